@@ -0,0 +1,80 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrWorkerIDInUse means that the worker ID is held by another process.
+var ErrWorkerIDInUse = errors.New("worker id is already in use")
+
+// WorkerIDAllocator allocates a worker ID which is unique among processes sharing the same backend.
+type WorkerIDAllocator interface {
+	// Allocate allocates a worker ID and holds it until ctx is done.
+	// The returned channel receives an error when the worker ID is lost,
+	// and is closed after the worker ID is released.
+	Allocate(ctx context.Context) (uint, <-chan error, error)
+}
+
+// WorkerIDRegisterer is a WorkerIDAllocator which can also hold a specified worker ID.
+type WorkerIDRegisterer interface {
+	WorkerIDAllocator
+
+	// Register holds workerID until ctx is done.
+	// It returns ErrWorkerIDInUse when workerID is held by another process.
+	Register(ctx context.Context, workerID uint) (<-chan error, error)
+}
+
+func validateWorkerIDRange(min, max uint) error {
+	if min > max {
+		return errors.New("max worker id must be larger than min worker id")
+	}
+	if max > workerIDMask {
+		return fmt.Errorf("max worker id must be smaller than %d", workerIDMask+1)
+	}
+	return nil
+}
+
+// allocateInRange calls try for each worker ID between min and max from a random position,
+// and returns the first worker ID which try succeeded to hold.
+func allocateInRange(ctx context.Context, min, max uint, try func(id uint) (bool, error)) (uint, error) {
+	size := max - min + 1
+	start := uint(rand.Int63n(int64(size)))
+	for i := uint(0); i < size; i++ {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		id := min + (start+i)%size
+		ok, err := try(id)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return id, nil
+		}
+		log.Debugf("worker id %d is held by another process", id)
+	}
+	return 0, fmt.Errorf("no more available worker id between %d and %d", min, max)
+}
+
+// leaseMayExpire reports whether a lease of ttl may expire in the backend soon,
+// when the last successful renewal was sent at renewedAt.
+// The backend extends the lease after receiving the renewal, so renewedAt is never later than the extension.
+// It reports margin earlier than the expiration, not to issue IDs while another process can take the worker ID.
+func leaseMayExpire(renewedAt time.Time, ttl, margin time.Duration) bool {
+	return now().Sub(renewedAt) >= ttl-margin
+}
+
+// holdUntilDone returns a channel which is closed when ctx is done.
+// It is used by allocators which do not need to hold any lease.
+func holdUntilDone(ctx context.Context) <-chan error {
+	ch := make(chan error)
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,81 @@
+package katsubushi
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// IPAllocator derives a worker ID from an IPv4 address of a network interface.
+// The worker ID is calculated as (address & Mask) + Offset.
+type IPAllocator struct {
+	// Interface is a name of the network interface. e.g. eth0
+	Interface string
+	Mask      uint
+	Offset    uint
+
+	// Registerer is optional. When it is set, the derived worker ID is
+	// registered to it to detect collisions with other processes.
+	Registerer WorkerIDRegisterer
+}
+
+// NewIPAllocator creates IPAllocator. The default mask covers all of WorkerIDBits.
+func NewIPAllocator(iface string) *IPAllocator {
+	return &IPAllocator{
+		Interface: iface,
+		Mask:      workerIDMask,
+	}
+}
+
+// Allocate derives a worker ID from the IPv4 address of the interface.
+func (a *IPAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	ip, err := interfaceIPv4(a.Interface)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := WorkerIDFromIP(ip, a.Mask, a.Offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	log.Infof("worker id %d is derived from %s (%s)", id, ip, a.Interface)
+	if a.Registerer == nil {
+		return id, holdUntilDone(ctx), nil
+	}
+	ch, err := a.Registerer.Register(ctx, id)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to register worker id %d derived from %s: %w", id, ip, err)
+	}
+	return id, ch, nil
+}
+
+// WorkerIDFromIP calculates a worker ID as (ip & mask) + offset.
+// ip must be an IPv4 address.
+func WorkerIDFromIP(ip net.IP, mask, offset uint) (uint, error) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return 0, fmt.Errorf("%s is not an IPv4 address", ip)
+	}
+	id := uint(binary.BigEndian.Uint32(ip4))&mask + offset
+	if id > workerIDMask {
+		return 0, fmt.Errorf("worker id %d derived from %s exceeds %d bits: %w", id, ip, WorkerIDBits, ErrInvalidWorkerID)
+	}
+	return id, nil
+}
+
+func interfaceIPv4(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find network interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of %s: %w", name, err)
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.To4(), nil
+		}
+	}
+	return nil, fmt.Errorf("no IPv4 address found on %s", name)
+}
@@ -0,0 +1,98 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestWorkerIDFromIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		mask   uint
+		offset uint
+		id     uint
+		err    bool
+	}{
+		{ip: "10.0.1.23", mask: 0xff, offset: 0, id: 23},
+		{ip: "10.0.1.23", mask: 0x3ff, offset: 0, id: 256 + 23},
+		{ip: "10.0.1.23", mask: 0xff, offset: 100, id: 123},
+		{ip: "10.0.3.255", mask: 0x3ff, offset: 0, id: 1023},
+		{ip: "10.0.3.255", mask: 0x3ff, offset: 1, err: true},
+		{ip: "10.0.255.255", mask: 0xffff, offset: 0, err: true},
+		{ip: "::1", mask: 0xff, offset: 0, err: true},
+	}
+	for _, tt := range tests {
+		id, err := WorkerIDFromIP(net.ParseIP(tt.ip), tt.mask, tt.offset)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s mask %x offset %d: must be error but got %d", tt.ip, tt.mask, tt.offset, id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s mask %x offset %d: unexpected error %s", tt.ip, tt.mask, tt.offset, err)
+			continue
+		}
+		if id != tt.id {
+			t.Errorf("%s mask %x offset %d: expected %d got %d", tt.ip, tt.mask, tt.offset, tt.id, id)
+		}
+	}
+}
+
+func loopbackInterface(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			continue
+		}
+		if _, err := interfaceIPv4(iface.Name); err == nil {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface with IPv4 address")
+	return ""
+}
+
+func TestIPAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := NewIPAllocator(loopbackInterface(t))
+	a.Mask = 0xff
+	a.Offset = 10
+	id, ch, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 127.0.0.1
+	if id != 11 {
+		t.Errorf("unexpected worker id %d", id)
+	}
+	cancel()
+	if _, more := <-ch; more {
+		t.Error("channel must be closed after canceled")
+	}
+}
+
+func TestIPAllocatorCollision(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	iface := loopbackInterface(t)
+	reg := newTestRedisAllocator(t, 1, 1023)
+
+	a1 := NewIPAllocator(iface)
+	a1.Registerer = reg
+	if _, _, err := a1.Allocate(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	a2 := NewIPAllocator(iface)
+	a2.Registerer = reg
+	_, _, err := a2.Allocate(ctx)
+	if !errors.Is(err, ErrWorkerIDInUse) {
+		t.Errorf("collision must be detected: %v", err)
+	}
+}
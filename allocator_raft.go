@@ -0,0 +1,841 @@
+package katsubushi
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+	raftboltdb "github.com/hashicorp/raft-boltdb/v2"
+)
+
+// RaftAllocator allocates a worker ID by consensus among katsubushi processes using Raft.
+// Each process is a member of the Raft cluster, so no external service is required.
+//
+// The listener is shared by Raft and the RPC to forward requests to the leader.
+// Connections are authenticated by RaftConfig.Secret shared among members.
+// Its address is advertised to other members as RaftConfig.Advertise, the entry of Peers resolved to the listener,
+// or the address of the listener, so it must be reachable from other members.
+type RaftAllocator struct {
+	MinWorkerID uint
+	MaxWorkerID uint
+
+	// LeaseDuration is the duration of a lease.
+	// The lease is renewed every RenewInterval while holding it,
+	// and regarded as lost when it is not renewed until RenewInterval before it expires.
+	LeaseDuration time.Duration
+	RenewInterval time.Duration
+
+	owner     string
+	addr      string
+	raft      *raft.Raft
+	fsm       *raftFSM
+	layer     *raftStreamLayer
+	transport *raft.NetworkTransport
+	store     io.Closer
+}
+
+// RaftConfig is a configuration of the Raft cluster of RaftAllocator.
+type RaftConfig struct {
+	// Peers is a list of addresses of initial members.
+	// When the advertised address is included in Peers, the process bootstraps the cluster with Peers.
+	// Otherwise, it joins the existing cluster via Peers.
+	Peers []string
+
+	// Secret is shared among members to authenticate connections of Raft and the RPC.
+	Secret []byte
+
+	// DataDir is a directory to store the log and snapshots of Raft, to keep leases while a quorum restarts.
+	// When empty, they are kept in memory.
+	DataDir string
+
+	// Advertise is the address advertised to other members.
+	// When empty, it is the entry of Peers whose host and port are resolved to the listener,
+	// or the address of the listener when no entry is resolved to it.
+	Advertise string
+
+	// HeartbeatTimeout and ElectionTimeout are passed to raft.Config. Zero means the default of raft.
+	HeartbeatTimeout time.Duration
+	ElectionTimeout  time.Duration
+}
+
+const (
+	raftRPCTypeRaft byte = iota + 1
+	raftRPCTypeForward
+)
+
+var raftRPCTimeout = 10 * time.Second
+
+// raftMaxClockSkew is the maximum difference of a timestamp of authentication from the local clock,
+// not to accept replayed ones.
+const raftMaxClockSkew = 30 * time.Second
+
+var errNoRaftLeader = errors.New("no raft leader")
+
+// NewRaftAllocator creates RaftAllocator and starts a Raft member on l.
+func NewRaftAllocator(l net.Listener, rc RaftConfig, min, max uint) (*RaftAllocator, error) {
+	if err := validateWorkerIDRange(min, max); err != nil {
+		return nil, err
+	}
+	if len(rc.Secret) == 0 {
+		return nil, errors.New("raft secret is required")
+	}
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	addr := rc.Advertise
+	if addr == "" {
+		addr = localPeer(rc.Peers, l.Addr())
+	}
+	if addr == "" {
+		addr = l.Addr().String()
+	}
+	a := &RaftAllocator{
+		MinWorkerID:   min,
+		MaxWorkerID:   max,
+		LeaseDuration: 60 * time.Second,
+		RenewInterval: 10 * time.Second,
+		owner:         u.String(),
+		addr:          addr,
+		fsm:           newRaftFSM(),
+	}
+	hlog := hclog.New(&hclog.LoggerOptions{
+		Name:   "raft",
+		Level:  hclog.Warn,
+		Output: StdLogger().Writer(),
+	})
+
+	a.layer = newRaftStreamLayer(l, rc.Secret, a.serveRPC)
+	a.transport = raft.NewNetworkTransportWithConfig(&raft.NetworkTransportConfig{
+		Stream:  a.layer,
+		MaxPool: 3,
+		Timeout: raftRPCTimeout,
+		Logger:  hlog,
+	})
+
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(a.addr)
+	conf.Logger = hlog
+	if rc.HeartbeatTimeout > 0 {
+		conf.HeartbeatTimeout = rc.HeartbeatTimeout
+		conf.LeaderLeaseTimeout = rc.HeartbeatTimeout
+	}
+	if rc.ElectionTimeout > 0 {
+		conf.ElectionTimeout = rc.ElectionTimeout
+	}
+	logs, stable, snaps, err := a.openStore(rc.DataDir, hlog)
+	if err != nil {
+		a.transport.Close()
+		return nil, err
+	}
+	existing, err := raft.HasExistingState(logs, stable, snaps)
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+	a.raft, err = raft.NewRaft(conf, a.fsm, logs, stable, snaps, a.transport)
+	if err != nil {
+		a.transport.Close()
+		if a.store != nil {
+			a.store.Close()
+		}
+		return nil, err
+	}
+	if existing {
+		// restarted as a member of the cluster
+		log.Infof("restored the raft state from %s", rc.DataDir)
+		return a, nil
+	}
+
+	var servers []raft.Server
+	member := false
+	for _, peer := range rc.Peers {
+		if peer == a.addr {
+			member = true
+		}
+		servers = append(servers, raft.Server{ID: raft.ServerID(peer), Address: raft.ServerAddress(peer)})
+	}
+	if member {
+		// all initial members bootstrap with the same configuration
+		if err := a.raft.BootstrapCluster(raft.Configuration{Servers: servers}).Error(); err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+			a.Close()
+			return nil, err
+		}
+	} else {
+		go a.join(rc.Peers)
+	}
+	return a, nil
+}
+
+// openStore opens stores of Raft in dir, or in memory when dir is empty.
+func (a *RaftAllocator) openStore(dir string, hlog hclog.Logger) (raft.LogStore, raft.StableStore, raft.SnapshotStore, error) {
+	if dir == "" {
+		store := raft.NewInmemStore()
+		return store, store, raft.NewInmemSnapshotStore(), nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, nil, err
+	}
+	snaps, err := raft.NewFileSnapshotStoreWithLogger(dir, 2, hlog)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	store, err := raftboltdb.NewBoltStore(filepath.Join(dir, "raft.db"))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open the raft store: %w", err)
+	}
+	a.store = store
+	return store, store, snaps, nil
+}
+
+// localPeer returns the entry of peers whose host and port are resolved to addr of the listener, or empty.
+func localPeer(peers []string, addr net.Addr) string {
+	ta, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	local := []net.IP{ta.IP}
+	if ta.IP.IsUnspecified() {
+		// listening on all interfaces
+		addrs, err := net.InterfaceAddrs()
+		if err != nil {
+			log.Warnf("failed to get addresses of interfaces: %s", err)
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok {
+				local = append(local, ipnet.IP)
+			}
+		}
+	}
+	for _, peer := range peers {
+		host, port, err := net.SplitHostPort(peer)
+		if err != nil || host == "" || port != strconv.Itoa(ta.Port) {
+			continue
+		}
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			log.Debugf("failed to resolve raft peer %s: %s", peer, err)
+			continue
+		}
+		for _, ip := range ips {
+			for _, l := range local {
+				if ip.Equal(l) {
+					return peer
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// Addr returns the address of the Raft member.
+func (a *RaftAllocator) Addr() string {
+	return a.addr
+}
+
+// Leader returns the address of the current leader of the Raft cluster.
+func (a *RaftAllocator) Leader() string {
+	addr, _ := a.raft.LeaderWithID()
+	return string(addr)
+}
+
+// Close stops the Raft member.
+func (a *RaftAllocator) Close() error {
+	var err error
+	if a.raft != nil {
+		err = a.raft.Shutdown().Error()
+	}
+	a.transport.Close()
+	if a.store != nil {
+		a.store.Close()
+	}
+	return err
+}
+
+// Allocate leases an unused worker ID between MinWorkerID and MaxWorkerID.
+func (a *RaftAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	holder := newLease(ctx, 0)
+	leasedAt := now()
+	res, err := a.apply(ctx, raftCommand{
+		Op:     "allocate",
+		Owner:  a.owner,
+		Min:    a.MinWorkerID,
+		Max:    a.MaxWorkerID,
+		Start:  uint(rand.Int63n(int64(a.MaxWorkerID - a.MinWorkerID + 1))),
+		Lease:  a.LeaseDuration.Milliseconds(),
+		Holder: &holder,
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	log.Infof("leased worker id %d by raft", res.WorkerID)
+	return res.WorkerID, a.hold(ctx, res.WorkerID, holder, leasedAt), nil
+}
+
+// Register leases the specified worker ID.
+func (a *RaftAllocator) Register(ctx context.Context, id uint) (<-chan error, error) {
+	if id > workerIDMask {
+		return nil, ErrInvalidWorkerID
+	}
+	holder := newLease(ctx, id)
+	leasedAt := now()
+	_, err := a.apply(ctx, raftCommand{
+		Op:       "register",
+		Owner:    a.owner,
+		WorkerID: id,
+		Lease:    a.LeaseDuration.Milliseconds(),
+		Holder:   &holder,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("leased worker id %d by raft", id)
+	return a.hold(ctx, id, holder, leasedAt), nil
+}
+
+// LeaseHistory returns leases of workerID recorded in the state of this member.
+func (a *RaftAllocator) LeaseHistory(ctx context.Context, workerID uint) ([]Lease, error) {
+	return a.fsm.leaseHistory(workerID), nil
+}
+
+// RaftRegistry looks up the history of leases from members of the Raft cluster of RaftAllocator without joining it.
+type RaftRegistry struct {
+	// Peers is a list of addresses of members.
+	Peers []string
+
+	// Secret is shared among members to authenticate connections.
+	Secret []byte
+}
+
+// LeaseHistory returns leases of workerID recorded in the state of the leader via the first member responded in Peers.
+func (r *RaftRegistry) LeaseHistory(ctx context.Context, workerID uint) ([]Lease, error) {
+	err := errors.New("no raft peers")
+	for _, peer := range r.Peers {
+		var res raftRPCResponse
+		err = raftCall(ctx, r.Secret, peer, raftRPCRequest{Op: "history", WorkerID: workerID}, &res)
+		if err == nil && res.Error != "" {
+			err = errors.New(res.Error)
+		}
+		if err == nil {
+			return res.History, nil
+		}
+		log.Debugf("failed to get history of worker id %d from %s: %s", workerID, peer, err)
+	}
+	return nil, fmt.Errorf("failed to get history of worker id %d: %w", workerID, err)
+}
+
+// hold renews the lease of id which was sent at leasedAt until ctx is done.
+// holder is recorded again when the lease is taken over after its expiration.
+func (a *RaftAllocator) hold(ctx context.Context, id uint, holder Lease, leasedAt time.Time) <-chan error {
+	ch := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(a.RenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.release(id)
+				return
+			case <-ticker.C:
+				// the leader stamps the expiration after receiving the renewal
+				sentAt := now()
+				_, err := a.apply(ctx, raftCommand{
+					Op:       "renew",
+					Owner:    a.owner,
+					WorkerID: id,
+					Lease:    a.LeaseDuration.Milliseconds(),
+					Holder:   &holder,
+				})
+				if err == nil {
+					leasedAt = sentAt
+					continue
+				}
+				if errors.Is(err, ErrWorkerIDInUse) {
+					ch <- err
+					return
+				}
+				log.Warnf("failed to renew a lease of worker id %d: %s", id, err)
+				if leaseMayExpire(leasedAt, a.LeaseDuration, a.RenewInterval) {
+					ch <- fmt.Errorf("lease of worker id %d may be expired: %w", id, err)
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
+
+func (a *RaftAllocator) release(id uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := a.apply(ctx, raftCommand{Op: "release", Owner: a.owner, WorkerID: id}); err != nil {
+		log.Warnf("failed to release a lease of worker id %d: %s", id, err)
+		return
+	}
+	log.Infof("released a lease of worker id %d", id)
+}
+
+func (a *RaftAllocator) join(peers []string) {
+	for {
+		for _, peer := range peers {
+			err := a.forward(context.Background(), peer, raftRPCRequest{Op: "join", Addr: a.addr})
+			if err == nil {
+				log.Infof("joined the raft cluster via %s", peer)
+				return
+			}
+			log.Debugf("failed to join the raft cluster via %s: %s", peer, err)
+		}
+		if a.raft.State() == raft.Shutdown {
+			return
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// apply applies cmd by the leader.
+func (a *RaftAllocator) apply(ctx context.Context, cmd raftCommand) (raftResult, error) {
+	req := raftRPCRequest{Op: "apply", Command: &cmd}
+	var res raftRPCResponse
+	var err error
+	if a.raft.State() == raft.Leader {
+		res = a.handleRPC(req)
+	} else if leader := a.Leader(); leader == "" {
+		return raftResult{}, errNoRaftLeader
+	} else if err = a.call(ctx, leader, req, &res); err != nil {
+		return raftResult{}, err
+	}
+	if res.Error != "" {
+		return raftResult{}, errors.New(res.Error)
+	}
+	if res.Result.InUse {
+		return raftResult{}, fmt.Errorf("worker id %d: %w", res.Result.WorkerID, ErrWorkerIDInUse)
+	}
+	if res.Result.Error != "" {
+		return raftResult{}, errors.New(res.Result.Error)
+	}
+	return res.Result, nil
+}
+
+// forward sends req to addr which forwards it to the leader if needed.
+func (a *RaftAllocator) forward(ctx context.Context, addr string, req raftRPCRequest) error {
+	var res raftRPCResponse
+	if err := a.call(ctx, addr, req, &res); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return errors.New(res.Error)
+	}
+	return nil
+}
+
+func (a *RaftAllocator) call(ctx context.Context, addr string, req raftRPCRequest, res *raftRPCResponse) error {
+	return raftCall(ctx, a.layer.secret, addr, req, res)
+}
+
+// raftCall sends req to the RPC of the member of addr, authenticated by secret.
+func raftCall(ctx context.Context, secret []byte, addr string, req raftRPCRequest, res *raftRPCResponse) error {
+	d := net.Dialer{Timeout: raftRPCTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(raftRPCTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	if _, err := conn.Write(raftHeader(secret, raftRPCTypeForward)); err != nil {
+		return err
+	}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return err
+	}
+	return json.NewDecoder(bufio.NewReader(conn)).Decode(res)
+}
+
+func (a *RaftAllocator) serveRPC(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(raftRPCTimeout))
+	var req raftRPCRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		log.Debugf("invalid raft rpc from %s: %s", conn.RemoteAddr(), err)
+		return
+	}
+	var res raftRPCResponse
+	leader := a.Leader()
+	if req.Op == "history" && (a.raft.State() == raft.Leader || leader == "") {
+		// the latest state on the leader, or the state of this member without the leader
+		res.History = a.fsm.leaseHistory(req.WorkerID)
+	} else if a.raft.State() == raft.Leader {
+		res = a.handleRPC(req)
+	} else if leader == "" {
+		res.Error = errNoRaftLeader.Error()
+	} else if req.Op == "join" || req.Op == "history" {
+		// forwarded to the leader, because a new member may not know it yet
+		if err := a.call(context.Background(), leader, req, &res); err != nil {
+			res.Error = err.Error()
+		}
+	} else {
+		res.Error = fmt.Sprintf("not a raft leader, the leader is %s", leader)
+	}
+	json.NewEncoder(conn).Encode(res)
+}
+
+// handleRPC handles req on the leader.
+func (a *RaftAllocator) handleRPC(req raftRPCRequest) raftRPCResponse {
+	var res raftRPCResponse
+	switch req.Op {
+	case "apply":
+		cmd := *req.Command
+		// the leader decides the time to keep the state machine deterministic
+		cmd.Now = now().UnixMilli()
+		b, err := json.Marshal(cmd)
+		if err != nil {
+			res.Error = err.Error()
+			break
+		}
+		f := a.raft.Apply(b, raftRPCTimeout)
+		if err := f.Error(); err != nil {
+			res.Error = err.Error()
+			break
+		}
+		res.Result = f.Response().(raftResult)
+	case "join":
+		id := raft.ServerID(req.Addr)
+		if err := a.raft.AddVoter(id, raft.ServerAddress(req.Addr), 0, raftRPCTimeout).Error(); err != nil {
+			res.Error = err.Error()
+			break
+		}
+		log.Infof("%s joined the raft cluster", req.Addr)
+	default:
+		res.Error = fmt.Sprintf("unknown raft rpc %q", req.Op)
+	}
+	return res
+}
+
+type raftRPCRequest struct {
+	Op       string       `json:"op"`
+	Command  *raftCommand `json:"command,omitempty"`
+	Addr     string       `json:"addr,omitempty"`
+	WorkerID uint         `json:"worker_id,omitempty"`
+}
+
+type raftRPCResponse struct {
+	Result  raftResult `json:"result"`
+	History []Lease    `json:"history,omitempty"`
+	Error   string     `json:"error,omitempty"`
+}
+
+type raftCommand struct {
+	Op       string `json:"op"`
+	Owner    string `json:"owner"`
+	WorkerID uint   `json:"worker_id"`
+	Min      uint   `json:"min"`
+	Max      uint   `json:"max"`
+	Start    uint   `json:"start"`
+	Lease    int64  `json:"lease"` // milliseconds
+	Now      int64  `json:"now"`   // unix milliseconds
+	Holder   *Lease `json:"holder,omitempty"`
+}
+
+type raftResult struct {
+	WorkerID uint   `json:"worker_id"`
+	InUse    bool   `json:"in_use,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type raftLease struct {
+	Owner   string `json:"owner"`
+	Expires int64  `json:"expires"` // unix milliseconds
+}
+
+// raftMaxHistory is the maximum number of leases recorded for each worker ID in the state of Raft.
+const raftMaxHistory = 100
+
+// raftHistory is a lease in the history recorded with the owner.
+type raftHistory struct {
+	Owner string `json:"owner"`
+	Lease
+}
+
+// raftFSM is a state machine of leases of worker IDs.
+type raftFSM struct {
+	mu      sync.Mutex
+	leases  map[uint]raftLease
+	history map[uint][]raftHistory
+}
+
+func newRaftFSM() *raftFSM {
+	return &raftFSM{
+		leases:  map[uint]raftLease{},
+		history: map[uint][]raftHistory{},
+	}
+}
+
+// raftState is a snapshot of raftFSM.
+type raftState struct {
+	Leases  map[uint]raftLease     `json:"leases"`
+	History map[uint][]raftHistory `json:"history"`
+}
+
+func (f *raftFSM) Apply(l *raft.Log) interface{} {
+	var cmd raftCommand
+	if err := json.Unmarshal(l.Data, &cmd); err != nil {
+		return raftResult{Error: err.Error()}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	free := func(id uint) bool {
+		l, exists := f.leases[id]
+		return !exists || l.Expires < cmd.Now
+	}
+	switch cmd.Op {
+	case "allocate":
+		size := cmd.Max - cmd.Min + 1
+		for i := uint(0); i < size; i++ {
+			id := cmd.Min + (cmd.Start+i)%size
+			if free(id) {
+				f.grant(id, cmd)
+				return raftResult{WorkerID: id}
+			}
+		}
+		return raftResult{Error: fmt.Sprintf("no more available worker id between %d and %d", cmd.Min, cmd.Max)}
+	case "register":
+		if !free(cmd.WorkerID) {
+			return raftResult{WorkerID: cmd.WorkerID, InUse: true}
+		}
+		f.grant(cmd.WorkerID, cmd)
+	case "renew":
+		// a lease lost by expiration is taken again unless another process holds it
+		l, exists := f.leases[cmd.WorkerID]
+		if !free(cmd.WorkerID) && l.Owner != cmd.Owner {
+			return raftResult{WorkerID: cmd.WorkerID, InUse: true}
+		}
+		if exists && l.Owner == cmd.Owner {
+			f.leases[cmd.WorkerID] = raftLease{Owner: cmd.Owner, Expires: cmd.Now + cmd.Lease}
+		} else {
+			f.grant(cmd.WorkerID, cmd)
+		}
+	case "release":
+		if l, exists := f.leases[cmd.WorkerID]; exists && l.Owner == cmd.Owner {
+			f.endHistory(cmd.WorkerID, cmd.Owner, cmd.Now)
+			delete(f.leases, cmd.WorkerID)
+		}
+	default:
+		return raftResult{Error: fmt.Sprintf("unknown raft command %q", cmd.Op)}
+	}
+	return raftResult{WorkerID: cmd.WorkerID}
+}
+
+// grant leases id to the owner of cmd, and records the holder of cmd in the history.
+func (f *raftFSM) grant(id uint, cmd raftCommand) {
+	if prev, exists := f.leases[id]; exists {
+		// the previous lease was expired
+		f.endHistory(id, prev.Owner, prev.Expires)
+	}
+	f.leases[id] = raftLease{Owner: cmd.Owner, Expires: cmd.Now + cmd.Lease}
+	if cmd.Holder == nil {
+		return
+	}
+	l := *cmd.Holder
+	l.WorkerID = id
+	l.Start = time.UnixMilli(cmd.Now)
+	l.End = nil
+	hs := append(f.history[id], raftHistory{Owner: cmd.Owner, Lease: l})
+	if len(hs) > raftMaxHistory {
+		hs = hs[len(hs)-raftMaxHistory:]
+	}
+	f.history[id] = hs
+}
+
+// endHistory records the end of the latest lease of id by owner.
+func (f *raftFSM) endHistory(id uint, owner string, end int64) {
+	hs := f.history[id]
+	if n := len(hs); n > 0 && hs[n-1].Owner == owner && hs[n-1].End == nil {
+		t := time.UnixMilli(end)
+		hs[n-1].End = &t
+	}
+}
+
+func (f *raftFSM) leaseHistory(id uint) []Lease {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	leases := make([]Lease, 0, len(f.history[id]))
+	for _, h := range f.history[id] {
+		leases = append(leases, h.Lease)
+	}
+	return leases
+}
+
+func (f *raftFSM) Snapshot() (raft.FSMSnapshot, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.Marshal(raftState{Leases: f.leases, History: f.history})
+	if err != nil {
+		return nil, err
+	}
+	return raftSnapshot(b), nil
+}
+
+func (f *raftFSM) Restore(r io.ReadCloser) error {
+	defer r.Close()
+	st := raftState{
+		Leases:  map[uint]raftLease{},
+		History: map[uint][]raftHistory{},
+	}
+	if err := json.NewDecoder(r).Decode(&st); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.leases = st.Leases
+	f.history = st.History
+	return nil
+}
+
+type raftSnapshot []byte
+
+func (s raftSnapshot) Persist(sink raft.SnapshotSink) error {
+	if _, err := sink.Write(s); err != nil {
+		sink.Cancel()
+		return err
+	}
+	return sink.Close()
+}
+
+func (s raftSnapshot) Release() {}
+
+// raftStreamLayer shares a listener between Raft and the RPC by the first byte of connections.
+// The byte is followed by a timestamp in unix milliseconds and HMAC-SHA256 of them by the secret.
+type raftStreamLayer struct {
+	net.Listener
+	secret []byte
+	conns  chan net.Conn
+	closed chan struct{}
+	once   sync.Once
+}
+
+const raftHeaderSize = 1 + 8 + sha256.Size
+
+func newRaftStreamLayer(l net.Listener, secret []byte, rpc func(net.Conn)) *raftStreamLayer {
+	s := &raftStreamLayer{
+		Listener: l,
+		secret:   secret,
+		conns:    make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go s.dispatch(rpc)
+	return s
+}
+
+func (s *raftStreamLayer) dispatch(rpc func(net.Conn)) {
+	for {
+		conn, err := s.Listener.Accept()
+		if err != nil {
+			s.Close()
+			return
+		}
+		go func() {
+			conn.SetReadDeadline(time.Now().Add(raftRPCTimeout))
+			b := make([]byte, raftHeaderSize)
+			if _, err := io.ReadFull(conn, b); err != nil {
+				conn.Close()
+				return
+			}
+			if err := s.verify(b); err != nil {
+				log.Warnf("unauthenticated raft connection from %s: %s", conn.RemoteAddr(), err)
+				conn.Close()
+				return
+			}
+			conn.SetReadDeadline(time.Time{})
+			switch b[0] {
+			case raftRPCTypeRaft:
+				select {
+				case s.conns <- conn:
+				case <-s.closed:
+					conn.Close()
+				}
+			case raftRPCTypeForward:
+				rpc(conn)
+			default:
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// raftHeader returns a header of a connection of typ authenticated by secret.
+func raftHeader(secret []byte, typ byte) []byte {
+	b := make([]byte, 9, raftHeaderSize)
+	b[0] = typ
+	binary.BigEndian.PutUint64(b[1:], uint64(now().UnixMilli()))
+	h := hmac.New(sha256.New, secret)
+	h.Write(b)
+	return h.Sum(b)
+}
+
+// verify authenticates a header of a connection.
+func (s *raftStreamLayer) verify(b []byte) error {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(b[:9])
+	if !hmac.Equal(b[9:], h.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+	t := time.UnixMilli(int64(binary.BigEndian.Uint64(b[1:9])))
+	if d := now().Sub(t); d > raftMaxClockSkew || d < -raftMaxClockSkew {
+		return fmt.Errorf("timestamp is off by %s", d)
+	}
+	return nil
+}
+
+// Accept returns connections for Raft.
+func (s *raftStreamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-s.conns:
+		return conn, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener.
+func (s *raftStreamLayer) Close() error {
+	var err error
+	s.once.Do(func() {
+		close(s.closed)
+		err = s.Listener.Close()
+	})
+	return err
+}
+
+// Dial connects to a Raft member.
+func (s *raftStreamLayer) Dial(addr raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", string(addr), timeout)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(raftHeader(s.secret, raftRPCTypeRaft)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
@@ -0,0 +1,348 @@
+package katsubushi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func newTestRaftCluster(t *testing.T, n int, min, max uint) []*RaftAllocator {
+	var listeners []net.Listener
+	var peers []string
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		peers = append(peers, l.Addr().String())
+	}
+	var nodes []*RaftAllocator
+	for _, l := range listeners {
+		nodes = append(nodes, newTestRaftAllocator(t, l, peers, min, max))
+	}
+	waitFor(t, func() bool {
+		for _, a := range nodes {
+			if a.Leader() == "" {
+				return false
+			}
+		}
+		return true
+	}, "raft leader must be elected")
+	return nodes
+}
+
+func TestRaftAllocatorResolvePeers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var listeners []net.Listener
+	var peers []string
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, l)
+		// by hostname
+		peers = append(peers, fmt.Sprintf("localhost:%d", l.Addr().(*net.TCPAddr).Port))
+	}
+	var nodes []*RaftAllocator
+	for i, l := range listeners {
+		a := newTestRaftAllocator(t, l, peers, 1, 3)
+		if a.Addr() != peers[i] {
+			t.Errorf("the entry of peers must be advertised: %s", a.Addr())
+		}
+		nodes = append(nodes, a)
+	}
+	waitFor(t, func() bool { return nodes[0].Leader() != "" }, "raft leader must be elected")
+	if _, _, err := nodes[0].Allocate(ctx); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalPeer(t *testing.T) {
+	l, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+	peers := []string{
+		"192.0.2.1:" + strconv.Itoa(port),
+		"127.0.0.1:" + strconv.Itoa(port+1),
+		"127.0.0.1:" + strconv.Itoa(port),
+	}
+	if p := localPeer(peers, l.Addr()); p != peers[2] {
+		t.Errorf("unexpected local peer %q", p)
+	}
+	if p := localPeer(peers[:2], l.Addr()); p != "" {
+		t.Errorf("unexpected local peer %q", p)
+	}
+}
+
+func newTestRaftAllocator(t *testing.T, l net.Listener, peers []string, min, max uint) *RaftAllocator {
+	return newTestRaftAllocatorIn(t, l, peers, "", min, max)
+}
+
+func newTestRaftAllocatorIn(t *testing.T, l net.Listener, peers []string, dir string, min, max uint) *RaftAllocator {
+	a, err := NewRaftAllocator(l, RaftConfig{
+		Peers:            peers,
+		Secret:           []byte("secret"),
+		DataDir:          dir,
+		HeartbeatTimeout: 200 * time.Millisecond,
+		ElectionTimeout:  200 * time.Millisecond,
+	}, min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.RenewInterval = 100 * time.Millisecond
+	t.Cleanup(func() { a.Close() })
+	return a
+}
+
+func TestRaftAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestRaftCluster(t, 3, 10, 12)
+
+	ids := map[uint]bool{}
+	for _, a := range nodes {
+		id, _, err := a.Allocate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id < 10 || 12 < id {
+			t.Errorf("worker id %d is out of range", id)
+		}
+		if ids[id] {
+			t.Errorf("worker id %d is allocated twice", id)
+		}
+		ids[id] = true
+	}
+	if _, _, err := nodes[0].Allocate(ctx); err == nil {
+		t.Error("allocation must fail when all worker ids are used")
+	}
+}
+
+func TestRaftAllocatorRelease(t *testing.T) {
+	nodes := newTestRaftCluster(t, 3, 5, 5)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	id, ch, err := nodes[1].Allocate(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := nodes[2].Register(context.Background(), id); !errors.Is(err, ErrWorkerIDInUse) {
+		t.Errorf("worker id %d must be in use: %v", id, err)
+	}
+	cancel1()
+	for range ch {
+	}
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	if _, err := nodes[2].Register(ctx2, id); err != nil {
+		t.Errorf("worker id %d must be released: %v", id, err)
+	}
+}
+
+func TestRaftAllocatorJoin(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestRaftCluster(t, 3, 1, 4)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a new node knows one of the members only
+	a := newTestRaftAllocator(t, l, []string{nodes[0].Addr()}, 1, 4)
+	waitFor(t, func() bool { return a.Leader() != "" }, "new node must join the cluster")
+
+	ids := map[uint]bool{}
+	for _, a := range append(nodes, a) {
+		id, _, err := a.Allocate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids[id] {
+			t.Errorf("worker id %d is allocated twice", id)
+		}
+		ids[id] = true
+	}
+}
+
+func TestRaftAllocatorLeaderFailure(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestRaftCluster(t, 3, 1, 3)
+
+	var leader, follower *RaftAllocator
+	for _, a := range nodes {
+		if a.Leader() == a.Addr() {
+			leader = a
+		} else {
+			follower = a
+		}
+	}
+	id, ch, err := follower.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leader.Close()
+	waitFor(t, func() bool {
+		l := follower.Leader()
+		return l != "" && l != leader.Addr()
+	}, "new leader must be elected")
+
+	// the lease is kept by the new leader
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case err := <-ch:
+		t.Fatalf("lease must not be lost: %v", err)
+	default:
+	}
+	for _, a := range nodes {
+		if a == leader {
+			continue
+		}
+		if _, err := a.Register(ctx, id); !errors.Is(err, ErrWorkerIDInUse) {
+			t.Errorf("worker id %d must be in use: %v", id, err)
+		}
+	}
+}
+
+func TestRaftAllocatorLeaseMayExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	nodes := newTestRaftCluster(t, 3, 1, 3)
+	a := nodes[0]
+	a.LeaseDuration = time.Hour
+	setClock := stopTestClock(t)
+
+	_, ch, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewals fail without the quorum
+	nodes[1].Close()
+	nodes[2].Close()
+
+	setClock(a.LeaseDuration - 5*a.RenewInterval)
+	select {
+	case err := <-ch:
+		t.Fatalf("the lease must be held until RenewInterval before LeaseDuration: %v", err)
+	case <-time.After(time.Second):
+	}
+
+	setClock(a.LeaseDuration - a.RenewInterval/2)
+	select {
+	case err, ok := <-ch:
+		if !ok || err == nil {
+			t.Error("the lease must be lost before LeaseDuration")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("the lease must be lost before LeaseDuration")
+	}
+}
+
+func TestRaftAllocatorRestart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var peers, dirs []string
+	var nodes []*RaftAllocator
+	start := func(listen bool) {
+		var listeners []net.Listener
+		for i := 0; i < 3; i++ {
+			addr := "127.0.0.1:0"
+			if !listen {
+				addr = peers[i]
+			}
+			l, err := net.Listen("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listeners = append(listeners, l)
+			if listen {
+				peers = append(peers, l.Addr().String())
+				dirs = append(dirs, t.TempDir())
+			}
+		}
+		nodes = nil
+		for i, l := range listeners {
+			nodes = append(nodes, newTestRaftAllocatorIn(t, l, peers, dirs[i], 1, 3))
+		}
+		waitFor(t, func() bool { return nodes[0].Leader() != "" }, "raft leader must be elected")
+	}
+	start(true)
+	id, _, err := nodes[0].Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// all members restart
+	for _, a := range nodes {
+		a.Close()
+	}
+	start(false)
+	for _, a := range nodes {
+		if _, err := a.Register(ctx, id); !errors.Is(err, ErrWorkerIDInUse) {
+			t.Errorf("lease of worker id %d must be kept after restart: %v", id, err)
+		}
+	}
+}
+
+func TestRaftFSMRenew(t *testing.T) {
+	f := newRaftFSM()
+	apply := func(cmd raftCommand) raftResult {
+		b, _ := json.Marshal(cmd)
+		return f.Apply(&raft.Log{Data: b}).(raftResult)
+	}
+	// the lease is lost
+	if res := apply(raftCommand{Op: "renew", Owner: "a", WorkerID: 1, Lease: 1000, Now: 1}); res.InUse {
+		t.Error("a lost lease must be taken again")
+	}
+	if res := apply(raftCommand{Op: "renew", Owner: "b", WorkerID: 1, Lease: 1000, Now: 2}); !res.InUse {
+		t.Error("a lease held by another owner must not be renewed")
+	}
+	if res := apply(raftCommand{Op: "renew", Owner: "b", WorkerID: 1, Lease: 1000, Now: 2000}); res.InUse {
+		t.Error("an expired lease must be taken")
+	}
+}
+
+func TestRaftAllocatorUnauthenticated(t *testing.T) {
+	nodes := newTestRaftCluster(t, 3, 1, 4)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRaftAllocator(l, RaftConfig{
+		Peers:  []string{nodes[0].Addr()},
+		Secret: []byte("wrong"),
+	}, 1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	time.Sleep(time.Second)
+	if a.Leader() != "" {
+		t.Error("a node with a wrong secret must not join the cluster")
+	}
+	if f := nodes[0].raft.GetConfiguration(); f.Error() != nil || len(f.Configuration().Servers) != 3 {
+		t.Errorf("unexpected members: %v", f.Configuration().Servers)
+	}
+
+	if _, err := NewRaftAllocator(l, RaftConfig{Peers: []string{nodes[0].Addr()}}, 1, 4); err == nil {
+		t.Error("secret must be required")
+	}
+}
@@ -0,0 +1,273 @@
+package katsubushi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fujiwara/raus"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// RedisAllocator allocates a worker ID using Redis.
+// Allocate is done by github.com/fujiwara/raus when raus supports the URL and the range.
+// Otherwise, such as TLS, Sentinel and Register, worker IDs are locked by the same keys as raus,
+// so it can share the same Redis namespace with raus.
+type RedisAllocator struct {
+	MinWorkerID uint
+	MaxWorkerID uint
+
+	// LockExpires is the expiration of the lock key.
+	// The lock is refreshed every HoldInterval while holding it,
+	// and regarded as lost when it is not refreshed until HoldInterval before LockExpires.
+	// Allocation by raus uses raus.LockExpires instead.
+	LockExpires  time.Duration
+	HoldInterval time.Duration
+
+	options *RedisOptions
+	uuid    string
+
+	// rausURL is the URL for raus, or empty when raus does not support it.
+	rausURL string
+}
+
+// NewRedisAllocator creates RedisAllocator.
+// See ParseRedisURL for the format of redisURL.
+func NewRedisAllocator(redisURL string, min, max uint) (*RedisAllocator, error) {
+	if err := validateWorkerIDRange(min, max); err != nil {
+		return nil, err
+	}
+	op, err := ParseRedisURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redis URL: %w", err)
+	}
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	a := &RedisAllocator{
+		MinWorkerID:  min,
+		MaxWorkerID:  max,
+		LockExpires:  raus.LockExpires,
+		HoldInterval: time.Second,
+		options:      op,
+		uuid:         u.String(),
+	}
+	// raus supports neither TLS, Sentinel, multiple cluster nodes nor a single worker ID
+	if op.TLSConfig == nil && op.MasterName == "" && len(op.Addrs) == 1 && min < max {
+		a.rausURL = redisURL
+	}
+	return a, nil
+}
+
+func (a *RedisAllocator) lockKey(id uint) string {
+	return fmt.Sprintf("%s:id:%d", a.options.Namespace, id)
+}
+
+func (a *RedisAllocator) historyKey(id uint) string {
+	return fmt.Sprintf("%s:history:%d", a.options.Namespace, id)
+}
+
+func (a *RedisAllocator) broadcastChannel() string {
+	return a.options.Namespace + ":broadcast"
+}
+
+// Allocate allocates an unused worker ID between MinWorkerID and MaxWorkerID.
+func (a *RedisAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	if a.rausURL != "" {
+		return a.allocateByRaus(ctx)
+	}
+	c := a.options.NewClient()
+	defer c.Close()
+
+	lockedAt := now()
+	id, err := allocateInRange(ctx, a.MinWorkerID, a.MaxWorkerID, func(id uint) (bool, error) {
+		return a.lock(ctx, c, id)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	log.Infof("got lock for worker id %d", id)
+	return id, a.hold(ctx, a.recordLease(ctx, c, newLease(ctx, id)), lockedAt), nil
+}
+
+func (a *RedisAllocator) allocateByRaus(ctx context.Context) (uint, <-chan error, error) {
+	r, err := raus.New(a.rausURL, a.MinWorkerID, a.MaxWorkerID)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, rch, err := r.Get(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	c := a.options.NewClient()
+	l := a.recordLease(ctx, c, newLease(ctx, id))
+	c.Close()
+
+	ch := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		// raus sends a fatal error when the lock is taken, or closes the channel after releasing it
+		err := <-rch
+		c := a.options.NewClient()
+		defer c.Close()
+		a.endLease(c, l)
+		if err != nil {
+			ch <- fmt.Errorf("lock of worker id %d is lost: %s: %w", id, err, ErrWorkerIDInUse)
+		}
+	}()
+	return id, ch, nil
+}
+
+// Register holds the specified worker ID.
+func (a *RedisAllocator) Register(ctx context.Context, id uint) (<-chan error, error) {
+	if id > workerIDMask {
+		return nil, ErrInvalidWorkerID
+	}
+	c := a.options.NewClient()
+	defer c.Close()
+
+	lockedAt := now()
+	ok, err := a.lock(ctx, c, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("worker id %d: %w", id, ErrWorkerIDInUse)
+	}
+	log.Infof("got lock for worker id %d", id)
+	return a.hold(ctx, a.recordLease(ctx, c, newLease(ctx, id)), lockedAt), nil
+}
+
+func (a *RedisAllocator) lock(ctx context.Context, c redis.UniversalClient, id uint) (bool, error) {
+	res := c.SetNX(ctx, a.lockKey(id), a.uuid, a.LockExpires)
+	if err := res.Err(); err != nil {
+		return false, fmt.Errorf("failed to get lock by SET NX: %w", err)
+	}
+	return res.Val(), nil
+}
+
+// recordLease saves l into the history hash. Failures are only logged because the history is informational.
+func (a *RedisAllocator) recordLease(ctx context.Context, c redis.UniversalClient, l Lease) Lease {
+	b, err := json.Marshal(l)
+	if err != nil {
+		log.Warnf("failed to encode a lease of worker id %d: %s", l.WorkerID, err)
+		return l
+	}
+	field := fmt.Sprintf("%s:%d", a.uuid, l.Start.UnixMilli())
+	if err := c.HSet(ctx, a.historyKey(l.WorkerID), field, b).Err(); err != nil {
+		log.Warnf("failed to record a lease of worker id %d: %s", l.WorkerID, err)
+	}
+	return l
+}
+
+func (a *RedisAllocator) endLease(c redis.UniversalClient, l Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	end := now()
+	l.End = &end
+	a.recordLease(ctx, c, l)
+}
+
+// LeaseHistory returns leases of workerID recorded in Redis.
+func (a *RedisAllocator) LeaseHistory(ctx context.Context, workerID uint) ([]Lease, error) {
+	c := a.options.NewClient()
+	defer c.Close()
+
+	vals, err := c.HVals(ctx, a.historyKey(workerID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("HVALS failed: %w", err)
+	}
+	leases := make([]Lease, 0, len(vals))
+	for _, v := range vals {
+		var l Lease
+		if err := json.Unmarshal([]byte(v), &l); err != nil {
+			log.Warnf("invalid lease of worker id %d: %s", workerID, err)
+			continue
+		}
+		leases = append(leases, l)
+	}
+	sortLeases(leases)
+	return leases, nil
+}
+
+// hold refreshes the lock of l which was sent at lockedAt until ctx is done.
+func (a *RedisAllocator) hold(ctx context.Context, l Lease, lockedAt time.Time) <-chan error {
+	id := l.WorkerID
+	ch := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		c := a.options.NewClient()
+		defer func() {
+			c.Close()
+		}()
+
+		ticker := time.NewTicker(a.HoldInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.release(c, id)
+				a.endLease(c, l)
+				return
+			case <-ticker.C:
+				sentAt := now()
+				err := a.refresh(ctx, c, id)
+				if err == nil {
+					lockedAt = sentAt
+					continue
+				}
+				if errors.Is(err, ErrWorkerIDInUse) {
+					a.endLease(c, l)
+					ch <- err
+					return
+				}
+				log.Warnf("failed to refresh a lock of worker id %d: %s", id, err)
+				if leaseMayExpire(lockedAt, a.LockExpires, a.HoldInterval) {
+					a.endLease(c, l)
+					ch <- fmt.Errorf("lock of worker id %d may be expired: %w", id, err)
+					return
+				}
+				c.Close()
+				c = a.options.NewClient()
+			}
+		}
+	}()
+	return ch
+}
+
+func (a *RedisAllocator) refresh(ctx context.Context, c redis.UniversalClient, id uint) error {
+	// broadcast for raus compatibility
+	payload := fmt.Sprintf("%s:%d", a.uuid, id)
+	if err := c.Publish(ctx, a.broadcastChannel(), payload).Err(); err != nil {
+		return fmt.Errorf("PUBLISH failed: %w", err)
+	}
+
+	pipe := c.TxPipeline()
+	getset := pipe.GetSet(ctx, a.lockKey(id), a.uuid)
+	pipe.Expire(ctx, a.lockKey(id), a.LockExpires)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("GETSET or EXPIRE failed: %w", err)
+	}
+	if v := getset.Val(); v != a.uuid {
+		return fmt.Errorf("lock of worker id %d is taken by %s: %w", id, v, ErrWorkerIDInUse)
+	}
+	return nil
+}
+
+func (a *RedisAllocator) release(c redis.UniversalClient, id uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if v, err := c.Get(ctx, a.lockKey(id)).Result(); err != nil || v != a.uuid {
+		// expired or taken by another process
+		return
+	}
+	if err := c.Del(ctx, a.lockKey(id)).Err(); err != nil {
+		log.Warnf("failed to release a lock of worker id %d: %s", id, err)
+		return
+	}
+	log.Infof("released a lock of worker id %d", id)
+}
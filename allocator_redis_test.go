@@ -0,0 +1,167 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fujiwara/raus"
+)
+
+func init() {
+	// not to wait for other processes of raus in tests
+	raus.SubscribeTimeout = 100 * time.Millisecond
+}
+
+func newTestRedisAllocator(t *testing.T, min, max uint) *RedisAllocator {
+	mr := miniredis.RunT(t)
+	a, err := NewRedisAllocator("redis://"+mr.Addr()+"/0?ns=test", min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.HoldInterval = 100 * time.Millisecond
+	return a
+}
+
+func TestRedisAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := newTestRedisAllocator(t, 10, 12)
+
+	ids := map[uint]bool{}
+	for i := 0; i < 3; i++ {
+		id, _, err := a.Allocate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id < 10 || 12 < id {
+			t.Errorf("worker id %d is out of range", id)
+		}
+		if ids[id] {
+			t.Errorf("worker id %d is allocated twice", id)
+		}
+		ids[id] = true
+	}
+	if _, _, err := a.Allocate(ctx); err == nil {
+		t.Error("allocation must fail when all worker ids are used")
+	}
+}
+
+func TestRedisAllocatorRelease(t *testing.T) {
+	a := newTestRedisAllocator(t, 5, 5)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	id, ch, err := a.Allocate(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(context.Background(), id); !errors.Is(err, ErrWorkerIDInUse) {
+		t.Errorf("worker id %d must be in use: %v", id, err)
+	}
+	cancel1()
+	for range ch {
+	}
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	if _, err := a.Register(ctx2, id); err != nil {
+		t.Errorf("worker id %d must be released: %v", id, err)
+	}
+}
+
+func TestRedisAllocatorLockMayExpire(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a, err := NewRedisAllocator("redis://"+mr.Addr()+"/0?ns=test", 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.LockExpires = time.Hour
+	a.HoldInterval = 50 * time.Millisecond
+	setClock := stopTestClock(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch, err := a.Register(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// partitioned from Redis
+	mr.Close()
+
+	setClock(a.LockExpires - 2*a.HoldInterval)
+	select {
+	case err := <-ch:
+		t.Fatalf("the lock must be held until HoldInterval before LockExpires: %v", err)
+	case <-time.After(5 * a.HoldInterval):
+	}
+
+	setClock(a.LockExpires - a.HoldInterval/2)
+	select {
+	case err, ok := <-ch:
+		if !ok || err == nil {
+			t.Error("the lock must be lost before LockExpires")
+		}
+	case <-time.After(time.Second):
+		t.Error("the lock must be lost before LockExpires")
+	}
+}
+
+func TestRedisAllocatorInvalidRange(t *testing.T) {
+	if _, err := NewRedisAllocator("redis://localhost:6379", 10, 1); err == nil {
+		t.Error("min > max must be error")
+	}
+	if _, err := NewRedisAllocator("redis://localhost:6379", 1, 1024); err == nil {
+		t.Error("max > 1023 must be error")
+	}
+	if _, err := NewRedisAllocator("http://localhost:6379", 1, 10); err == nil {
+		t.Error("invalid scheme must be error")
+	}
+}
+
+func TestRedisAllocatorCompatibleWithRaus(t *testing.T) {
+	a := newTestRedisAllocator(t, 1, 3)
+	if a.rausURL == "" {
+		t.Fatal("allocation must be done by raus")
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	r, err := raus.New(a.rausURL, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ch, err := r.Get(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Register(context.Background(), id); !errors.Is(err, ErrWorkerIDInUse) {
+		t.Errorf("worker id %d locked by raus must be in use: %v", id, err)
+	}
+
+	// lock the others without raus
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	for i := uint(1); i <= 3; i++ {
+		if i == id {
+			continue
+		}
+		if _, err := a.Register(ctx2, i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r2, err := raus.New(a.rausURL, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2, _, err := r2.Get(context.Background()); err == nil {
+		t.Errorf("worker id %d is allocated twice by raus", id2)
+	}
+
+	cancel1()
+	for range ch {
+	}
+	if _, err := a.Register(ctx2, id); err != nil {
+		t.Errorf("worker id %d must be released by raus: %v", id, err)
+	}
+}
@@ -0,0 +1,113 @@
+package katsubushi
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// AllocationPolicy defines the behavior when a WorkerIDAllocator is unavailable at startup.
+type AllocationPolicy string
+
+const (
+	// AllocationPolicyWait keeps retrying until the allocator becomes available.
+	AllocationPolicyWait AllocationPolicy = "wait"
+	// AllocationPolicyFail gives up after the timeout.
+	AllocationPolicyFail AllocationPolicy = "fail"
+	// AllocationPolicyFallback uses the fallback allocator after the timeout.
+	AllocationPolicyFallback AllocationPolicy = "fallback"
+)
+
+// ParseAllocationPolicy parses s as AllocationPolicy.
+func ParseAllocationPolicy(s string) (AllocationPolicy, error) {
+	switch p := AllocationPolicy(s); p {
+	case AllocationPolicyWait, AllocationPolicyFail, AllocationPolicyFallback:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid allocation policy %q: must be one of wait, fail or fallback", s)
+}
+
+// DefaultMaxRetryInterval is the upper limit of the interval to retry allocation.
+var DefaultMaxRetryInterval = 10 * time.Second
+
+// RetryAllocator retries allocation by Allocator according to Policy.
+type RetryAllocator struct {
+	Allocator WorkerIDAllocator
+	Policy    AllocationPolicy
+
+	// Timeout is used by AllocationPolicyFail and AllocationPolicyFallback.
+	Timeout time.Duration
+
+	// RetryInterval is doubled on each failure up to MaxRetryInterval.
+	RetryInterval    time.Duration
+	MaxRetryInterval time.Duration
+
+	// Fallback is used by AllocationPolicyFallback.
+	Fallback WorkerIDAllocator
+
+	usedFallback int32
+}
+
+// NewRetryAllocator creates RetryAllocator.
+func NewRetryAllocator(a WorkerIDAllocator, policy AllocationPolicy, timeout time.Duration) *RetryAllocator {
+	return &RetryAllocator{
+		Allocator:        a,
+		Policy:           policy,
+		Timeout:          timeout,
+		RetryInterval:    time.Second,
+		MaxRetryInterval: DefaultMaxRetryInterval,
+	}
+}
+
+// Allocate allocates a worker ID by Allocator with retries.
+func (a *RetryAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	if a.Policy == AllocationPolicyFallback && a.Fallback == nil {
+		return 0, nil, fmt.Errorf("fallback allocator is not configured")
+	}
+	var deadline time.Time
+	if a.Policy != AllocationPolicyWait {
+		deadline = time.Now().Add(a.Timeout)
+	}
+	interval := a.RetryInterval
+	for {
+		id, ch, err := a.Allocator.Allocate(ctx)
+		if err == nil {
+			return id, ch, nil
+		}
+		if ctx.Err() != nil {
+			return 0, nil, ctx.Err()
+		}
+		if !deadline.IsZero() && !time.Now().Add(interval).Before(deadline) {
+			if a.Policy == AllocationPolicyFail {
+				return 0, nil, fmt.Errorf("gave up worker id allocation after %s: %w", a.Timeout, err)
+			}
+			log.Errorf("worker id allocation failed for %s: %s", a.Timeout, err)
+			return a.fallback(ctx)
+		}
+		log.Warnf("worker id allocation failed, retrying in %s: %s", interval, err)
+		select {
+		case <-ctx.Done():
+			return 0, nil, ctx.Err()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > a.MaxRetryInterval {
+			interval = a.MaxRetryInterval
+		}
+	}
+}
+
+func (a *RetryAllocator) fallback(ctx context.Context) (uint, <-chan error, error) {
+	id, ch, err := a.Fallback.Allocate(ctx)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to allocate fallback worker id: %w", err)
+	}
+	atomic.StoreInt32(&a.usedFallback, 1)
+	log.Warnf("FALLBACK: using emergency worker id %d", id)
+	return id, ch, nil
+}
+
+// UsedFallback reports whether the worker ID was allocated by the fallback allocator.
+func (a *RetryAllocator) UsedFallback() bool {
+	return atomic.LoadInt32(&a.usedFallback) == 1
+}
@@ -0,0 +1,91 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type flakyAllocator struct {
+	failures int
+	calls    int
+	id       uint
+}
+
+func (a *flakyAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	a.calls++
+	if a.calls <= a.failures {
+		return 0, nil, errors.New("allocator is unavailable")
+	}
+	return a.id, holdUntilDone(ctx), nil
+}
+
+func newTestRetryAllocator(a WorkerIDAllocator, policy AllocationPolicy, timeout time.Duration) *RetryAllocator {
+	r := NewRetryAllocator(a, policy, timeout)
+	r.RetryInterval = 10 * time.Millisecond
+	r.MaxRetryInterval = 20 * time.Millisecond
+	return r
+}
+
+func TestRetryAllocatorWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fa := &flakyAllocator{failures: 5, id: 10}
+	r := newTestRetryAllocator(fa, AllocationPolicyWait, 0)
+	id, _, err := r.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 10 || fa.calls != 6 {
+		t.Errorf("unexpected worker id %d after %d calls", id, fa.calls)
+	}
+	if r.UsedFallback() {
+		t.Error("fallback must not be used")
+	}
+}
+
+func TestRetryAllocatorFail(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fa := &flakyAllocator{failures: 1000}
+	r := newTestRetryAllocator(fa, AllocationPolicyFail, 100*time.Millisecond)
+	start := time.Now()
+	if _, _, err := r.Allocate(ctx); err == nil {
+		t.Error("allocation must fail")
+	} else {
+		t.Log(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("allocation must give up in the timeout: %s", elapsed)
+	}
+}
+
+func TestRetryAllocatorFallback(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fa := &flakyAllocator{failures: 1000}
+	r := newTestRetryAllocator(fa, AllocationPolicyFallback, 50*time.Millisecond)
+	r.Fallback = NewStaticAllocator(WorkerIDRange{Min: 1000, Max: 1010})
+	id, _, err := r.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id < 1000 || 1010 < id {
+		t.Errorf("unexpected fallback worker id %d", id)
+	}
+	if !r.UsedFallback() {
+		t.Error("fallback must be used")
+	}
+}
+
+func TestParseAllocationPolicy(t *testing.T) {
+	for _, s := range []string{"wait", "fail", "fallback"} {
+		if p, err := ParseAllocationPolicy(s); err != nil || string(p) != s {
+			t.Errorf("%s: unexpected result %s %v", s, p, err)
+		}
+	}
+	if _, err := ParseAllocationPolicy("retry"); err == nil {
+		t.Error("invalid policy must be error")
+	}
+}
@@ -0,0 +1,287 @@
+package katsubushi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// DefaultSQLTable is the default name of the table to lease worker IDs.
+const DefaultSQLTable = "katsubushi_worker_ids"
+
+// SQLAllocator allocates a worker ID by leasing a row of a table in a relational database.
+// Queries are compatible with SQLite and MySQL.
+type SQLAllocator struct {
+	MinWorkerID uint
+	MaxWorkerID uint
+
+	// Table is a name of the table to lease worker IDs.
+	// History of leases is recorded in the table named Table + "_history".
+	Table string
+
+	// LeaseDuration is the duration of a lease.
+	// The lease is renewed every RenewInterval while holding it,
+	// and regarded as lost when it is not renewed until RenewInterval before it expires.
+	LeaseDuration time.Duration
+	RenewInterval time.Duration
+
+	// AutoCreateTable creates the table before allocation if not exists.
+	AutoCreateTable bool
+
+	db    *sql.DB
+	owner string
+}
+
+// NewSQLAllocator creates SQLAllocator.
+func NewSQLAllocator(db *sql.DB, min, max uint) (*SQLAllocator, error) {
+	if err := validateWorkerIDRange(min, max); err != nil {
+		return nil, err
+	}
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	return &SQLAllocator{
+		MinWorkerID:   min,
+		MaxWorkerID:   max,
+		Table:         DefaultSQLTable,
+		LeaseDuration: 60 * time.Second,
+		RenewInterval: 10 * time.Second,
+		db:            db,
+		owner:         u.String(),
+	}, nil
+}
+
+func (a *SQLAllocator) historyTable() string {
+	return a.Table + "_history"
+}
+
+// CreateTable creates the tables to lease worker IDs and to record the history of leases if not exist.
+func (a *SQLAllocator) CreateTable(ctx context.Context) error {
+	_, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			worker_id INTEGER NOT NULL PRIMARY KEY,
+			owner VARCHAR(64) NOT NULL,
+			leased_at BIGINT NOT NULL,
+			expires_at BIGINT NOT NULL
+		)`, a.Table,
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", a.Table, err)
+	}
+	_, err = a.db.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %s (
+			worker_id INTEGER NOT NULL,
+			owner VARCHAR(64) NOT NULL,
+			hostname VARCHAR(255) NOT NULL,
+			pid INTEGER NOT NULL,
+			version VARCHAR(64) NOT NULL,
+			started_at BIGINT NOT NULL,
+			ended_at BIGINT NOT NULL,
+			PRIMARY KEY (worker_id, started_at, owner)
+		)`, a.historyTable(),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to create table %s: %w", a.historyTable(), err)
+	}
+	return nil
+}
+
+// Allocate leases an unused worker ID between MinWorkerID and MaxWorkerID.
+func (a *SQLAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	if a.AutoCreateTable {
+		if err := a.CreateTable(ctx); err != nil {
+			return 0, nil, err
+		}
+	}
+	leasedAt := now()
+	id, err := allocateInRange(ctx, a.MinWorkerID, a.MaxWorkerID, func(id uint) (bool, error) {
+		return a.lease(ctx, id)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	log.Infof("leased worker id %d", id)
+	return id, a.hold(ctx, a.recordLease(ctx, newLease(ctx, id)), leasedAt), nil
+}
+
+// Register leases the specified worker ID.
+func (a *SQLAllocator) Register(ctx context.Context, id uint) (<-chan error, error) {
+	if id > workerIDMask {
+		return nil, ErrInvalidWorkerID
+	}
+	if a.AutoCreateTable {
+		if err := a.CreateTable(ctx); err != nil {
+			return nil, err
+		}
+	}
+	leasedAt := now()
+	ok, err := a.lease(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("worker id %d: %w", id, ErrWorkerIDInUse)
+	}
+	log.Infof("leased worker id %d", id)
+	return a.hold(ctx, a.recordLease(ctx, newLease(ctx, id)), leasedAt), nil
+}
+
+// lease tries to lease id. It returns false when id is leased by another owner.
+func (a *SQLAllocator) lease(ctx context.Context, id uint) (bool, error) {
+	n := now()
+	leasedAt, expiresAt := n.UnixMilli(), n.Add(a.LeaseDuration).UnixMilli()
+
+	// take over an expired lease
+	res, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET owner = ?, leased_at = ?, expires_at = ? WHERE worker_id = ? AND expires_at < ?", a.Table),
+		a.owner, leasedAt, expiresAt, id, leasedAt,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to update a lease of worker id %d: %w", id, err)
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return false, err
+	} else if rows > 0 {
+		return true, nil
+	}
+
+	_, err = a.db.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (worker_id, owner, leased_at, expires_at) VALUES (?, ?, ?, ?)", a.Table),
+		id, a.owner, leasedAt, expiresAt,
+	)
+	if err == nil {
+		return true, nil
+	}
+	// The insert fails by the primary key when another owner holds the lease.
+	var owner string
+	if qerr := a.db.QueryRowContext(ctx, fmt.Sprintf(
+		"SELECT owner FROM %s WHERE worker_id = ?", a.Table), id,
+	).Scan(&owner); qerr == nil && owner != a.owner {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to insert a lease of worker id %d: %w", id, err)
+}
+
+func (a *SQLAllocator) renew(ctx context.Context, id uint) error {
+	res, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET expires_at = ? WHERE worker_id = ? AND owner = ?", a.Table),
+		now().Add(a.LeaseDuration).UnixMilli(), id, a.owner,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil {
+		return err
+	} else if rows == 0 {
+		return fmt.Errorf("lease of worker id %d is taken by another owner: %w", id, ErrWorkerIDInUse)
+	}
+	return nil
+}
+
+func (a *SQLAllocator) release(id uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		"DELETE FROM %s WHERE worker_id = ? AND owner = ?", a.Table),
+		id, a.owner,
+	)
+	if err != nil {
+		log.Warnf("failed to release a lease of worker id %d: %s", id, err)
+		return
+	}
+	log.Infof("released a lease of worker id %d", id)
+}
+
+// recordLease inserts l into the history table. Failures are only logged because the history is informational.
+func (a *SQLAllocator) recordLease(ctx context.Context, l Lease) Lease {
+	_, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		"INSERT INTO %s (worker_id, owner, hostname, pid, version, started_at, ended_at) VALUES (?, ?, ?, ?, ?, ?, 0)", a.historyTable()),
+		l.WorkerID, a.owner, l.Hostname, l.PID, l.Version, l.Start.UnixMilli(),
+	)
+	if err != nil {
+		log.Warnf("failed to record a lease of worker id %d: %s", l.WorkerID, err)
+	}
+	return l
+}
+
+func (a *SQLAllocator) endLease(l Lease) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	_, err := a.db.ExecContext(ctx, fmt.Sprintf(
+		"UPDATE %s SET ended_at = ? WHERE worker_id = ? AND started_at = ? AND owner = ?", a.historyTable()),
+		now().UnixMilli(), l.WorkerID, l.Start.UnixMilli(), a.owner,
+	)
+	if err != nil {
+		log.Warnf("failed to record the end of a lease of worker id %d: %s", l.WorkerID, err)
+	}
+}
+
+// LeaseHistory returns leases of workerID recorded in the history table.
+func (a *SQLAllocator) LeaseHistory(ctx context.Context, workerID uint) ([]Lease, error) {
+	rows, err := a.db.QueryContext(ctx, fmt.Sprintf(
+		"SELECT hostname, pid, version, started_at, ended_at FROM %s WHERE worker_id = ? ORDER BY started_at", a.historyTable()),
+		workerID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select history of worker id %d: %w", workerID, err)
+	}
+	defer rows.Close()
+	var leases []Lease
+	for rows.Next() {
+		var startedAt, endedAt int64
+		l := Lease{WorkerID: workerID}
+		if err := rows.Scan(&l.Hostname, &l.PID, &l.Version, &startedAt, &endedAt); err != nil {
+			return nil, err
+		}
+		l.Start = time.UnixMilli(startedAt)
+		if endedAt > 0 {
+			end := time.UnixMilli(endedAt)
+			l.End = &end
+		}
+		leases = append(leases, l)
+	}
+	return leases, rows.Err()
+}
+
+// hold renews the lease of l which was sent at leasedAt until ctx is done.
+func (a *SQLAllocator) hold(ctx context.Context, l Lease, leasedAt time.Time) <-chan error {
+	id := l.WorkerID
+	ch := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		ticker := time.NewTicker(a.RenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.release(id)
+				a.endLease(l)
+				return
+			case <-ticker.C:
+				sentAt := now()
+				err := a.renew(ctx, id)
+				if err == nil {
+					leasedAt = sentAt
+					continue
+				}
+				if errors.Is(err, ErrWorkerIDInUse) {
+					a.endLease(l)
+					ch <- err
+					return
+				}
+				log.Warnf("failed to renew a lease of worker id %d: %s", id, err)
+				if leaseMayExpire(leasedAt, a.LeaseDuration, a.RenewInterval) {
+					a.endLease(l)
+					ch <- fmt.Errorf("lease of worker id %d may be expired: %w", id, err)
+					return
+				}
+			}
+		}
+	}()
+	return ch
+}
@@ -0,0 +1,168 @@
+package katsubushi
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+func newTestSQLAllocator(t *testing.T, db *sql.DB, min, max uint) *SQLAllocator {
+	a, err := NewSQLAllocator(db, min, max)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.RenewInterval = 100 * time.Millisecond
+	if err := a.CreateTable(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func openTestSQLite(t *testing.T) *sql.DB {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)", filepath.Join(t.TempDir(), "katsubushi.db"))
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := openTestSQLite(t)
+
+	ids := map[uint]bool{}
+	for i := 0; i < 3; i++ {
+		// each allocator acts as a different process
+		a := newTestSQLAllocator(t, db, 10, 12)
+		id, _, err := a.Allocate(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id < 10 || 12 < id {
+			t.Errorf("worker id %d is out of range", id)
+		}
+		if ids[id] {
+			t.Errorf("worker id %d is allocated twice", id)
+		}
+		ids[id] = true
+	}
+	a := newTestSQLAllocator(t, db, 10, 12)
+	if _, _, err := a.Allocate(ctx); err == nil {
+		t.Error("allocation must fail when all worker ids are used")
+	}
+}
+
+func TestSQLAllocatorRelease(t *testing.T) {
+	db := openTestSQLite(t)
+	a1 := newTestSQLAllocator(t, db, 5, 5)
+	a2 := newTestSQLAllocator(t, db, 5, 5)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	id, ch, err := a1.Allocate(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a2.Register(context.Background(), id); !errors.Is(err, ErrWorkerIDInUse) {
+		t.Errorf("worker id %d must be in use: %v", id, err)
+	}
+	cancel1()
+	for range ch {
+	}
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	if _, err := a2.Register(ctx2, id); err != nil {
+		t.Errorf("worker id %d must be released: %v", id, err)
+	}
+}
+
+func TestSQLAllocatorExpired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := openTestSQLite(t)
+	a := newTestSQLAllocator(t, db, 7, 7)
+
+	// a lease left by a crashed process
+	expired := now().Add(-time.Minute).UnixMilli()
+	if _, err := db.Exec(
+		"INSERT INTO katsubushi_worker_ids (worker_id, owner, leased_at, expires_at) VALUES (?, ?, ?, ?)",
+		7, "crashed", expired, expired,
+	); err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 7 {
+		t.Errorf("unexpected worker id %d", id)
+	}
+}
+
+func TestSQLAllocatorLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := openTestSQLite(t)
+	a := newTestSQLAllocator(t, db, 3, 3)
+
+	id, ch, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE katsubushi_worker_ids SET owner = ? WHERE worker_id = ?", "other", id); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-ch:
+		if !errors.Is(err, ErrWorkerIDInUse) {
+			t.Errorf("unexpected error %v", err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Error("lost lease must be notified")
+	}
+}
+
+func TestSQLAllocatorLeaseMayExpire(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	db := openTestSQLite(t)
+	a := newTestSQLAllocator(t, db, 4, 4)
+	a.LeaseDuration = time.Hour
+	a.RenewInterval = 50 * time.Millisecond
+	setClock := stopTestClock(t)
+
+	_, ch, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewals fail without the table
+	if _, err := db.Exec("DROP TABLE katsubushi_worker_ids"); err != nil {
+		t.Fatal(err)
+	}
+
+	setClock(a.LeaseDuration - 2*a.RenewInterval)
+	select {
+	case err := <-ch:
+		t.Fatalf("the lease must be held until RenewInterval before LeaseDuration: %v", err)
+	case <-time.After(5 * a.RenewInterval):
+	}
+
+	setClock(a.LeaseDuration - a.RenewInterval/2)
+	select {
+	case err, ok := <-ch:
+		if !ok || err == nil {
+			t.Error("the lease must be lost before LeaseDuration")
+		}
+	case <-time.After(time.Second):
+		t.Error("the lease must be lost before LeaseDuration")
+	}
+}
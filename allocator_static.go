@@ -0,0 +1,198 @@
+package katsubushi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// WorkerIDRange is a range of worker IDs between Min and Max.
+// It can be written as a single worker ID in a map file.
+type WorkerIDRange struct {
+	Min uint `json:"min" yaml:"min"`
+	Max uint `json:"max" yaml:"max"`
+}
+
+// UnmarshalJSON accepts a single worker ID as well as {"min": ..., "max": ...}.
+func (r *WorkerIDRange) UnmarshalJSON(b []byte) error {
+	var id uint
+	if err := json.Unmarshal(b, &id); err == nil {
+		r.Min, r.Max = id, id
+		return nil
+	}
+	type plain WorkerIDRange
+	return json.Unmarshal(b, (*plain)(r))
+}
+
+// UnmarshalYAML accepts a single worker ID as well as {min: ..., max: ...}.
+func (r *WorkerIDRange) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		var id uint
+		if err := node.Decode(&id); err != nil {
+			return err
+		}
+		r.Min, r.Max = id, id
+		return nil
+	}
+	type plain WorkerIDRange
+	return node.Decode((*plain)(r))
+}
+
+func (r WorkerIDRange) String() string {
+	if r.Min == r.Max {
+		return fmt.Sprintf("%d", r.Min)
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// WorkerIDMap maps keys (e.g. hostname) to ranges of worker IDs.
+type WorkerIDMap map[string]WorkerIDRange
+
+// LoadWorkerIDMap loads WorkerIDMap from a JSON or YAML (.yml, .yaml) file and validates it.
+//
+//	{
+//	  "host-a": 1,
+//	  "host-b": 2,
+//	  "host-c": {"min": 1000, "max": 1001}
+//	}
+func LoadWorkerIDMap(path string) (WorkerIDMap, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m WorkerIDMap
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		// yaml.v3 rejects duplicated keys
+		err = yaml.Unmarshal(b, &m)
+	default:
+		m, err = decodeWorkerIDMapJSON(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid worker id map %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// decodeWorkerIDMapJSON decodes JSON object with rejecting duplicated keys.
+func decodeWorkerIDMapJSON(b []byte) (WorkerIDMap, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	if t, err := dec.Token(); err != nil {
+		return nil, err
+	} else if t != json.Delim('{') {
+		return nil, errors.New("must be a JSON object")
+	}
+	m := WorkerIDMap{}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := t.(string)
+		if _, exists := m[key]; exists {
+			return nil, fmt.Errorf("key %q is duplicated", key)
+		}
+		var r WorkerIDRange
+		if err := dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		m[key] = r
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Lookup returns the range of worker IDs for key.
+func (m WorkerIDMap) Lookup(key string) (WorkerIDRange, error) {
+	r, ok := m[key]
+	if !ok {
+		return WorkerIDRange{}, fmt.Errorf("no worker id for %q", key)
+	}
+	return r, nil
+}
+
+// Validate validates that all ranges fit within WorkerIDBits and do not overlap each other.
+func (m WorkerIDMap) Validate() error {
+	keys := make([]string, 0, len(m))
+	for key, r := range m {
+		if r.Min > r.Max {
+			return fmt.Errorf("%s: max %d must be larger than min %d", key, r.Max, r.Min)
+		}
+		if r.Max > workerIDMask {
+			return fmt.Errorf("%s: worker id %d exceeds %d bits", key, r.Max, WorkerIDBits)
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return m[keys[i]].Min < m[keys[j]].Min
+	})
+	for i := 1; i < len(keys); i++ {
+		prev, cur := m[keys[i-1]], m[keys[i]]
+		if cur.Min <= prev.Max {
+			return fmt.Errorf("worker ids of %s (%s) and %s (%s) are duplicated", keys[i-1], prev, keys[i], cur)
+		}
+	}
+	return nil
+}
+
+// WorkerIDMapKey returns a key to look up WorkerIDMap for this host.
+// source is one of "hostname", "machine-id" (/etc/machine-id) or "env:{NAME}".
+func WorkerIDMapKey(source string) (string, error) {
+	switch {
+	case source == "hostname":
+		return os.Hostname()
+	case source == "machine-id":
+		b, err := os.ReadFile("/etc/machine-id")
+		if err != nil {
+			return "", err
+		}
+		id := strings.TrimSpace(string(b))
+		if id == "" {
+			return "", errors.New("/etc/machine-id is empty")
+		}
+		return id, nil
+	case strings.HasPrefix(source, "env:"):
+		name := strings.TrimPrefix(source, "env:")
+		v := os.Getenv(name)
+		if v == "" {
+			return "", fmt.Errorf("environment variable %s is empty", name)
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid key source %q: must be one of hostname, machine-id or env:{NAME}", source)
+}
+
+// StaticAllocator allocates a worker ID from a range owned by this process statically.
+type StaticAllocator struct {
+	Range WorkerIDRange
+}
+
+// NewStaticAllocator creates StaticAllocator.
+func NewStaticAllocator(r WorkerIDRange) *StaticAllocator {
+	return &StaticAllocator{Range: r}
+}
+
+// Allocate returns the first worker ID in the range which is not used by generators in this process.
+func (a *StaticAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	newGeneratorLock.Lock()
+	defer newGeneratorLock.Unlock()
+	for id := a.Range.Min; id <= a.Range.Max; id++ {
+		if checkWorkerID(id) == nil {
+			return id, holdUntilDone(ctx), nil
+		}
+	}
+	return 0, nil, fmt.Errorf("no more available worker id between %d and %d", a.Range.Min, a.Range.Max)
+}
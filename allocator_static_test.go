@@ -0,0 +1,126 @@
+package katsubushi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadWorkerIDMap(t *testing.T) {
+	path := writeTestFile(t, "map.json", `{
+		"host-a": {"min": 1000, "max": 1001},
+		"host-b": {"min": 1002, "max": 1002}
+	}`)
+	m, err := LoadWorkerIDMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := m["host-a"]; r.Min != 1000 || r.Max != 1001 {
+		t.Errorf("unexpected range of host-a %v", r)
+	}
+	if r := m["host-b"]; r.Min != 1002 || r.Max != 1002 {
+		t.Errorf("unexpected range of host-b %v", r)
+	}
+}
+
+func TestLoadWorkerIDMapInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"overlapped": `{"host-a": {"min": 1000, "max": 1001}, "host-b": {"min": 1001, "max": 1002}}`,
+		"min > max":  `{"host-a": {"min": 1001, "max": 1000}}`,
+		"overflow":   `{"host-a": {"min": 1000, "max": 1024}}`,
+		"broken":     `{"host-a": `,
+	} {
+		if _, err := LoadWorkerIDMap(writeTestFile(t, "map.json", content)); err == nil {
+			t.Errorf("%s: must be error", name)
+		} else {
+			t.Logf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestStaticAllocator(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := NewStaticAllocator(WorkerIDRange{Min: 1020, Max: 1021})
+	id, _, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewGenerator(id); err != nil {
+		t.Fatal(err)
+	}
+	id2, _, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == id2 {
+		t.Errorf("worker id %d used in this process must not be allocated", id)
+	}
+}
+
+func TestLoadWorkerIDMapSingle(t *testing.T) {
+	for name, content := range map[string]string{
+		"map.json": `{"host-a": 1, "host-b": 2, "host-c": {"min": 10, "max": 11}}`,
+		"map.yaml": "host-a: 1\nhost-b: 2\nhost-c:\n  min: 10\n  max: 11\n",
+	} {
+		m, err := LoadWorkerIDMap(writeTestFile(t, name, content))
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		for key, expected := range map[string]WorkerIDRange{
+			"host-a": {Min: 1, Max: 1},
+			"host-b": {Min: 2, Max: 2},
+			"host-c": {Min: 10, Max: 11},
+		} {
+			if r, err := m.Lookup(key); err != nil || r != expected {
+				t.Errorf("%s: unexpected range of %s %v %v", name, key, r, err)
+			}
+		}
+		if _, err := m.Lookup("host-x"); err == nil {
+			t.Errorf("%s: lookup of unknown key must be error", name)
+		}
+	}
+}
+
+func TestLoadWorkerIDMapDuplicated(t *testing.T) {
+	for name, content := range map[string]string{
+		"map.json": `{"host-a": 1, "host-b": 1}`,
+		"map.yaml": "host-a: 1\nhost-b: 1\n",
+		"key.json": `{"host-a": 1, "host-a": 2}`,
+		"key.yaml": "host-a: 1\nhost-a: 2\n",
+		"big.yaml": "host-a: 1024\n",
+	} {
+		if _, err := LoadWorkerIDMap(writeTestFile(t, name, content)); err == nil {
+			t.Errorf("%s: must be error", name)
+		} else {
+			t.Logf("%s: %s", name, err)
+		}
+	}
+}
+
+func TestWorkerIDMapKey(t *testing.T) {
+	t.Setenv("KATSUBUSHI_TEST_HOST", "host-a")
+	if key, err := WorkerIDMapKey("env:KATSUBUSHI_TEST_HOST"); err != nil || key != "host-a" {
+		t.Errorf("unexpected key %s %v", key, err)
+	}
+	if _, err := WorkerIDMapKey("env:KATSUBUSHI_TEST_UNDEFINED"); err == nil {
+		t.Error("empty environment variable must be error")
+	}
+	hostname, _ := os.Hostname()
+	if key, err := WorkerIDMapKey("hostname"); err != nil || key != hostname {
+		t.Errorf("unexpected key %s %v", key, err)
+	}
+	if _, err := WorkerIDMapKey("ipaddr"); err == nil {
+		t.Error("invalid source must be error")
+	}
+}
@@ -0,0 +1,92 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// StickyAllocator remembers the last allocated worker ID in a local file,
+// and tries to hold the same worker ID again at first.
+// It falls back to allocate any free worker ID when the last one is held by another process.
+type StickyAllocator struct {
+	Allocator   WorkerIDRegisterer
+	Path        string
+	MinWorkerID uint
+	MaxWorkerID uint
+}
+
+// NewStickyAllocator creates StickyAllocator.
+// The cached worker ID is ignored when it is out of the range between min and max.
+func NewStickyAllocator(a WorkerIDRegisterer, path string, min, max uint) *StickyAllocator {
+	return &StickyAllocator{
+		Allocator:   a,
+		Path:        path,
+		MinWorkerID: min,
+		MaxWorkerID: max,
+	}
+}
+
+// Allocate allocates the last worker ID if available, or any free worker ID.
+func (a *StickyAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	if id, ok := a.load(); ok {
+		ch, err := a.Allocator.Register(ctx, id)
+		if err == nil {
+			log.Infof("reuse the last worker id %d", id)
+			return id, ch, nil
+		}
+		if !errors.Is(err, ErrWorkerIDInUse) {
+			return 0, nil, err
+		}
+		log.Infof("the last worker id %d is in use, allocating another one", id)
+	}
+	id, ch, err := a.Allocator.Allocate(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := a.save(id); err != nil {
+		log.Warnf("failed to save worker id to %s: %s", a.Path, err)
+	}
+	return id, ch, nil
+}
+
+func (a *StickyAllocator) load() (uint, bool) {
+	b, err := os.ReadFile(a.Path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Warnf("failed to read the last worker id from %s: %s", a.Path, err)
+		}
+		return 0, false
+	}
+	id, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		log.Warnf("invalid worker id in %s: %s", a.Path, err)
+		return 0, false
+	}
+	if uint(id) < a.MinWorkerID || a.MaxWorkerID < uint(id) {
+		log.Infof("the last worker id %d is out of range between %d and %d", id, a.MinWorkerID, a.MaxWorkerID)
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func (a *StickyAllocator) save(id uint) error {
+	// write to a temporary file and rename it to avoid a partially written file
+	f, err := os.CreateTemp(filepath.Dir(a.Path), filepath.Base(a.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := fmt.Fprintf(f, "%d\n", id); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), a.Path)
+}
@@ -0,0 +1,73 @@
+package katsubushi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStickyAllocator(t *testing.T) {
+	db := openTestSQLite(t)
+	path := filepath.Join(t.TempDir(), "worker_id")
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	a1 := NewStickyAllocator(newTestSQLAllocator(t, db, 1, 1023), path, 1, 1023)
+	id1, ch1, err := a1.Allocate(ctx1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := os.ReadFile(path); err != nil {
+		t.Fatal(err)
+	} else {
+		t.Logf("cached worker id: %s", b)
+	}
+	// restart
+	cancel1()
+	for range ch1 {
+	}
+
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	a2 := NewStickyAllocator(newTestSQLAllocator(t, db, 1, 1023), path, 1, 1023)
+	id2, _, err := a2.Allocate(ctx2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != id2 {
+		t.Errorf("worker id must be same across restarts: %d != %d", id1, id2)
+	}
+
+	// the last worker id is held by a2
+	ctx3, cancel3 := context.WithCancel(context.Background())
+	defer cancel3()
+	a3 := NewStickyAllocator(newTestSQLAllocator(t, db, 1, 1023), path, 1, 1023)
+	id3, _, err := a3.Allocate(ctx3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id3 == id2 {
+		t.Errorf("worker id %d is allocated twice", id3)
+	}
+	if id, ok := a3.load(); !ok || id != id3 {
+		t.Errorf("cache must be updated to %d: %d", id3, id)
+	}
+}
+
+func TestStickyAllocatorOutOfRange(t *testing.T) {
+	db := openTestSQLite(t)
+	path := filepath.Join(t.TempDir(), "worker_id")
+	if err := os.WriteFile(path, []byte("100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	a := NewStickyAllocator(newTestSQLAllocator(t, db, 1, 10), path, 1, 10)
+	id, _, err := a.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id < 1 || 10 < id {
+		t.Errorf("worker id %d is out of range", id)
+	}
+}
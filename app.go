@@ -3,6 +3,7 @@ package katsubushi
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -10,13 +11,14 @@ import (
 	"net"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
 )
 
 var (
@@ -28,6 +30,8 @@ var (
 
 var (
 	respError         = []byte("ERROR\r\n")
+	respClientError   = []byte("CLIENT_ERROR ")
+	respServerError   = []byte("SERVER_ERROR ")
 	memdSep           = []byte("\r\n")
 	memdSepLen        = len(memdSep)
 	memdSpc           = []byte(" ")
@@ -48,7 +52,9 @@ var (
 
 // App is main struct of the Application.
 type App struct {
-	Listener net.Listener
+	// Listener is the first listener of the memcached protocol, and listeners are all of them.
+	Listener  net.Listener
+	listeners []net.Listener
 
 	gen     Generator
 	readyCh chan interface{}
@@ -56,14 +62,62 @@ type App struct {
 	// App will disconnect connection if there are no commands until idleTimeout.
 	idleTimeout time.Duration
 
+	// App closes connections not drained in shutdownTimeout on shutdown.
+	shutdownTimeout time.Duration
+
+	// drainCh is closed on shutdown to report not ready and drain connections.
+	drainOnce sync.Once
+	drainCh   chan struct{}
+	health    *health.Server
+
+	// App refuses to issue IDs while gossip detects a conflict of the worker ID.
+	gossip *Gossip
+
+	// workerLeaser leases worker IDs to clients generating IDs locally.
+	workerLeaser *WorkerLeaser
+
+	// saslAuth authenticates connections. nil means no authentication.
+	saslAuth *SASLAuth
+
+	// proxyProtocol accepts PROXY protocol headers. nil means disabled.
+	proxyProtocol *ProxyProtocol
+
+	// rateLimiter limits IDs issued to clients. nil means unlimited.
+	rateLimiter *RateLimiter
+
+	// mux dispatches connections of HTTP and gRPC on listeners of the memcached protocol. nil means disabled.
+	mux *multiplexer
+
+	// connLimits are limits of connections of each protocol, shared by its own listeners and multiplexed ones.
+	connLimitsOnce sync.Once
+	connLimits     [3]*connLimit
+
+	// handoffListeners are handed over to a new process by Upgrade, and upgraded is 1 after Upgrade.
+	// handoffPipe is closed by Handoff to let the new process issue IDs.
+	handoffMu        sync.Mutex
+	handoffListeners []handoffListener
+	handoffPipe      *os.File
+	upgraded         int32
+
+	// issueMu is held to issue IDs, and locked to stop issuing on Upgrade.
+	issueMu sync.RWMutex
+
 	startedAt time.Time
 
+	// conns maps connections to *connStat, and listenAddrs maps names to listening addresses for stats.
+	conns       sync.Map
+	listenAddrs sync.Map
+
 	// these values are accessed atomically
-	currConnections  int64
-	totalConnections int64
-	cmdGet           int64
-	getHits          int64
-	getMisses        int64
+	connSeq             int64
+	maxConnections      int64
+	currConnections     int64
+	totalConnections    int64
+	cmdGet              int64
+	getHits             int64
+	getMisses           int64
+	workerIDFallback    int64
+	rejectedConnections int64
 }
 
 // New create and returns new App instance.
@@ -76,6 +130,8 @@ func New(workerID uint) (*App, error) {
 		gen:       gen,
 		startedAt: time.Now(),
 		readyCh:   make(chan interface{}),
+		drainCh:   make(chan struct{}),
+		health:    health.NewServer(),
 	}, nil
 }
 
@@ -85,6 +141,8 @@ func NewAppWithGenerator(gen Generator, workerID uint) (*App, error) {
 		gen:       gen,
 		startedAt: time.Now(),
 		readyCh:   make(chan interface{}),
+		drainCh:   make(chan struct{}),
+		health:    health.NewServer(),
 	}, nil
 }
 
@@ -126,21 +184,52 @@ func StdLogger() *stdlog.Logger {
 }
 
 func (app *App) RunServer(ctx context.Context, kc *Config) error {
-	var l net.Listener
-	var err error
-	if kc.Sockpath != "" {
-		l, err = app.ListenerSock(kc.Sockpath)
-		if err != nil {
-			return err
+	app.setMaxConnections(kc.MaxConnections)
+	memdLimit := app.connLimitOf(kc, muxMemcache)
+	if kc.Multiplex {
+		// counted by the protocol dispatched to after sniffing
+		memdLimit = nil
+	}
+	tlsConfig := kc.TLSConfig
+	if tlsConfig != nil && kc.Multiplex {
+		tlsConfig = multiplexTLSConfig(tlsConfig)
+	}
+	var ls []net.Listener
+	add := func(l net.Listener, disableTLS bool) {
+		app.addHandoffListener("memcache", l)
+		limitConnections(l, memdLimit)
+		if tlsConfig != nil && !disableTLS {
+			l = tls.NewListener(l, tlsConfig)
 		}
-	} else {
-		l, err = app.ListenerTCP(fmt.Sprintf(":%d", kc.Port))
-		if err != nil {
-			return err
+		ls = append(ls, l)
+	}
+	for _, l := range kc.Listeners {
+		add(app.wrapListener(l), false)
+	}
+	if len(kc.Listeners) == 0 {
+		for _, a := range listenAddrsOf(kc) {
+			l, err := app.ListenerAddr(a)
+			if err != nil {
+				for _, l := range ls {
+					l.Close()
+				}
+				return err
+			}
+			add(l, a.DisableTLS)
 		}
 	}
 	app.idleTimeout = kc.IdleTimeout
-	return app.Serve(ctx, l)
+	app.shutdownTimeout = kc.ShutdownTimeout
+	if !kc.Multiplex {
+		return app.ServeListeners(ctx, ls)
+	}
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	muxDone := app.startMultiplexer(ctx2, kc, ls[0].Addr())
+	err := app.ServeListeners(ctx2, ls)
+	cancel()
+	<-muxDone
+	return err
 }
 
 // ListenerSock starts listen Unix Domain Socket on sockpath.
@@ -164,44 +253,102 @@ func (app *App) ListenerTCP(addr string) (net.Listener, error) {
 
 // Serve starts a server.
 func (app *App) Serve(ctx context.Context, l net.Listener) error {
+	return app.ServeListeners(ctx, []net.Listener{l})
+}
+
+// ServeListeners starts a server on all listeners. It returns an error of the first listener failed to accept,
+// after closing all listeners.
+func (app *App) ServeListeners(ctx context.Context, ls []net.Listener) error {
+	if len(ls) == 0 {
+		return errors.New("no listeners to serve")
+	}
 	defer logger.Sync()
-	log.Infof("Listening server at %s", l.Addr().String())
+	for _, l := range ls {
+		log.Infof("Listening server at %s", l.Addr().String())
+	}
 	log.Infof("Worker ID = %d", app.gen.WorkerID())
 
-	app.Listener = l
+	app.Listener = ls[0]
+	app.listeners = ls
 	close(app.readyCh)
+	// pings to the watchdog continue while draining
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	app.notifyReady(watchdogCtx)
 
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go func() {
-		<-ctx.Done()
-		if err := l.Close(); err != nil {
-			log.Warn(err)
+		<-ctx2.Done()
+		for _, l := range ls {
+			if err := l.Close(); err != nil {
+				log.Warn(err)
+			}
 		}
 	}()
 
+	// connections are closed by connCtx, which is canceled after draining
+	connCtx, closeConns := context.WithCancel(context.Background())
+	defer closeConns()
+	var conns sync.WaitGroup
+	errCh := make(chan error, len(ls))
+	for _, l := range ls {
+		go func(l net.Listener) {
+			errCh <- app.serve(ctx2, connCtx, l, &conns)
+		}(l)
+	}
+	var err error
+	for range ls {
+		if e := <-errCh; e != nil && err == nil {
+			err = e
+			cancel()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	log.Info("Shutting down server")
+	app.drainConns(&conns, closeConns)
+	return nil
+}
+
+// serve accepts connections on l until ctx is done. Connections are handled with connCtx.
+func (app *App) serve(ctx, connCtx context.Context, l net.Listener, conns *sync.WaitGroup) error {
 	for {
 		conn, err := l.Accept()
 		if err != nil {
 			select {
 			case <-ctx.Done():
-				log.Info("Shutting down server")
 				return nil
 			default:
-				log.Warnf("Error on accept connection: %s", err)
+				log.Warnf("Error on accept connection at %s: %s", l.Addr(), err)
 				return err
 			}
 		}
-		log.Debugf("Connected from %s", conn.RemoteAddr().String())
-
-		go app.handleConn(ctx, conn)
+		conns.Add(1)
+		go func() {
+			defer conns.Done()
+			app.handleConn(connCtx, conn)
+		}()
 	}
 }
 
 // Ready returns a channel which become readable when the app can accept connections.
+// READY=1 is also notified to systemd at the time when NOTIFY_SOCKET is set, and STOPPING=1 on shutdown.
 func (app *App) Ready() chan interface{} {
 	return app.readyCh
 }
 
 func (app *App) handleConn(ctx context.Context, conn net.Conn) {
+	// RemoteAddr may wait for a PROXY protocol header, so it is not called in the accept loop
+	log.Debugf("Connected from %s", conn.RemoteAddr().String())
+	if app.mux != nil {
+		// connections of HTTP and gRPC are handed over to the servers
+		if conn = app.mux.dispatch(ctx, app, conn); conn == nil {
+			return
+		}
+	}
+
 	ctx2, cancel := context.WithCancel(ctx)
 	defer cancel()
 	go func() {
@@ -210,8 +357,23 @@ func (app *App) handleConn(ctx context.Context, conn net.Conn) {
 		log.Debugf("Closed %s", conn.RemoteAddr().String())
 	}()
 
+	if isRejectedConn(conn) {
+		conn.SetWriteDeadline(time.Now().Add(time.Second))
+		app.writeErrorOf(conn, ErrTooManyConnections)
+		return
+	}
+	cs := app.trackConn(conn)
+	defer app.untrackConn(conn)
+
 	app.extendDeadline(conn)
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.HandshakeContext(ctx2); err != nil {
+			log.Warnf("TLS handshake error from %s: %s", conn.RemoteAddr().String(), err)
+			return
+		}
+	}
+
 	bufReader := bufio.NewReader(conn)
 	isBin, err := app.IsBinaryProtocol(bufReader)
 	if err != nil {
@@ -223,34 +385,66 @@ func (app *App) handleConn(ctx context.Context, conn net.Conn) {
 		return
 	}
 	if isBin {
+		atomic.StoreInt32(&cs.protocol, protocolBinary)
 		log.Debug("binary protocol")
 		app.RespondToBinary(bufReader, conn)
 		return
 	}
 
+	atomic.StoreInt32(&cs.protocol, protocolText)
+
 	scanner := bufio.NewScanner(bufReader)
 	w := bufio.NewWriter(conn)
 	var deadline time.Time
+	authenticated := app.saslAuth == nil
 	for scanner.Scan() {
 		deadline, err = app.extendDeadline(conn)
 		if err != nil {
 			log.Warnf("error on set deadline: %s", err)
 			return
 		}
+		if !authenticated {
+			authenticated, err = app.authenticateText(scanner.Bytes(), w)
+			if ferr := w.Flush(); err != nil || ferr != nil {
+				return
+			}
+			continue
+		}
 		cmd, err := app.BytesToCmd(scanner.Bytes())
 		if err != nil {
-			if err := app.writeError(conn); err != nil {
+			if errors.Is(err, errUnknownCommand) {
+				err = app.writeError(conn)
+			} else {
+				err = app.writeErrorOf(conn, err)
+			}
+			if err != nil {
 				log.Warnf("error on write error: %s", err)
 				return
 			}
 			continue
 		}
+		atomic.AddInt64(&cs.cmds, 1)
+		if wc, ok := cmd.(*MemdCmdWorker); ok {
+			wc.client = conn.RemoteAddr()
+		}
+		if err := app.limitRate(conn.RemoteAddr(), "", cmd); err != nil {
+			log.Warn(err)
+			app.writeErrorOf(w, err)
+			if err := w.Flush(); err != nil {
+				return
+			}
+			continue
+		}
 		if err := cmd.Execute(app, w); err != nil {
 			if err != io.EOF {
 				log.Warnf("error on execute cmd %s: %s", cmd, err)
 			}
 			return
 		}
+		if q, ok := cmd.(quietCmd); ok && q.quiet() {
+			// flushed by a following command, as mn
+			continue
+		}
 		if err := w.Flush(); err != nil {
 			if err != io.EOF {
 				log.Warnf("error on cmd %s write to conn: %s", cmd, err)
@@ -263,6 +457,9 @@ func (app *App) handleConn(ctx context.Context, conn net.Conn) {
 		case <-ctx.Done():
 			// shutting down
 			return
+		case <-app.drainCh:
+			log.Debugf("Closed a drained connection %s", conn.RemoteAddr().String())
+			return
 		default:
 		}
 		if !deadline.IsZero() && time.Now().After(deadline) {
@@ -277,18 +474,93 @@ func (app *App) handleConn(ctx context.Context, conn net.Conn) {
 func (app *App) GetStats() MemdStats {
 	now := time.Now()
 	return MemdStats{
-		Pid:              os.Getpid(),
-		Uptime:           int64(now.Sub(app.startedAt).Seconds()),
-		Time:             time.Now().Unix(),
-		Version:          Version,
-		CurrConnections:  atomic.LoadInt64(&app.currConnections),
-		TotalConnections: atomic.LoadInt64(&app.totalConnections),
-		CmdGet:           atomic.LoadInt64(&app.cmdGet),
-		GetHits:          atomic.LoadInt64(&app.getHits),
-		GetMisses:        atomic.LoadInt64(&app.getMisses),
+		Pid:                 os.Getpid(),
+		Uptime:              int64(now.Sub(app.startedAt).Seconds()),
+		Time:                time.Now().Unix(),
+		Version:             Version,
+		CurrConnections:     atomic.LoadInt64(&app.currConnections),
+		TotalConnections:    atomic.LoadInt64(&app.totalConnections),
+		CmdGet:              atomic.LoadInt64(&app.cmdGet),
+		GetHits:             atomic.LoadInt64(&app.getHits),
+		GetMisses:           atomic.LoadInt64(&app.getMisses),
+		WorkerIDFallback:    atomic.LoadInt64(&app.workerIDFallback),
+		WorkerIDConflict:    app.workerIDConflict(),
+		RejectedConnections: atomic.LoadInt64(&app.rejectedConnections),
 	}
 }
 
+// SetWorkerIDFallback marks that the worker ID was allocated by a fallback allocator.
+// It is reported as worker_id_fallback in stats.
+func (app *App) SetWorkerIDFallback(fallback bool) {
+	var v int64
+	if fallback {
+		v = 1
+	}
+	atomic.StoreInt64(&app.workerIDFallback, v)
+}
+
+// SetGossip sets Gossip to detect a conflict of the worker ID with other processes.
+// App refuses to issue IDs until the first round of gossip and while the worker ID is conflicted,
+// and reports worker_id_conflict in stats.
+func (app *App) SetGossip(g *Gossip) {
+	app.gossip = g
+}
+
+// SetWorkerLeaser enables leasing worker IDs to clients by l.
+func (app *App) SetWorkerLeaser(l *WorkerLeaser) {
+	app.workerLeaser = l
+}
+
+func (app *App) leaseWorker(addr net.Addr) (*WorkerLease, error) {
+	if app.workerLeaser == nil {
+		return nil, ErrWorkerLeaseDisabled
+	}
+	return app.workerLeaser.Lease(addr)
+}
+
+func (app *App) renewWorker(id uint, token string) (*WorkerLease, error) {
+	if app.workerLeaser == nil {
+		return nil, ErrWorkerLeaseDisabled
+	}
+	return app.workerLeaser.Renew(id, token)
+}
+
+func (app *App) releaseWorker(id uint, token string) error {
+	if app.workerLeaser == nil {
+		return ErrWorkerLeaseDisabled
+	}
+	return app.workerLeaser.Release(id, token)
+}
+
+// gossipError returns an error when IDs must not be issued by gossip.
+// issueError returns an error when app must not issue IDs. The caller must hold issueMu.
+func (app *App) issueError() error {
+	if atomic.LoadInt32(&app.upgraded) == 1 {
+		return ErrHandedOver
+	}
+	return app.gossipError()
+}
+
+func (app *App) gossipError() error {
+	if app.gossip == nil {
+		return nil
+	}
+	if app.gossip.Conflicted() {
+		return ErrWorkerIDConflicted
+	}
+	if !app.gossip.Confirmed() {
+		return ErrWorkerIDUnconfirmed
+	}
+	return nil
+}
+
+func (app *App) workerIDConflict() int64 {
+	if app.gossip != nil && app.gossip.conflictDetected() {
+		return 1
+	}
+	return 0
+}
+
 func (app *App) writeError(conn io.Writer) (err error) {
 	_, err = conn.Write(respError)
 	if err != nil {
@@ -298,8 +570,44 @@ func (app *App) writeError(conn io.Writer) (err error) {
 	return
 }
 
+// writeErrorOf writes "CLIENT_ERROR <reason>" for invalid requests, "SERVER_ERROR <reason>" for others.
+func (app *App) writeErrorOf(conn io.Writer, reason error) (err error) {
+	if isInvalidArgument(reason) {
+		conn.Write(respClientError)
+	} else {
+		conn.Write(respServerError)
+	}
+	io.WriteString(conn, reason.Error())
+	_, err = conn.Write(memdSep)
+	if err != nil {
+		log.Warn(err)
+	}
+	return
+}
+
+// invalidArgumentError is an error caused by invalid arguments of a request.
+type invalidArgumentError string
+
+func (e invalidArgumentError) Error() string {
+	return string(e)
+}
+
+func isInvalidArgument(err error) bool {
+	var e invalidArgumentError
+	return errors.As(err, &e)
+}
+
+// errUnknownCommand is returned by BytesToCmd for unknown commands, responded as "ERROR".
+var errUnknownCommand = errors.New("unknown command")
+
 // NextID generates new ID.
 func (app *App) NextID() (uint64, error) {
+	app.issueMu.RLock()
+	defer app.issueMu.RUnlock()
+	if err := app.issueError(); err != nil {
+		atomic.AddInt64(&(app.getMisses), 1)
+		return 0, err
+	}
 	id, err := app.gen.NextID()
 	if err != nil {
 		atomic.AddInt64(&(app.getMisses), 1)
@@ -309,10 +617,53 @@ func (app *App) NextID() (uint64, error) {
 	return id, err
 }
 
+// NextRanges reserves n IDs as contiguous ranges.
+func (app *App) NextRanges(n int) ([]IDRange, error) {
+	if err := validateRangeSize(n); err != nil {
+		return nil, err
+	}
+	app.issueMu.RLock()
+	defer app.issueMu.RUnlock()
+	if err := app.issueError(); err != nil {
+		atomic.AddInt64(&(app.getMisses), 1)
+		return nil, err
+	}
+	ranges, err := nextRanges(app.gen, n)
+	if err != nil {
+		atomic.AddInt64(&(app.getMisses), 1)
+	} else {
+		atomic.AddInt64(&(app.getHits), int64(n))
+	}
+	return ranges, err
+}
+
+// valueOf returns a value for key of GET commands.
+// It is a new ID, or ID ranges for a key "range:<number_of_ids>".
+func (app *App) valueOf(key string) (string, error) {
+	if !strings.HasPrefix(key, RangeKeyPrefix) {
+		id, err := app.NextID()
+		if err != nil {
+			return "", err
+		}
+		log.Debugf("Generated ID: %d", id)
+		return strconv.FormatUint(id, 10), nil
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(key, RangeKeyPrefix))
+	if err != nil {
+		return "", invalidArgumentError("invalid range key: " + key)
+	}
+	ranges, err := app.NextRanges(n)
+	if err != nil {
+		return "", err
+	}
+	log.Debugf("Generated ID ranges: %v", ranges)
+	return formatIDRanges(ranges), nil
+}
+
 // BytesToCmd converts byte array to a MemdCmd and returns it.
 func (app *App) BytesToCmd(data []byte) (cmd MemdCmd, err error) {
 	if len(data) == 0 {
-		return nil, fmt.Errorf("no command")
+		return nil, fmt.Errorf("%w: no command", errUnknownCommand)
 	}
 
 	fields := strings.Fields(string(data))
@@ -320,7 +671,7 @@ func (app *App) BytesToCmd(data []byte) (cmd MemdCmd, err error) {
 	case "GET", "GETS":
 		atomic.AddInt64(&(app.cmdGet), 1)
 		if len(fields) < 2 {
-			err = fmt.Errorf("GET command needs key as second parameter")
+			err = invalidArgumentError("GET command needs key as second parameter")
 			return
 		}
 		cmd = &MemdCmdGet{
@@ -330,21 +681,39 @@ func (app *App) BytesToCmd(data []byte) (cmd MemdCmd, err error) {
 	case "QUIT":
 		cmd = MemdCmdQuit(0)
 	case "STATS":
-		cmd = MemdCmdStats(0)
+		if len(fields) > 1 {
+			cmd = &MemdCmdStatsSub{Name: fields[1]}
+		} else {
+			cmd = MemdCmdStats(0)
+		}
 	case "VERSION":
 		cmd = MemdCmdVersion(0)
+	case "WORKER":
+		cmd, err = parseMemdCmdWorker(fields[1:])
+	case "MG":
+		atomic.AddInt64(&(app.cmdGet), 1)
+		cmd, err = parseMetaCmd(name, fields[1:])
+	case "MN", "ME":
+		cmd, err = parseMetaCmd(name, fields[1:])
 	default:
-		err = fmt.Errorf("unknown command: %s", name)
+		err = fmt.Errorf("%w: %s", errUnknownCommand, name)
 	}
 	return
 }
 
 func (app *App) extendDeadline(conn net.Conn) (time.Time, error) {
-	if app.idleTimeout == InfiniteIdleTimeout {
-		return time.Time{}, nil
+	var d time.Time
+	if app.idleTimeout != InfiniteIdleTimeout {
+		d = time.Now().Add(app.idleTimeout)
+		if err := conn.SetDeadline(d); err != nil {
+			return d, err
+		}
 	}
-	d := time.Now().Add(app.idleTimeout)
-	return d, conn.SetDeadline(d)
+	if app.isDraining() {
+		// reading the next command fails, to close the connection after the current command
+		return d, conn.SetReadDeadline(time.Now())
+	}
+	return d, nil
 }
 
 // MemdCmd defines a command.
@@ -361,18 +730,17 @@ type MemdCmdGet struct {
 // Execute generates new ID.
 func (cmd *MemdCmdGet) Execute(app *App, conn io.Writer) error {
 	values := make([]string, len(cmd.Keys))
-	for i := range cmd.Keys {
-		id, err := app.NextID()
+	for i, key := range cmd.Keys {
+		v, err := app.valueOf(key)
 		if err != nil {
 			log.Warn(err)
-			if err = app.writeError(conn); err != nil {
+			if err = app.writeErrorOf(conn, err); err != nil {
 				log.Warn("error on write error: %s", err)
 				return err
 			}
 			return nil
 		}
-		log.Debugf("Generated ID: %d", id)
-		values[i] = strconv.FormatUint(id, 10)
+		values[i] = v
 	}
 	_, err := MemdValue{
 		Keys:   cmd.Keys,
@@ -410,6 +778,69 @@ func (cmd MemdCmdVersion) Execute(app *App, w io.Writer) error {
 	return err
 }
 
+// MemdCmdWorker defines WORKER command to lease a worker ID to a client.
+//
+//	WORKER LEASE
+//	WORKER RENEW <worker_id> <token>
+//	WORKER RELEASE <worker_id> <token>
+type MemdCmdWorker struct {
+	Op       string
+	WorkerID uint
+	Token    string
+
+	// client is the address of the connection, recorded as the holder of the worker ID.
+	client net.Addr
+}
+
+func parseMemdCmdWorker(args []string) (*MemdCmdWorker, error) {
+	if len(args) == 0 {
+		return nil, invalidArgumentError("WORKER command needs LEASE, RENEW or RELEASE")
+	}
+	cmd := &MemdCmdWorker{Op: strings.ToUpper(args[0])}
+	switch cmd.Op {
+	case "LEASE":
+		return cmd, nil
+	case "RENEW", "RELEASE":
+		if len(args) != 3 {
+			return nil, invalidArgumentError(fmt.Sprintf("WORKER %s command needs worker id and token", cmd.Op))
+		}
+		id, err := strconv.ParseUint(args[1], 10, 64)
+		if err != nil {
+			return nil, invalidArgumentError("invalid worker id: " + args[1])
+		}
+		cmd.WorkerID, cmd.Token = uint(id), args[2]
+		return cmd, nil
+	}
+	return nil, invalidArgumentError("unknown WORKER command: " + cmd.Op)
+}
+
+// Execute leases, renews or releases a worker ID.
+// It responds "WORKER <worker_id> <token> <ttl_ms>", "RELEASED" or "NOT_FOUND".
+func (cmd *MemdCmdWorker) Execute(app *App, w io.Writer) error {
+	var l *WorkerLease
+	var err error
+	switch cmd.Op {
+	case "LEASE":
+		l, err = app.leaseWorker(cmd.client)
+	case "RENEW":
+		l, err = app.renewWorker(cmd.WorkerID, cmd.Token)
+	case "RELEASE":
+		err = app.releaseWorker(cmd.WorkerID, cmd.Token)
+	}
+	switch {
+	case errors.Is(err, ErrWorkerLeaseNotFound):
+		_, err = io.WriteString(w, "NOT_FOUND\r\n")
+	case err != nil:
+		log.Warn(err)
+		err = app.writeErrorOf(w, err)
+	case l == nil:
+		_, err = io.WriteString(w, "RELEASED\r\n")
+	default:
+		_, err = fmt.Fprintf(w, "WORKER %d %s %d\r\n", l.WorkerID, l.Token, l.TTL.Milliseconds())
+	}
+	return err
+}
+
 // MemdValue defines return value for client.
 type MemdValue struct {
 	Keys   []string
@@ -419,15 +850,18 @@ type MemdValue struct {
 
 // MemdStats defines result of STATS command.
 type MemdStats struct {
-	Pid              int    `memd:"pid" json:"pid"`
-	Uptime           int64  `memd:"uptime" json:"uptime"`
-	Time             int64  `memd:"time" json:"time"`
-	Version          string `memd:"version" json:"version"`
-	CurrConnections  int64  `memd:"curr_connections" json:"curr_connections"`
-	TotalConnections int64  `memd:"total_connections" json:"total_connections"`
-	CmdGet           int64  `memd:"cmd_get" json:"cmd_get"`
-	GetHits          int64  `memd:"get_hits" json:"get_hits"`
-	GetMisses        int64  `memd:"get_misses" json:"get_misses"`
+	Pid                 int    `memd:"pid" json:"pid"`
+	Uptime              int64  `memd:"uptime" json:"uptime"`
+	Time                int64  `memd:"time" json:"time"`
+	Version             string `memd:"version" json:"version"`
+	CurrConnections     int64  `memd:"curr_connections" json:"curr_connections"`
+	TotalConnections    int64  `memd:"total_connections" json:"total_connections"`
+	CmdGet              int64  `memd:"cmd_get" json:"cmd_get"`
+	GetHits             int64  `memd:"get_hits" json:"get_hits"`
+	GetMisses           int64  `memd:"get_misses" json:"get_misses"`
+	WorkerIDFallback    int64  `memd:"worker_id_fallback" json:"worker_id_fallback"`
+	WorkerIDConflict    int64  `memd:"worker_id_conflict" json:"worker_id_conflict"`
+	RejectedConnections int64  `memd:"rejected_connections" json:"rejected_connections"`
 }
 
 // WriteTo writes content of MemdValue to io.Writer.
@@ -450,28 +884,5 @@ func (v MemdValue) WriteTo(w io.Writer) (int64, error) {
 
 // WriteTo writes result of STATS command to io.Writer.
 func (s MemdStats) WriteTo(w io.Writer) (int64, error) {
-	statsValue := reflect.ValueOf(s)
-	statsType := reflect.TypeOf(s)
-	for i := 0; i < statsType.NumField(); i++ {
-		w.Write(memdStatHeader)
-		field := statsType.Field(i)
-		if tag := field.Tag.Get("memd"); tag != "" {
-			io.WriteString(w, tag)
-		} else {
-			io.WriteString(w, strings.ToUpper(field.Name))
-		}
-		w.Write(memdSpc)
-		v := statsValue.FieldByIndex(field.Index).Interface()
-		switch _v := v.(type) {
-		case int:
-			io.WriteString(w, strconv.Itoa(_v))
-		case int64:
-			io.WriteString(w, strconv.FormatInt(int64(_v), 10))
-		case string:
-			io.WriteString(w, string(_v))
-		}
-		w.Write(memdSep)
-	}
-	n, err := w.Write(memdValFooter)
-	return int64(n), err
+	return s.list().WriteTo(w)
 }
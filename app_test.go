@@ -319,6 +319,9 @@ STAT total_connections 123
 STAT cmd_get 399
 STAT get_hits 396
 STAT get_misses 3
+STAT worker_id_fallback 0
+STAT worker_id_conflict 0
+STAT rejected_connections 0
 END
 `
 	expected = strings.Replace(expected, "\n", "\r\n", -1)
@@ -682,14 +685,15 @@ func TestAppBinaryError(t *testing.T) {
 	}
 
 	expected := []byte{
-		0x81, 0x00, 0x00, 0x00,
-		//          status: Internal Error
-		0x00, 0x00, 0x00, 0x84,
-		0x00, 0x00, 0x00, 0x00,
+		0x81, 0x02, 0x00, 0x00,
+		//          status: Unknown command
+		0x00, 0x00, 0x00, 0x81,
+		0x00, 0x00, 0x00, 0x12,
 		0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00,
 		0x00, 0x00, 0x00, 0x00,
 	}
+	expected = append(expected, "unknown command: 2"...)
 
 	resp, err := client.Command(cmd)
 	if bytes.Compare(resp, expected) != 0 {
@@ -878,3 +882,54 @@ func TestAppBinaryCancel(t *testing.T) {
 		}
 	}
 }
+
+type errorGenerator struct {
+	err error
+}
+
+func (g errorGenerator) NextID() (uint64, error) {
+	return 0, g.err
+}
+
+func (g errorGenerator) WorkerID() uint {
+	return 0
+}
+
+func newTestAppFailing(ctx context.Context, t testing.TB, err error) *App {
+	app, _ := NewAppWithGenerator(errorGenerator{err}, 0)
+	l, _ := app.ListenerTCP("localhost:0")
+	go app.Serve(ctx, l)
+	<-app.Ready()
+	return app
+}
+
+func TestAppErrorResponses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppFailing(ctx, t, ErrClockRollbacked)
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd    string
+		expect string
+	}{
+		{"GET id", "SERVER_ERROR system clock was rollbacked\r\n"},
+		{"GET", "CLIENT_ERROR GET command needs key as second parameter\r\n"},
+		{"GET range:0", "CLIENT_ERROR invalid number of IDs: 0, n should be between 1 and 100000\r\n"},
+		{"WORKER FOO", "CLIENT_ERROR unknown WORKER command: FOO\r\n"},
+		{"WORKER LEASE", "SERVER_ERROR worker lease is disabled\r\n"},
+		{"FOO", "ERROR\r\n"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Command(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != tt.expect {
+			t.Errorf("unexpected response of %s: %q", tt.cmd, resp)
+		}
+	}
+}
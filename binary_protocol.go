@@ -3,11 +3,11 @@ package katsubushi
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
-	"reflect"
-	"strconv"
+	"strings"
 	"sync/atomic"
 )
 
@@ -16,10 +16,45 @@ const (
 	magicRequest  = 0x80
 	magicResponse = 0x81
 	opcodeGet     = 0x00
+	opcodeQuit    = 0x07
+	opcodeGetQ    = 0x09
+	opcodeNoop    = 0x0a
 	opcodeVersion = 0x0b
+	opcodeGetK    = 0x0c
+	opcodeGetKQ   = 0x0d
 	opcodeStat    = 0x10
+	opcodeQuitQ   = 0x17
 )
 
+// response statuses
+var (
+	statusKeyNotFound      = [2]byte{0x00, 0x01}
+	statusInvalidArguments = [2]byte{0x00, 0x04}
+	statusUnknownCommand   = [2]byte{0x00, 0x81}
+	statusInternalError    = [2]byte{0x00, 0x84}
+	statusBusy             = [2]byte{0x00, 0x85}
+	statusTemporaryFailure = [2]byte{0x00, 0x86}
+)
+
+// binaryStatusOf returns a response status for err.
+func binaryStatusOf(err error) [2]byte {
+	switch {
+	case isInvalidArgument(err):
+		return statusInvalidArguments
+	case errors.Is(err, ErrClockRollbacked), errors.Is(err, ErrWorkerIDConflicted), errors.Is(err, ErrWorkerIDUnconfirmed),
+		errors.Is(err, ErrHandedOver):
+		return statusTemporaryFailure
+	case errors.Is(err, ErrRateLimited):
+		return statusBusy
+	}
+	return statusInternalError
+}
+
+// isQuietOpcode reports whether responses of opcode can be buffered until a non-quiet command.
+func isQuietOpcode(opcode byte) bool {
+	return opcode == opcodeGetQ || opcode == opcodeGetKQ || opcode == opcodeQuitQ
+}
+
 type bRequest struct {
 	magic    byte
 	opcode   byte
@@ -190,30 +225,68 @@ func (app *App) IsBinaryProtocol(r *bufio.Reader) (bool, error) {
 // RespondToBinary responds to a binary request with a binary response.
 // A request should be read from r, not conn.
 // Because the request reader might be buffered.
+// Responses of quiet commands (GETQ, GETKQ) are buffered and flushed together with a following
+// non-quiet command like NOOP.
 func (app *App) RespondToBinary(r io.Reader, conn net.Conn) {
+	w := bufio.NewWriter(conn)
+	authenticated := app.saslAuth == nil
+	var user string
 	for {
 		app.extendDeadline(conn)
 
 		req, err := newBRequest(r)
 		if err != nil {
-			if err != io.EOF {
+			if err != io.EOF && !app.isDraining() {
 				log.Warn(err)
 			}
+			w.Flush()
 			return
 		}
 
+		if app.saslAuth != nil && isSASLOpcode(req.opcode) {
+			u, err := app.respondToSASL(req, w)
+			if u != "" {
+				authenticated, user = true, u
+			}
+			if ferr := w.Flush(); err != nil || ferr != nil {
+				return
+			}
+			continue
+		}
+		if !authenticated && !allowedWithoutAuth(req.opcode) {
+			err := app.writeBinaryError(w, req.opcode, req.opaque, statusAuthError, ErrAuthRequired)
+			if ferr := w.Flush(); err != nil || ferr != nil {
+				return
+			}
+			continue
+		}
+
 		cmd, err := app.BytesToBinaryCmd(*req)
 		if err != nil {
-			if err := app.writeBinaryError(conn); err != nil {
+			if err := app.writeBinaryError(w, req.opcode, req.opaque, statusUnknownCommand, err); err != nil {
 				log.Warnf("error on write error: %s", err)
 				return
 			}
-			continue
+		} else if err := app.limitRate(conn.RemoteAddr(), user, cmd); err != nil {
+			log.Warn(err)
+			if err := app.writeBinaryError(w, req.opcode, req.opaque, binaryStatusOf(err), err); err != nil {
+				log.Warnf("error on write error: %s", err)
+				return
+			}
+		} else {
+			app.countCmd(conn)
+			if err := cmd.Execute(app, w); err != nil {
+				if err == io.EOF {
+					// QUIT
+					w.Flush()
+				} else {
+					log.Warnf("error on execute cmd %s: %s", cmd, err)
+				}
+				return
+			}
 		}
-		w := bufio.NewWriter(conn)
-		if err := cmd.Execute(app, w); err != nil {
-			log.Warnf("error on execute cmd %s: %s", cmd, err)
-			return
+		if isQuietOpcode(req.opcode) {
+			continue
 		}
 		if err := w.Flush(); err != nil {
 			if err != io.EOF {
@@ -224,31 +297,35 @@ func (app *App) RespondToBinary(r io.Reader, conn net.Conn) {
 	}
 }
 
-func (app *App) writeBinaryError(w io.Writer) error {
-	// TODO: Opcode, Opaque and Status are static and not accurate. It's better to make them dynamic
-	// opcode: GET, it should be a requested opcode
-	// opaque: zero padding, it should be a requested opaque
-	res := newBResponse(opcodeGet, [4]byte{0x00, 0x00, 0x00, 0x00}, bResponseConfig{
-		// status: Internal Error, it should be determined by a request or server condition
-		status: [2]byte{0x00, 0x84},
+// writeBinaryError writes an error response to the request of opcode and opaque, with the message of reason.
+func (app *App) writeBinaryError(w io.Writer, opcode byte, opaque [4]byte, status [2]byte, reason error) error {
+	res := newBResponse(opcode, opaque, bResponseConfig{
+		status: status,
+		value:  reason.Error(),
 	})
-
-	n, err := w.Write(res.Bytes())
-	if n < len(respError) {
-		return fmt.Errorf("failed to write error response")
-	}
+	_, err := w.Write(res.Bytes())
 	return err
 }
 
 // BytesToCmd converts byte array to a MemdBCmd and returns it.
 func (app *App) BytesToBinaryCmd(req bRequest) (cmd MemdCmd, err error) {
 	switch req.opcode {
-	case opcodeGet:
+	case opcodeGet, opcodeGetQ, opcodeGetK, opcodeGetKQ:
 		atomic.AddInt64(&(app.cmdGet), 1)
 		cmd = &MemdBCmdGet{
-			Name:   "GET",
+			Name:   binaryGetNames[req.opcode],
 			Key:    req.key,
 			Opaque: req.opaque,
+			Opcode: req.opcode,
+		}
+	case opcodeNoop:
+		cmd = &MemdBCmdNoop{
+			Opaque: req.opaque,
+		}
+	case opcodeQuit, opcodeQuitQ:
+		cmd = &MemdBCmdQuit{
+			Opaque: req.opaque,
+			Quiet:  req.opcode == opcodeQuitQ,
 		}
 	case opcodeVersion:
 		cmd = &MemdBCmdVersion{
@@ -260,41 +337,82 @@ func (app *App) BytesToBinaryCmd(req bRequest) (cmd MemdCmd, err error) {
 			Opaque: req.opaque,
 		}
 	default:
-		err = fmt.Errorf("unknown binary command: %x", req.opcode)
+		err = fmt.Errorf("unknown command: %x", req.opcode)
 	}
 	return
 }
 
-// MemdCmdGet defines binary Get command.
+var binaryGetNames = map[byte]string{
+	opcodeGet:   "GET",
+	opcodeGetQ:  "GETQ",
+	opcodeGetK:  "GETK",
+	opcodeGetKQ: "GETKQ",
+}
+
+// MemdCmdGet defines binary Get command, and its variants GETQ, GETK and GETKQ.
 type MemdBCmdGet struct {
 	Name   string
 	Key    string
 	Opaque [4]byte
+	Opcode byte
 }
 
 // Execute generates new ID.
 func (cmd *MemdBCmdGet) Execute(app *App, w io.Writer) error {
-	id, err := app.NextID()
+	v, err := app.valueOf(cmd.Key)
 	if err != nil {
 		log.Warn(err)
-		if err = app.writeError(w); err != nil {
+		if err = app.writeBinaryError(w, cmd.Opcode, cmd.Opaque, binaryStatusOf(err), err); err != nil {
 			log.Warn("error on write error: %s", err)
 			return err
 		}
 		return nil
 	}
-	log.Debugf("Generated ID: %d", id)
 
-	res := newBResponse(opcodeGet, cmd.Opaque, bResponseConfig{
+	resConf := bResponseConfig{
 		// fixed 4bytes flags is given to GET response
 		extras: []byte{0x00, 0x00, 0x00, 0x00},
-		value:  strconv.FormatUint(id, 10),
-	})
+		value:  v,
+	}
+	// GETK and GETKQ echo the key
+	if cmd.Opcode == opcodeGetK || cmd.Opcode == opcodeGetKQ {
+		resConf.key = cmd.Key
+	}
+	res := newBResponse(cmd.Opcode, cmd.Opaque, resConf)
 
 	_, err2 := w.Write(res.Bytes())
 	return err2
 }
 
+// MemdBCmdNoop defines binary NOOP command.
+type MemdBCmdNoop struct {
+	Opaque [4]byte
+}
+
+// Execute writes NOOP response, which flushes responses of preceding quiet commands.
+func (cmd *MemdBCmdNoop) Execute(app *App, w io.Writer) error {
+	res := newBResponse(opcodeNoop, cmd.Opaque, bResponseConfig{})
+	_, err := w.Write(res.Bytes())
+	return err
+}
+
+// MemdBCmdQuit defines binary QUIT and QUITQ command.
+type MemdBCmdQuit struct {
+	Opaque [4]byte
+	Quiet  bool
+}
+
+// Execute writes QUIT response unless quiet, and disconnect by server.
+func (cmd *MemdBCmdQuit) Execute(app *App, w io.Writer) error {
+	if !cmd.Quiet {
+		res := newBResponse(opcodeQuit, cmd.Opaque, bResponseConfig{})
+		if _, err := w.Write(res.Bytes()); err != nil {
+			return err
+		}
+	}
+	return io.EOF
+}
+
 // MemdBCmdVersion defines binary VERSION command.
 type MemdBCmdVersion struct {
 	Opaque [4]byte
@@ -316,43 +434,24 @@ type MemdBCmdStat struct {
 	Opaque [4]byte
 }
 
-// Execute writes binary stat
+// Execute writes binary stat. The key is a subcommand same as text protocol, settings, conns, generator or reset.
 // ref. https://github.com/memcached/memcached/wiki/BinaryProtocolRevamped#stat
 func (cmd *MemdBCmdStat) Execute(app *App, w io.Writer) error {
-	// ignore optional key (items, slabs) for now
-	s := app.GetStats()
-	return s.writeBinaryTo(w, cmd.Opaque)
+	switch {
+	case cmd.Key == "":
+		s := app.GetStats()
+		return s.writeBinaryTo(w, cmd.Opaque)
+	case strings.ToLower(cmd.Key) == "reset":
+		app.ResetStats()
+		return memdStatList{}.writeBinaryTo(w, cmd.Opaque)
+	}
+	l, err := app.statsOf(cmd.Key)
+	if err != nil {
+		return app.writeBinaryError(w, opcodeStat, cmd.Opaque, statusKeyNotFound, err)
+	}
+	return l.writeBinaryTo(w, cmd.Opaque)
 }
 
 func (s MemdStats) writeBinaryTo(w io.Writer, opaque [4]byte) error {
-	statsValue := reflect.ValueOf(s)
-	statsType := reflect.TypeOf(s)
-	for i := 0; i < statsType.NumField(); i++ {
-		field := statsType.Field(i)
-		tag := field.Tag.Get("memd")
-		if tag == "" {
-			continue
-		}
-		var val string
-		v := statsValue.FieldByIndex(field.Index).Interface()
-		switch _v := v.(type) {
-		case int:
-			val = strconv.Itoa(_v)
-		case int64:
-			val = strconv.FormatInt(int64(_v), 10)
-		case string:
-			val = string(_v)
-		}
-		res := newBResponse(opcodeStat, opaque, bResponseConfig{
-			key:   tag,
-			value: val,
-		})
-		if _, err := w.Write(res.Bytes()); err != nil {
-			return err
-		}
-	}
-	// to teminate the sequence
-	emptyRes := newBResponse(opcodeStat, opaque, bResponseConfig{})
-	_, err := w.Write(emptyRes.Bytes())
-	return err
+	return s.list().writeBinaryTo(w, opaque)
 }
@@ -3,9 +3,16 @@ package katsubushi
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestBResponseBytes(t *testing.T) {
@@ -332,6 +339,36 @@ func TestMemdStats_writeBinaryTo(t *testing.T) {
 		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // CAS
 		0x67, 0x65, 0x74, 0x5f, 0x6d, 0x69, 0x73, 0x73, 0x65, 0x73, // Key
 		0x31, // Value
+		// Next field
+		0x81, 0x10, // response Magic, Opcode
+		0x00, 0x12, // Key length
+		0x00, 0x00, 0x00, 0x00, // Extra Length(1), Data type(1), VBucket(2)
+		0x00, 0x00, 0x00, 0x13, // Total body
+		0x00, 0x00, 0x00, 0x00, // Opaque
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // CAS
+		0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, // Key
+		0x5f, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b,
+		0x30, // Value
+		// Next field
+		0x81, 0x10, // response Magic, Opcode
+		0x00, 0x12, // Key length
+		0x00, 0x00, 0x00, 0x00, // Extra Length(1), Data type(1), VBucket(2)
+		0x00, 0x00, 0x00, 0x13, // Total body
+		0x00, 0x00, 0x00, 0x00, // Opaque
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // CAS
+		0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, // Key
+		0x5f, 0x63, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74,
+		0x30, // Value
+		// Next field
+		0x81, 0x10, // response Magic, Opcode
+		0x00, 0x14, // Key length
+		0x00, 0x00, 0x00, 0x00, // Extra Length(1), Data type(1), VBucket(2)
+		0x00, 0x00, 0x00, 0x15, // Total body
+		0x00, 0x00, 0x00, 0x00, // Opaque
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // CAS
+		0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x5f, 0x63, // Key
+		0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73,
+		0x30, // Value
 		// Last empty field
 		0x81, 0x10, // response Magic, Opcode
 		0x00, 0x00, // Key length
@@ -344,3 +381,141 @@ func TestMemdStats_writeBinaryTo(t *testing.T) {
 		t.Errorf("got: \n%#v,\nexpect: %#v\n", g, e)
 	}
 }
+
+func newTestBRequest(opcode byte, opaque byte, key string) []byte {
+	req := make([]byte, headerSize+len(key))
+	req[0] = magicRequest
+	req[1] = opcode
+	binary.BigEndian.PutUint16(req[2:4], uint16(len(key)))
+	binary.BigEndian.PutUint32(req[8:12], uint32(len(key)))
+	req[15] = opaque
+	copy(req[headerSize:], key)
+	return req
+}
+
+func readTestBResponse(t *testing.T, r io.Reader) *bResponse {
+	t.Helper()
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[8:12]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	keyLen := int(binary.BigEndian.Uint16(header[2:4]))
+	extraLen := int(header[4])
+	res := &bResponse{
+		magic:  header[0],
+		opcode: header[1],
+		extras: body[:extraLen],
+		key:    string(body[extraLen : extraLen+keyLen]),
+		value:  string(body[extraLen+keyLen:]),
+	}
+	copy(res.status[:], header[6:8])
+	copy(res.opaque[:], header[12:16])
+	return res
+}
+
+func TestAppBinaryGetVariants(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.Write(newTestBRequest(opcodeGetK, 1, "hoge"))
+	res := readTestBResponse(t, conn)
+	if res.opcode != opcodeGetK || res.key != "hoge" || res.opaque[3] != 1 {
+		t.Errorf("unexpected GETK response: %#v", res)
+	}
+	if _, err := strconv.ParseUint(res.value, 10, 64); err != nil {
+		t.Errorf("invalid id: %s", err)
+	}
+
+	// quiet responses are not flushed until NOOP
+	conn.Write(newTestBRequest(opcodeGetKQ, 2, "foo"))
+	conn.Write(newTestBRequest(opcodeGetQ, 3, "bar"))
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if n, err := conn.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Errorf("responses of quiet commands must be buffered: %d %v", n, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	conn.Write(newTestBRequest(opcodeNoop, 4, ""))
+
+	expected := []struct {
+		opcode byte
+		opaque byte
+		key    string
+	}{
+		{opcodeGetKQ, 2, "foo"},
+		{opcodeGetQ, 3, ""},
+		{opcodeNoop, 4, ""},
+	}
+	for _, e := range expected {
+		res := readTestBResponse(t, conn)
+		if res.opcode != e.opcode || res.opaque[3] != e.opaque || res.key != e.key {
+			t.Errorf("unexpected response: %#v", res)
+		}
+	}
+}
+
+func TestAppBinaryQuit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+
+	for _, opcode := range []byte{opcodeQuit, opcodeQuitQ} {
+		conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(newTestBRequest(opcode, 1, ""))
+		if opcode == opcodeQuit {
+			if res := readTestBResponse(t, conn); res.opcode != opcodeQuit || res.opaque[3] != 1 {
+				t.Errorf("unexpected QUIT response: %#v", res)
+			}
+		}
+		if _, err := conn.Read(make([]byte, 1)); err != io.EOF {
+			t.Errorf("connection must be closed by %x: %v", opcode, err)
+		}
+		conn.Close()
+	}
+}
+
+func TestAppBinaryErrorResponses(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		err    error
+		key    string
+		status [2]byte
+		value  string
+	}{
+		{ErrClockRollbacked, "id", statusTemporaryFailure, "system clock was rollbacked"},
+		{ErrWorkerIDConflicted, "id", statusTemporaryFailure, ErrWorkerIDConflicted.Error()},
+		{errors.New("failure"), "id", statusInternalError, "failure"},
+		{nil, "range:x", statusInvalidArguments, "invalid range key: range:x"},
+	}
+	for _, tt := range tests {
+		app := newTestAppFailing(ctx, t, tt.err)
+		conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(newTestBRequest(opcodeGetKQ, 5, tt.key))
+		conn.Write(newTestBRequest(opcodeNoop, 6, ""))
+		res := readTestBResponse(t, conn)
+		if res.opcode != opcodeGetKQ || res.opaque[3] != 5 || res.status != tt.status || res.value != tt.value {
+			t.Errorf("unexpected error response: %#v", res)
+		}
+		if res := readTestBResponse(t, conn); res.opcode != opcodeNoop {
+			t.Errorf("unexpected NOOP response: %#v", res)
+		}
+		conn.Close()
+	}
+}
@@ -1,7 +1,10 @@
 package katsubushi
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"fmt"
 	"strconv"
 	"time"
 
@@ -36,6 +39,20 @@ func (c *Client) SetTimeout(t time.Duration) {
 	}
 }
 
+// SetAuthToken sets a shared token to authenticate text protocol connections. See SASLAuth.
+func (c *Client) SetAuthToken(token string) {
+	for _, mc := range c.memcacheClients {
+		mc.SetAuthToken(token)
+	}
+}
+
+// SetTLSConfig enables TLS to connect to katsubushi servers with cfg.
+func (c *Client) SetTLSConfig(cfg *tls.Config) {
+	for _, mc := range c.memcacheClients {
+		mc.SetTLSConfig(cfg)
+	}
+}
+
 // Fetch fetches id from katsubushi
 func (c *Client) Fetch(ctx context.Context) (uint64, error) {
 	errs := errors.New("no servers available")
@@ -80,3 +97,87 @@ func (c *Client) FetchMulti(ctx context.Context, n int) ([]uint64, error) {
 	}
 	return nil, errs
 }
+
+// FetchRange fetches n IDs as contiguous ranges from katsubushi.
+// IDs are expanded lazily by IDRanges.
+func (c *Client) FetchRange(ctx context.Context, n int) (*IDRanges, error) {
+	key := RangeKeyPrefix + strconv.Itoa(n)
+	errs := errors.New("no servers available")
+	for _, mc := range c.memcacheClients {
+		var ranges []IDRange
+		err := retry.Retry(2, 0, func() error {
+			value, _err := mc.GetBytes(ctx, key)
+			if _err != nil {
+				return _err
+			}
+			ranges, _err = parseIDRanges(string(value))
+			return _err
+		})
+		if err != nil {
+			errs = errors.Wrap(errs, err.Error())
+			continue
+		}
+		return NewIDRanges(ranges), nil
+	}
+	return nil, errs
+}
+
+// LeaseWorker leases a worker ID to generate IDs locally. See LeasedGenerator.
+func (c *Client) LeaseWorker(ctx context.Context) (*WorkerLease, error) {
+	errs := errors.New("no servers available")
+	for i, mc := range c.memcacheClients {
+		l, err := workerCommand(ctx, mc, "WORKER LEASE")
+		if err != nil {
+			errs = errors.Wrap(errs, err.Error())
+			continue
+		}
+		l.server = i
+		return l, nil
+	}
+	return nil, errs
+}
+
+// RenewWorker renews the lease of the worker ID on the server which leased it.
+func (c *Client) RenewWorker(ctx context.Context, l *WorkerLease) (*WorkerLease, error) {
+	renewed, err := workerCommand(ctx, c.memcacheClients[l.server], fmt.Sprintf("WORKER RENEW %d %s", l.WorkerID, l.Token))
+	if err != nil {
+		return nil, err
+	}
+	renewed.server = l.server
+	return renewed, nil
+}
+
+// ReleaseWorker releases the lease of the worker ID on the server which leased it.
+func (c *Client) ReleaseWorker(ctx context.Context, l *WorkerLease) error {
+	_, err := workerCommand(ctx, c.memcacheClients[l.server], fmt.Sprintf("WORKER RELEASE %d %s", l.WorkerID, l.Token))
+	return err
+}
+
+func workerCommand(ctx context.Context, mc *memcacheClient, line string) (*WorkerLease, error) {
+	res, err := mc.Command(ctx, line)
+	if err != nil {
+		return nil, err
+	}
+	fields := bytes.Fields(res)
+	switch {
+	case len(fields) == 1 && string(fields[0]) == "RELEASED":
+		return nil, nil
+	case len(fields) == 1 && string(fields[0]) == "NOT_FOUND":
+		return nil, ErrWorkerLeaseNotFound
+	case len(fields) == 4 && string(fields[0]) == "WORKER":
+		id, err := strconv.ParseUint(string(fields[1]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		ttl, err := strconv.ParseInt(string(fields[3]), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &WorkerLease{
+			WorkerID: uint(id),
+			Token:    string(fields[2]),
+			TTL:      time.Duration(ttl) * time.Millisecond,
+		}, nil
+	}
+	return nil, errors.Errorf("unexpected response: %s", res)
+}
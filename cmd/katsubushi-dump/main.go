@@ -1,34 +1,107 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	_ "github.com/go-sql-driver/mysql"
 	katsubushi "github.com/kayac/go-katsubushi/v2"
+	_ "modernc.org/sqlite"
 )
 
 type Dump struct {
-	Time     time.Time `json:"time"`
-	WorkerID uint64    `json:"worker_id"`
-	Sequence uint64    `json:"sequence"`
+	Time     time.Time         `json:"time"`
+	WorkerID uint64            `json:"worker_id"`
+	Sequence uint64            `json:"sequence"`
+	IssuedBy *katsubushi.Lease `json:"issued_by,omitempty"`
 }
 
 func main() {
-	if len(os.Args) < 2 {
+	var (
+		redisURL  string
+		sqlDriver string
+		sqlDSN    string
+		sqlTable  string
+		raftPeers string
+	)
+	flag.StringVar(&redisURL, "redis", "", "URL of Redis to look up the host which issued the IDs")
+	flag.StringVar(&sqlDriver, "sql-driver", "", "database driver to look up the host which issued the IDs (mysql or sqlite)")
+	flag.StringVar(&sqlDSN, "sql-dsn", "", "data source name of the database")
+	flag.StringVar(&sqlTable, "sql-table", katsubushi.DefaultSQLTable, "table name of worker ids")
+	flag.StringVar(&raftPeers, "raft-peers", "", "comma separated addresses of raft members to look up the host which issued the IDs. the secret is read from RAFT_SECRET environment variable")
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		fmt.Fprintln(os.Stderr, "no id")
 		os.Exit(1)
 	}
+	registry, err := newRegistry(redisURL, sqlDriver, sqlDSN, sqlTable, raftPeers)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctx := context.Background()
 	enc := json.NewEncoder(os.Stdout)
-	for _, s := range os.Args[1:] {
-		if id, err := strconv.ParseUint(s, 10, 64); err != nil {
+	for _, s := range flag.Args() {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
 			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
-		} else {
-			t, wid, seq := katsubushi.Dump(id)
-			enc.Encode(Dump{t, wid, seq})
 		}
+		t, wid, seq := katsubushi.Dump(id)
+		d := Dump{Time: t, WorkerID: wid, Sequence: seq}
+		if registry != nil {
+			l, err := katsubushi.LookupLease(ctx, registry, uint(wid), t)
+			if err != nil && !errors.Is(err, katsubushi.ErrLeaseNotFound) {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			d.IssuedBy = l
+		}
+		enc.Encode(d)
+	}
+}
+
+func newRegistry(redisURL, sqlDriver, sqlDSN, sqlTable, raftPeers string) (katsubushi.WorkerIDRegistry, error) {
+	n := 0
+	for _, s := range []string{redisURL, sqlDriver, raftPeers} {
+		if s != "" {
+			n++
+		}
+	}
+	switch {
+	case n > 1:
+		return nil, errors.New("-redis, -sql-driver and -raft-peers are exclusive")
+	case redisURL != "":
+		return katsubushi.NewRedisAllocator(redisURL, 0, (1<<katsubushi.WorkerIDBits)-1)
+	case sqlDriver != "":
+		db, err := sql.Open(sqlDriver, sqlDSN)
+		if err != nil {
+			return nil, err
+		}
+		sa, err := katsubushi.NewSQLAllocator(db, 0, (1<<katsubushi.WorkerIDBits)-1)
+		if err != nil {
+			return nil, err
+		}
+		sa.Table = sqlTable
+		return sa, nil
+	case raftPeers != "":
+		secret := os.Getenv("RAFT_SECRET")
+		if secret == "" {
+			return nil, errors.New("RAFT_SECRET is required for -raft-peers")
+		}
+		return &katsubushi.RaftRegistry{
+			Peers:  strings.Split(raftPeers, ","),
+			Secret: []byte(secret),
+		}, nil
 	}
+	return nil, nil
 }
@@ -2,15 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"database/sql"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	stdlog "log"
 	"net"
 	"net/http"
 	"net/http/pprof"
+	"net/url"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -18,7 +23,9 @@ import (
 
 	"github.com/fujiwara/raus"
 	stats_api "github.com/fukata/golang-stats-api-handler"
+	_ "github.com/go-sql-driver/mysql"
 	"github.com/kayac/go-katsubushi/v2"
+	_ "modernc.org/sqlite"
 )
 
 type profConfig struct {
@@ -40,10 +47,16 @@ func init() {
 func main() {
 	var (
 		showVersion bool
-		redisURL    string
-		minWorkerID uint
-		maxWorkerID uint
 		workerID    uint
+		ac          allocConfig
+		gc          gossipConfig
+		lc          workerLeaseConfig
+		saslPwdb    string
+		textToken   string
+		tc          tlsConfig
+		ppc         proxyProtocolConfig
+		rateLimits  string
+		listens     string
 	)
 	pc := &profConfig{}
 	kc := &katsubushi.Config{}
@@ -51,19 +64,60 @@ func main() {
 	flag.UintVar(&workerID, "worker-id", 0, "worker id. muset be unique.")
 	flag.IntVar(&kc.Port, "port", 11212, "port to listen.")
 	flag.StringVar(&kc.Sockpath, "sock", "", "unix domain socket to listen. ignore port option when set this.")
+	flag.StringVar(&listens, "listen", "", "comma separated addresses to listen memcached protocol, as tcp://:11212, tcp6://[::1]:11212 or unix:///path/to/sock?mode=0660. ignore port and sock options when set this.")
 	flag.DurationVar(&kc.IdleTimeout, "idle-timeout", katsubushi.DefaultIdleTimeout, "connection will be closed if there are no packets over the seconds. 0 means infinite.")
+	flag.DurationVar(&kc.ShutdownTimeout, "shutdown-timeout", katsubushi.DefaultShutdownTimeout, "grace period to drain connections on shutdown. connections remaining after it are closed.")
 	flag.StringVar(&kc.LogLevel, "log-level", "info", "log level (panic, fatal, error, warn, info = Default, debug)")
 	flag.IntVar(&kc.HTTPPort, "http-port", 0, "port to listen http server. 0 means disable.")
+	flag.BoolVar(&kc.Multiplex, "multiplex", false, "serve http (HTTP/1.1) and grpc (HTTP/2) on the port of memcached protocol too")
 	flag.IntVar(&kc.GRPCPort, "grpc-port", 0, "port to listen grpc server. 0 means disable.")
+	flag.IntVar(&kc.MaxConnections, "max-connections", 0, "maximum number of connections over all listeners. 0 means unlimited.")
+	flag.IntVar(&kc.MaxMemcacheConnections, "max-memcache-connections", 0, "maximum number of connections of memcached protocol. 0 means unlimited.")
+	flag.IntVar(&kc.MaxHTTPConnections, "max-http-connections", 0, "maximum number of connections of http server. 0 means unlimited.")
+	flag.IntVar(&kc.MaxGRPCConnections, "max-grpc-connections", 0, "maximum number of connections of grpc server. 0 means unlimited.")
 
 	flag.BoolVar(&pc.enablePprof, "enable-pprof", false, "")
 	flag.BoolVar(&pc.enableStats, "enable-stats", false, "")
 	flag.IntVar(&pc.debugPort, "debug-port", 8080, "port to listen for debug")
 
 	flag.BoolVar(&showVersion, "version", false, "show version number")
-	flag.StringVar(&redisURL, "redis", "", "URL of Redis for automated worker id allocation")
-	flag.UintVar(&minWorkerID, "min-worker-id", 0, "minimum automated worker id")
-	flag.UintVar(&maxWorkerID, "max-worker-id", 0, "maximum automated worker id")
+	flag.StringVar(&ac.redisURL, "redis", "", "URL of Redis for automated worker id allocation")
+	flag.UintVar(&ac.minWorkerID, "min-worker-id", 0, "minimum automated worker id")
+	flag.UintVar(&ac.maxWorkerID, "max-worker-id", 0, "maximum automated worker id")
+	flag.StringVar(&ac.cachePath, "worker-id-cache", "", "file to remember the last automated worker id to reuse it after restart")
+	flag.StringVar(&ac.policy, "allocation-policy", string(katsubushi.AllocationPolicyWait), "behavior when automated worker id allocation is unavailable at startup (wait, fail or fallback)")
+	flag.DurationVar(&ac.timeout, "allocation-timeout", 30*time.Second, "timeout of automated worker id allocation for -allocation-policy fail and fallback")
+	flag.StringVar(&ac.fallbackMap, "fallback-worker-ids", "", "JSON or YAML file which maps hosts to emergency worker ids for -allocation-policy fallback")
+	flag.StringVar(&ac.mapFile, "worker-id-map", "", "JSON or YAML file which maps hosts to worker ids")
+	flag.StringVar(&ac.mapKey, "worker-id-map-key", "hostname", "key to look up -worker-id-map and -fallback-worker-ids (hostname, machine-id or env:NAME)")
+	flag.StringVar(&ac.sql.driver, "sql-driver", "", "SQL driver for automated worker id allocation (mysql or sqlite)")
+	flag.StringVar(&ac.sql.dsn, "sql-dsn", "", "data source name of SQL database for automated worker id allocation")
+	flag.StringVar(&ac.sql.table, "sql-table", katsubushi.DefaultSQLTable, "table name for automated worker id allocation")
+	flag.StringVar(&ac.ip.iface, "worker-id-interface", "", "network interface to derive worker id from its IPv4 address")
+	flag.UintVar(&ac.ip.mask, "worker-id-ip-mask", (1<<katsubushi.WorkerIDBits)-1, "mask applied to the IPv4 address to derive worker id")
+	flag.UintVar(&ac.ip.offset, "worker-id-ip-offset", 0, "offset added to the masked IPv4 address to derive worker id")
+	flag.StringVar(&ac.raft.addr, "raft-addr", "", "address to listen raft for automated worker id allocation.")
+	flag.StringVar(&ac.raft.peers, "raft-peers", "", "comma separated addresses of initial raft members. a node not in the list joins the cluster.")
+	flag.StringVar(&ac.raft.secret, "raft-secret", "", "secret shared among raft members to authenticate them")
+	flag.StringVar(&ac.raft.dir, "raft-dir", "", "directory to store the state of raft")
+	flag.StringVar(&ac.raft.advertise, "raft-advertise", "", "address of raft advertised to peers. defaults to the entry of -raft-peers resolved to -raft-addr.")
+	flag.StringVar(&gc.addr, "gossip-addr", "", "address to listen gossip of worker ids among peers. empty means disable.")
+	flag.StringVar(&gc.network, "gossip-network", "udp", "network of gossip (udp or tcp)")
+	flag.StringVar(&gc.peers, "gossip-peers", "", "comma separated addresses of peers to gossip worker ids")
+	flag.DurationVar(&gc.interval, "gossip-interval", katsubushi.DefaultGossipInterval, "interval of gossip to peers")
+	flag.StringVar(&gc.key, "gossip-key", "", "key shared among peers to sign gossip")
+	flag.UintVar(&lc.minWorkerID, "worker-lease-min-worker-id", 0, "minimum worker id to lease to clients generating ids locally")
+	flag.UintVar(&lc.maxWorkerID, "worker-lease-max-worker-id", 0, "maximum worker id to lease to clients generating ids locally. 0 means disable.")
+	flag.DurationVar(&lc.ttl, "worker-lease-ttl", katsubushi.DefaultWorkerLeaseTTL, "TTL of worker ids leased to clients")
+	flag.StringVar(&saslPwdb, "sasl-pwdb", "", "file of \"user:password\" lines to require SASL PLAIN authentication on binary protocol")
+	flag.StringVar(&textToken, "text-auth-token", "", "shared token to authenticate text protocol by \"AUTH <token>\" with -sasl-pwdb. empty means text protocol is disabled.")
+	flag.StringVar(&tc.certFile, "tls-cert", "", "certificate file to enable TLS on all listeners. reloaded when modified.")
+	flag.StringVar(&tc.keyFile, "tls-key", "", "private key file of -tls-cert")
+	flag.StringVar(&tc.clientCAFile, "tls-client-ca", "", "CA certificates file to require and verify client certificates (mutual TLS)")
+	flag.BoolVar(&ppc.enable, "proxy-protocol", false, "accept PROXY protocol v1/v2 headers on all listeners")
+	flag.StringVar(&ppc.trusted, "proxy-protocol-trusted", "", "comma separated CIDRs or IP addresses of proxies allowed to send PROXY protocol headers. required by -proxy-protocol")
+	flag.BoolVar(&ppc.required, "proxy-protocol-required", false, "reject connections without PROXY protocol headers and connections from untrusted sources")
+	flag.StringVar(&rateLimits, "rate-limits", "", "JSON or YAML file of rate limits on IDs per second for each client address, SASL user or namespace")
 	flag.VisitAll(envToFlag)
 	flag.Parse()
 
@@ -77,21 +131,95 @@ func main() {
 		os.Exit(1)
 	}
 	log = katsubushi.StdLogger()
+	raus.SetLogger(log)
+
+	if listens != "" {
+		if kc.Sockpath != "" {
+			log.Println("-listen and -sock are exclusive")
+			os.Exit(1)
+		}
+		for _, s := range splitList(listens) {
+			a, err := katsubushi.ParseListenAddr(s)
+			if err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
+			kc.Listens = append(kc.Listens, a)
+		}
+	}
+
+	inherited, err := katsubushi.InheritFromParent()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	if inherited != nil {
+		err = applyListeners(kc, inherited.Listeners, "the parent process")
+		ac.inheritedWorkerID = inherited.WorkerID
+	} else {
+		var ls map[string][]net.Listener
+		if ls, err = katsubushi.SystemdListeners(); err == nil {
+			err = applyListeners(kc, ls, "systemd")
+		}
+	}
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+
+	tlsConf, err := newTLSConfig(tc)
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+	kc.TLSConfig = tlsConf
 
 	var wg sync.WaitGroup
+	// held is goroutines holding the worker id and addresses exclusively, released before the handoff on upgrade
+	var held sync.WaitGroup
+	var errs []error
 	ctx, cancel := context.WithCancel(context.Background())
 
 	wg.Add(1)
 	go signalHandler(ctx, cancel, &wg)
 
+	if inherited != nil {
+		// not to allocate the worker id and issue ids until the parent process hands over
+		log.Printf("Waiting for the parent process %d to hand over", inherited.ParentPID)
+		if err := inherited.WaitParent(ctx); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	// the backend holding worker ids for the automated assignment and leases to clients
+	var reg katsubushi.WorkerIDRegisterer
+	if workerID == 0 || lc.maxWorkerID != 0 {
+		var err error
+		if reg, err = newRegisterer(ac); err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+	}
+
+	var alloc *katsubushi.RetryAllocator
 	if workerID == 0 {
-		if redisURL == "" {
-			fmt.Println("please set -worker-id or -redis")
+		if ac.redisURL == "" && ac.sql.driver == "" && ac.raft.addr == "" && ac.ip.iface == "" && ac.mapFile == "" {
+			fmt.Println("please set -worker-id, -redis, -sql-driver, -raft-addr, -worker-id-interface or -worker-id-map")
 			os.Exit(1)
 		}
 		var err error
-		wg.Add(1)
-		workerID, err = assignWorkerID(ctx, &wg, redisURL, minWorkerID, maxWorkerID)
+		alloc, err = newAllocator(ac, reg)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		held.Add(1)
+		workerID, err = assignWorkerID(ctx, &held, alloc, func(err error) {
+			// stop issuing IDs by the lost worker id
+			errs = append(errs, err)
+			cancel()
+		})
 		if err != nil {
 			log.Println(err)
 			os.Exit(1)
@@ -101,8 +229,8 @@ func main() {
 	// for profiling
 	if pc.enabled() {
 		log.Println("Enabling profiler")
-		wg.Add(1)
-		go profiler(ctx, cancel, &wg, pc)
+		held.Add(1)
+		go profiler(ctx, cancel, &held, pc)
 	}
 
 	app, err := katsubushi.New(workerID)
@@ -110,9 +238,104 @@ func main() {
 		log.Println(err)
 		os.Exit(1)
 	}
+	if alloc != nil {
+		app.SetWorkerIDFallback(alloc.UsedFallback())
+	}
+
+	// duplicated worker id detection
+	if gc.addr != "" {
+		g, err := newGossip(gc, workerID)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		app.SetGossip(g)
+		held.Add(1)
+		go func() {
+			defer held.Done()
+			if err := g.Run(ctx); err != nil {
+				log.Println("Gossip stopped:", err)
+			}
+		}()
+	}
+
+	// worker id leases to clients
+	if lc.maxWorkerID != 0 {
+		l, err := newWorkerLeaser(lc, workerID, reg)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		app.SetWorkerLeaser(l)
+	}
+
+	// authentication
+	if saslPwdb != "" {
+		if kc.Multiplex {
+			// HTTP and gRPC issue IDs without SASL
+			log.Println("-multiplex and -sasl-pwdb are exclusive")
+			os.Exit(1)
+		}
+		a, err := katsubushi.LoadSASLAuth(saslPwdb)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		a.TextToken = textToken
+		app.SetSASLAuth(a)
+	} else if textToken != "" {
+		log.Println("-text-auth-token requires -sasl-pwdb")
+		os.Exit(1)
+	}
+
+	// PROXY protocol
+	if ppc.enable {
+		if ppc.trusted == "" {
+			// trusting all sources lets clients forge their addresses
+			log.Println("-proxy-protocol requires -proxy-protocol-trusted")
+			os.Exit(1)
+		}
+		p, err := katsubushi.NewProxyProtocol(splitList(ppc.trusted), ppc.required)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		app.SetProxyProtocol(p)
+	} else if ppc.trusted != "" || ppc.required {
+		log.Println("-proxy-protocol-trusted and -proxy-protocol-required require -proxy-protocol")
+		os.Exit(1)
+	}
+
+	// rate limits
+	if rateLimits != "" {
+		l, err := katsubushi.LoadRateLimiter(rateLimits)
+		if err != nil {
+			log.Println(err)
+			os.Exit(1)
+		}
+		app.SetRateLimiter(l)
+	}
+
+	// the backend is closed after the worker id is released
+	released := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		held.Wait()
+		if c, ok := reg.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Println(err)
+			}
+		}
+		close(released)
+	}()
+
+	// zero-downtime upgrade
+	if len(upgradeSignals) > 0 {
+		wg.Add(1)
+		go upgradeHandler(ctx, cancel, &wg, app, released)
+	}
 
 	// main server
-	var errs []error
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -123,7 +346,7 @@ func main() {
 	}()
 
 	// http server
-	if kc.HTTPPort != 0 {
+	if kc.HTTPPort != 0 || kc.HTTPListener != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -134,7 +357,7 @@ func main() {
 		}()
 	}
 
-	if kc.GRPCPort != 0 {
+	if kc.GRPCPort != 0 || kc.GRPCListener != nil {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
@@ -146,6 +369,7 @@ func main() {
 	}
 
 	wg.Wait()
+	<-released
 	code := 0
 	if len(errs) > 0 {
 		for _, err := range errs {
@@ -212,30 +436,381 @@ func signalHandler(ctx context.Context, cancel context.CancelFunc, wg *sync.Wait
 	}
 }
 
-func assignWorkerID(ctx context.Context, wg *sync.WaitGroup, redisURL string, min, max uint) (uint, error) {
+// upgradeHandler starts a new process handing over listeners on upgradeSignals, and stops this process.
+// The new process issues ids after the worker id is released, while this process drains connections.
+func upgradeHandler(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup, app *katsubushi.App, released <-chan struct{}) {
 	defer wg.Done()
-	raus.SetLogger(log)
-	defaultMax := uint((1 << katsubushi.WorkerIDBits) - 1)
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, upgradeSignals...)
+	defer signal.Stop(sigCh)
+	for {
+		select {
+		case sig := <-sigCh:
+			log.Printf("Got signal %s, upgrading", sig)
+			if _, err := app.Upgrade(); err != nil {
+				log.Println("Failed to upgrade:", err)
+				continue
+			}
+			cancel()
+			<-released
+			if err := app.Handoff(); err != nil {
+				log.Println(err)
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+type gossipConfig struct {
+	addr     string
+	network  string
+	peers    string
+	interval time.Duration
+	key      string
+}
+
+func newGossip(gc gossipConfig, workerID uint) (*katsubushi.Gossip, error) {
+	if gc.interval <= 0 {
+		return nil, errors.New("-gossip-interval must be positive")
+	}
+	if gc.key == "" {
+		return nil, errors.New("-gossip-key is required for -gossip-addr")
+	}
+	g, err := katsubushi.NewGossip(gc.network, gc.addr, workerID, []byte(gc.key))
+	if err != nil {
+		return nil, err
+	}
+	g.Peers = splitList(gc.peers)
+	g.Interval = gc.interval
+	return g, nil
+}
+
+type workerLeaseConfig struct {
+	minWorkerID uint
+	maxWorkerID uint
+	ttl         time.Duration
+}
+
+func newWorkerLeaser(lc workerLeaseConfig, workerID uint, reg katsubushi.WorkerIDRegisterer) (*katsubushi.WorkerLeaser, error) {
+	if reg == nil {
+		// leases only in memory are lost on restart and conflict among servers
+		return nil, errors.New("-worker-lease-max-worker-id requires -redis, -sql-driver or -raft-addr")
+	}
+	if lc.minWorkerID <= workerID && workerID <= lc.maxWorkerID {
+		return nil, fmt.Errorf("worker id %d must not be in range of -worker-lease-min-worker-id and -worker-lease-max-worker-id", workerID)
+	}
+	l, err := katsubushi.NewWorkerLeaser(lc.minWorkerID, lc.maxWorkerID, lc.ttl)
+	if err != nil {
+		return nil, err
+	}
+	l.Registerer = reg
+	return l, nil
+}
+
+type tlsConfig struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+}
+
+func newTLSConfig(tc tlsConfig) (*tls.Config, error) {
+	if tc.certFile == "" && tc.keyFile == "" {
+		if tc.clientCAFile != "" {
+			return nil, errors.New("-tls-client-ca requires -tls-cert and -tls-key")
+		}
+		return nil, nil
+	}
+	if tc.certFile == "" || tc.keyFile == "" {
+		return nil, errors.New("both of -tls-cert and -tls-key are required")
+	}
+	return katsubushi.NewServerTLSConfig(tc.certFile, tc.keyFile, tc.clientCAFile)
+}
+
+type proxyProtocolConfig struct {
+	enable   bool
+	trusted  string
+	required bool
+}
+
+type ipAllocationConfig struct {
+	iface  string
+	mask   uint
+	offset uint
+}
+
+type sqlAllocationConfig struct {
+	driver string
+	dsn    string
+	table  string
+}
+
+type raftAllocationConfig struct {
+	addr      string
+	peers     string
+	secret    string
+	dir       string
+	advertise string
+}
+
+type allocConfig struct {
+	redisURL    string
+	minWorkerID uint
+	maxWorkerID uint
+	cachePath   string
+	policy      string
+	timeout     time.Duration
+	fallbackMap string
+	mapFile     string
+	mapKey      string
+	sql         sqlAllocationConfig
+	raft        raftAllocationConfig
+	ip          ipAllocationConfig
+
+	// inheritedWorkerID is the worker id of the parent process on upgrade
+	inheritedWorkerID uint
+}
+
+func newAllocator(ac allocConfig, reg katsubushi.WorkerIDRegisterer) (*katsubushi.RetryAllocator, error) {
+	policy, err := katsubushi.ParseAllocationPolicy(ac.policy)
+	if err != nil {
+		return nil, err
+	}
+	var fallback katsubushi.WorkerIDAllocator
+	if policy == katsubushi.AllocationPolicyFallback {
+		if fallback, err = newFallbackAllocator(ac.fallbackMap, ac.mapKey); err != nil {
+			return nil, err
+		}
+	}
+	alloc, err := newBaseAllocator(ac, reg)
+	if err != nil {
+		return nil, err
+	}
+	ra := katsubushi.NewRetryAllocator(alloc, policy, ac.timeout)
+	ra.Fallback = fallback
+	return ra, nil
+}
+
+func newFallbackAllocator(path, keySource string) (katsubushi.WorkerIDAllocator, error) {
+	if path == "" {
+		return nil, errors.New("-fallback-worker-ids is required for -allocation-policy fallback")
+	}
+	r, key, err := lookupWorkerIDMap(path, keySource)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Emergency worker-id for %s: %s", key, r)
+	return katsubushi.NewStaticAllocator(r), nil
+}
+
+func lookupWorkerIDMap(path, keySource string) (katsubushi.WorkerIDRange, string, error) {
+	m, err := katsubushi.LoadWorkerIDMap(path)
+	if err != nil {
+		return katsubushi.WorkerIDRange{}, "", err
+	}
+	key, err := katsubushi.WorkerIDMapKey(keySource)
+	if err != nil {
+		return katsubushi.WorkerIDRange{}, "", err
+	}
+	r, err := m.Lookup(key)
+	if err != nil {
+		return katsubushi.WorkerIDRange{}, "", fmt.Errorf("%s: %w", path, err)
+	}
+	return r, key, nil
+}
+
+// workerIDRange returns the range of worker ids for automated assignment.
+func (ac allocConfig) workerIDRange() (uint, uint) {
+	min, max := ac.minWorkerID, ac.maxWorkerID
 	if min == 0 {
 		min = 1
 	}
 	if max == 0 {
-		max = defaultMax
+		max = (1 << katsubushi.WorkerIDBits) - 1
+	}
+	return min, max
+}
+
+// newRegisterer creates the backend holding worker ids by -redis, -sql-driver or -raft-addr,
+// which is shared by the automated assignment and leases to clients. It returns nil when none of them is set.
+func newRegisterer(ac allocConfig) (katsubushi.WorkerIDRegisterer, error) {
+	min, max := ac.workerIDRange()
+	switch {
+	case exclusive(ac.redisURL != "", ac.sql.driver != "", ac.raft.addr != "", ac.mapFile != ""):
+		return nil, errors.New("-redis, -sql-driver, -raft-addr and -worker-id-map are exclusive")
+	case ac.redisURL != "":
+		ra, err := katsubushi.NewRedisAllocator(ac.redisURL, min, max)
+		if err != nil {
+			return nil, err
+		}
+		return ra, nil
+	case ac.sql.driver != "":
+		sa, err := newSQLAllocator(ac.sql, min, max)
+		if err != nil {
+			return nil, err
+		}
+		return sa, nil
+	case ac.raft.addr != "":
+		ra, err := newRaftAllocator(ac.raft, min, max)
+		if err != nil {
+			return nil, err
+		}
+		return ra, nil
+	}
+	return nil, nil
+}
+
+// backend describes the backend of newRegisterer for logs.
+func (ac allocConfig) backend() string {
+	switch {
+	case ac.redisURL != "":
+		return redactURL(ac.redisURL)
+	case ac.sql.driver != "":
+		return ac.sql.driver
+	}
+	return "raft on " + ac.raft.addr
+}
+
+func newBaseAllocator(ac allocConfig, reg katsubushi.WorkerIDRegisterer) (katsubushi.WorkerIDAllocator, error) {
+	min, max := ac.workerIDRange()
+	switch {
+	case ac.mapFile != "" && ac.ip.iface != "":
+		return nil, errors.New("-worker-id-map and -worker-id-interface are exclusive")
+	case ac.mapFile != "":
+		r, key, err := lookupWorkerIDMap(ac.mapFile, ac.mapKey)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Worker-id for %s in %s: %s", key, ac.mapFile, r)
+		return katsubushi.NewStaticAllocator(r), nil
+	case reg != nil:
+		log.Printf("Waiting for worker-id automated assignment (between %d and %d) with %s", min, max, ac.backend())
+	}
+	if ac.ip.iface == "" {
+		var a katsubushi.WorkerIDAllocator = reg
+		if ac.cachePath != "" {
+			a = katsubushi.NewStickyAllocator(reg, ac.cachePath, min, max)
+		}
+		if id := ac.inheritedWorkerID; id != 0 && min <= id && id <= max {
+			a = katsubushi.NewPreferredAllocator(reg, a, id)
+		}
+		return a, nil
+	}
+	ia := katsubushi.NewIPAllocator(ac.ip.iface)
+	ia.Mask = ac.ip.mask
+	ia.Offset = ac.ip.offset
+	// register the worker id to detect collisions
+	ia.Registerer = reg
+	return ia, nil
+}
+
+// applyListeners sets listeners passed by systemd socket activation or the parent process to kc.
+// Sockets named "http" and "grpc" are for HTTP and gRPC, and the others are for memcached protocol.
+func applyListeners(kc *katsubushi.Config, ls map[string][]net.Listener, from string) error {
+	names := make([]string, 0, len(ls))
+	for name := range ls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		l := ls[name]
+		switch name {
+		case "http", "grpc":
+			if len(l) > 1 {
+				return fmt.Errorf("too many sockets named %s passed by %s", name, from)
+			}
+			if name == "http" {
+				kc.HTTPListener = l[0]
+			} else {
+				kc.GRPCListener = l[0]
+			}
+		default:
+			kc.Listeners = append(kc.Listeners, l...)
+		}
+		log.Printf("Using %d sockets named %s passed by %s", len(l), name, from)
+	}
+	return nil
+}
+
+// splitList splits a comma separated list with trimming spaces.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
 	}
-	if min > max {
-		return 0, errors.New("max-worker-id must be larger than min-worker-id")
+	return list
+}
+
+// exclusive reports whether two or more of conds are true.
+func exclusive(conds ...bool) bool {
+	n := 0
+	for _, c := range conds {
+		if c {
+			n++
+		}
+	}
+	return n > 1
+}
+
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return s
 	}
-	if max > defaultMax {
-		return 0, fmt.Errorf("max-worker-id must be smaller than %d", defaultMax)
+	return u.Redacted()
+}
+
+func newSQLAllocator(sqlc sqlAllocationConfig, min, max uint) (*katsubushi.SQLAllocator, error) {
+	switch sqlc.driver {
+	case "mysql", "sqlite":
+	default:
+		return nil, fmt.Errorf("unsupported -sql-driver %s", sqlc.driver)
 	}
-	log.Printf("Waiting for worker-id automated assignment (between %d and %d) with %s", min, max, redisURL)
-	r, err := raus.New(redisURL, min, max)
+	db, err := sql.Open(sqlc.driver, sqlc.dsn)
 	if err != nil {
-		log.Println("Failed to assign worker-id", err)
-		return 0, err
+		return nil, err
 	}
-	id, ch, err := r.Get(ctx)
+	sa, err := katsubushi.NewSQLAllocator(db, min, max)
 	if err != nil {
+		return nil, err
+	}
+	sa.Table = sqlc.table
+	sa.AutoCreateTable = true
+	return sa, nil
+}
+
+func newRaftAllocator(rc raftAllocationConfig, min, max uint) (*katsubushi.RaftAllocator, error) {
+	peers := splitList(rc.peers)
+	if len(peers) == 0 {
+		return nil, errors.New("-raft-peers is required for -raft-addr")
+	}
+	if rc.dir == "" {
+		return nil, errors.New("-raft-dir is required for -raft-addr")
+	}
+	if rc.secret == "" {
+		return nil, errors.New("-raft-secret is required for -raft-addr")
+	}
+	l, err := net.Listen("tcp", rc.addr)
+	if err != nil {
+		return nil, err
+	}
+	// the raft member keeps running until shutdown to serve other members
+	return katsubushi.NewRaftAllocator(l, katsubushi.RaftConfig{
+		Peers:     peers,
+		Secret:    []byte(rc.secret),
+		DataDir:   rc.dir,
+		Advertise: rc.advertise,
+	}, min, max)
+}
+
+func assignWorkerID(ctx context.Context, wg *sync.WaitGroup, alloc *katsubushi.RetryAllocator, lost func(error)) (uint, error) {
+	defer wg.Done()
+	id, ch, err := alloc.Allocate(ctx)
+	if err != nil {
+		log.Println("Failed to assign worker-id", err)
 		return 0, err
 	}
 	log.Printf("Assigned worker-id: %d", id)
@@ -243,12 +818,8 @@ func assignWorkerID(ctx context.Context, wg *sync.WaitGroup, redisURL string, mi
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		err, more := <-ch
-		if err != nil {
-			panic(err)
-		}
-		if !more {
-			// shutdown
+		if err := <-ch; err != nil {
+			lost(fmt.Errorf("lost worker-id %d: %w", id, err))
 		}
 	}()
 	return id, nil
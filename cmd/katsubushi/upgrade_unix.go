@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignals are signals to upgrade the binary without downtime.
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
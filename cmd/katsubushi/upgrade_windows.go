@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// upgradeSignals are empty because listeners can not be handed over on Windows.
+var upgradeSignals = []os.Signal{}
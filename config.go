@@ -1,6 +1,7 @@
 package katsubushi
 
 import (
+	"crypto/tls"
 	"net"
 	"time"
 )
@@ -9,13 +10,36 @@ type Config struct {
 	IdleTimeout time.Duration
 	LogLevel    string
 
+	// ShutdownTimeout is a grace period to drain connections on shutdown. Connections remaining after it are closed.
+	// 0 means closing connections immediately.
+	ShutdownTimeout time.Duration
+
 	Port     int
 	Sockpath string
 
+	// Listens is addresses to listen the memcached protocol. Port and Sockpath are ignored when set.
+	Listens []ListenAddr
+
+	// Listeners serve the memcached protocol, as passed by systemd socket activation. Listens is ignored when set.
+	Listeners []net.Listener
+
+	// Multiplex serves HTTP/1.1 and gRPC on the listeners of the memcached protocol too.
+	Multiplex bool
+
 	HTTPPort       int
 	HTTPPathPrefix string
 	HTTPListener   net.Listener
 
 	GRPCPort     int
 	GRPCListener net.Listener
+
+	// MaxConnections limits connections over all listeners, and the others limit connections of each protocol.
+	// 0 means unlimited.
+	MaxConnections         int
+	MaxMemcacheConnections int
+	MaxHTTPConnections     int
+	MaxGRPCConnections     int
+
+	// TLSConfig enables TLS on all listeners when not nil.
+	TLSConfig *tls.Config
 }
@@ -40,6 +40,7 @@ var newGeneratorLock sync.Mutex
 var (
 	ErrInvalidWorkerID    = errors.New("invalid worker id")
 	ErrDuplicatedWorkerID = errors.New("duplicated worker")
+	ErrClockRollbacked    = errors.New("system clock was rollbacked")
 )
 
 func checkWorkerID(id uint) error {
@@ -105,7 +106,7 @@ func (g *generator) NextID() (uint64, error) {
 
 	// for rewind of server clock
 	if ts < g.lastTimestamp {
-		return 0, errors.New("system clock was rollbacked")
+		return 0, ErrClockRollbacked
 	}
 
 	if ts == g.lastTimestamp {
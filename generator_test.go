@@ -8,8 +8,31 @@ import (
 
 var nextWorkerID uint32
 
+// getNextWorkerID returns a worker ID not used yet, skipping the ones used by fixed IDs.
 func getNextWorkerID() uint {
-	return uint(atomic.AddUint32(&nextWorkerID, 1))
+	for {
+		id := uint(atomic.AddUint32(&nextWorkerID, 1))
+		newGeneratorLock.Lock()
+		err := checkWorkerID(id)
+		newGeneratorLock.Unlock()
+		if err != ErrDuplicatedWorkerID {
+			return id
+		}
+	}
+}
+
+// stopTestClock stops the clock at the current time,
+// and returns a function to set the clock to d after the stopped time.
+func stopTestClock(t *testing.T) func(d time.Duration) {
+	base := time.Now()
+	var offset int64
+	setNowFunc(func() time.Time {
+		return base.Add(time.Duration(atomic.LoadInt64(&offset)))
+	})
+	t.Cleanup(func() { setNowFunc(time.Now) })
+	return func(d time.Duration) {
+		atomic.StoreInt64(&offset, int64(d))
+	}
 }
 
 func TestInvalidWorkerID(t *testing.T) {
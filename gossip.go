@@ -0,0 +1,337 @@
+package katsubushi
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ErrWorkerIDConflicted means that another katsubushi process announces the same worker ID.
+var ErrWorkerIDConflicted = errors.New("worker id is conflicted with another process")
+
+// ErrWorkerIDUnconfirmed means that the first round of gossip is not finished yet.
+var ErrWorkerIDUnconfirmed = errors.New("worker id is not confirmed by gossip yet")
+
+// DefaultGossipInterval is the default interval of announcements to peers.
+var DefaultGossipInterval = time.Second
+
+const gossipMaxMessageSize = 1024
+
+// gossipMaxClockSkew is the maximum difference of a timestamp of announcements from the local clock,
+// not to accept replayed ones.
+const gossipMaxClockSkew = 30 * time.Second
+
+type gossipMessage struct {
+	Node     string `json:"node"`
+	WorkerID uint   `json:"worker_id"`
+	Addr     string `json:"addr"`
+	Reply    bool   `json:"reply,omitempty"`
+	Time     int64  `json:"time"`
+	MAC      string `json:"mac,omitempty"`
+}
+
+// Gossip announces the worker ID to peers over UDP or TCP,
+// and detects other processes announcing the same worker ID.
+// Announcements are signed by HMAC-SHA256 with a key shared among peers, and unsigned ones are ignored.
+type Gossip struct {
+	// Peers is a list of addresses of other katsubushi processes.
+	// Its own address may be included.
+	Peers []string
+
+	// Interval is the interval of announcements to Peers.
+	Interval time.Duration
+
+	// ConflictTimeout is the duration to keep the conflicted state after the last conflicting announcement.
+	// It defaults to 3 times Interval when zero.
+	ConflictTimeout time.Duration
+
+	network  string
+	workerID uint
+	node     string
+	key      []byte
+
+	packetConn net.PacketConn
+	listener   net.Listener
+
+	mu         sync.Mutex
+	conflicts  map[string]time.Time // node => last seen
+	conflicted int32
+	confirmed  int32
+
+	// detected is 1 after a conflict is detected until resetDetected, for stats.
+	detected int32
+}
+
+// NewGossip creates Gossip and listens on addr of network ("udp" or "tcp").
+// key is shared among peers to sign announcements.
+func NewGossip(network, addr string, workerID uint, key []byte) (*Gossip, error) {
+	if len(key) == 0 {
+		return nil, errors.New("gossip key is required")
+	}
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	g := &Gossip{
+		Interval:  DefaultGossipInterval,
+		network:   network,
+		workerID:  workerID,
+		node:      u.String(),
+		key:       key,
+		conflicts: map[string]time.Time{},
+	}
+	switch network {
+	case "udp":
+		g.packetConn, err = net.ListenPacket("udp", addr)
+	case "tcp":
+		g.listener, err = net.Listen("tcp", addr)
+	default:
+		return nil, fmt.Errorf("invalid gossip network %q: must be udp or tcp", network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Addr returns the address listening announcements.
+func (g *Gossip) Addr() net.Addr {
+	if g.packetConn != nil {
+		return g.packetConn.LocalAddr()
+	}
+	return g.listener.Addr()
+}
+
+// Conflicted reports whether another process announces the same worker ID.
+func (g *Gossip) Conflicted() bool {
+	return atomic.LoadInt32(&g.conflicted) == 1
+}
+
+// Confirmed reports whether a full round of announcements has passed since Run without a conflict.
+// IDs must not be issued before it, because a conflict is not detected yet.
+func (g *Gossip) Confirmed() bool {
+	return atomic.LoadInt32(&g.confirmed) == 1
+}
+
+// Run receives and sends announcements until ctx is done.
+func (g *Gossip) Run(ctx context.Context) error {
+	log.Infof("Gossip worker id %d on %s/%s to %v", g.workerID, g.network, g.Addr(), g.Peers)
+	go func() {
+		<-ctx.Done()
+		g.close()
+	}()
+	go g.announceLoop(ctx)
+	var err error
+	if g.packetConn != nil {
+		err = g.serveUDP()
+	} else {
+		err = g.serveTCP()
+	}
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (g *Gossip) close() {
+	if g.packetConn != nil {
+		g.packetConn.Close()
+	} else {
+		g.listener.Close()
+	}
+}
+
+// message returns a signed announcement.
+func (g *Gossip) message(reply bool) []byte {
+	msg := gossipMessage{
+		Node:     g.node,
+		WorkerID: g.workerID,
+		Addr:     g.Addr().String(),
+		Reply:    reply,
+		Time:     now().UnixMilli(),
+	}
+	msg.MAC = g.sign(msg)
+	b, _ := json.Marshal(msg)
+	return b
+}
+
+// sign returns a MAC of msg without its MAC.
+func (g *Gossip) sign(msg gossipMessage) string {
+	msg.MAC = ""
+	b, _ := json.Marshal(msg)
+	h := hmac.New(sha256.New, g.key)
+	h.Write(b)
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// verify parses a signed announcement.
+func (g *Gossip) verify(b []byte) (gossipMessage, error) {
+	var msg gossipMessage
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return msg, err
+	}
+	if !hmac.Equal([]byte(msg.MAC), []byte(g.sign(msg))) {
+		return msg, errors.New("invalid signature")
+	}
+	if d := now().Sub(time.UnixMilli(msg.Time)); d > gossipMaxClockSkew || d < -gossipMaxClockSkew {
+		return msg, fmt.Errorf("timestamp is off by %s", d)
+	}
+	return msg, nil
+}
+
+func (g *Gossip) announceLoop(ctx context.Context) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		for _, peer := range g.Peers {
+			if err := g.announce(ctx, peer); err != nil {
+				log.Debugf("failed to announce worker id to %s: %s", peer, err)
+			}
+		}
+		g.expireConflicts()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// replies to the first round are received in the interval
+		if atomic.CompareAndSwapInt32(&g.confirmed, 0, 1) {
+			log.Infof("worker id %d is confirmed by gossip", g.workerID)
+		}
+	}
+}
+
+func (g *Gossip) announce(ctx context.Context, peer string) error {
+	b := g.message(false)
+	if g.packetConn != nil {
+		addr, err := net.ResolveUDPAddr("udp", peer)
+		if err != nil {
+			return err
+		}
+		// the reply is received by serveUDP
+		_, err = g.packetConn.WriteTo(b, addr)
+		return err
+	}
+
+	d := net.Dialer{Timeout: g.Interval}
+	conn, err := d.DialContext(ctx, "tcp", peer)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(g.Interval))
+	if _, err := conn.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	line, err := bufio.NewReaderSize(conn, gossipMaxMessageSize).ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	_, err = g.receive(line)
+	return err
+}
+
+func (g *Gossip) serveUDP() error {
+	buf := make([]byte, gossipMaxMessageSize)
+	for {
+		n, addr, err := g.packetConn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		reply, err := g.receive(buf[:n])
+		if err != nil {
+			log.Debugf("invalid gossip message from %s: %s", addr, err)
+			continue
+		}
+		if reply {
+			g.packetConn.WriteTo(g.message(true), addr)
+		}
+	}
+}
+
+func (g *Gossip) serveTCP() error {
+	for {
+		conn, err := g.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(g.Interval))
+			line, err := bufio.NewReaderSize(conn, gossipMaxMessageSize).ReadSlice('\n')
+			if err != nil {
+				return
+			}
+			reply, err := g.receive(line)
+			if err != nil {
+				log.Debugf("invalid gossip message from %s: %s", conn.RemoteAddr(), err)
+				return
+			}
+			if reply {
+				conn.Write(append(g.message(true), '\n'))
+			}
+		}()
+	}
+}
+
+// receive handles an announcement and reports whether it needs a reply.
+func (g *Gossip) receive(b []byte) (bool, error) {
+	msg, err := g.verify(b)
+	if err != nil {
+		return false, err
+	}
+	if msg.Node != g.node && msg.WorkerID == g.workerID {
+		g.conflict(msg)
+	}
+	// reply to let the peer detect the conflict even if it is not in our peers
+	return !msg.Reply, nil
+}
+
+func (g *Gossip) conflict(msg gossipMessage) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, exists := g.conflicts[msg.Node]; !exists {
+		log.Errorf("CONFLICT: worker id %d is also used by %s, refusing to issue IDs", msg.WorkerID, msg.Addr)
+	}
+	g.conflicts[msg.Node] = time.Now()
+	atomic.StoreInt32(&g.conflicted, 1)
+	atomic.StoreInt32(&g.detected, 1)
+}
+
+// conflictDetected reports whether a conflict is detected since the last resetDetected, or continues.
+func (g *Gossip) conflictDetected() bool {
+	return atomic.LoadInt32(&g.detected) == 1 || g.Conflicted()
+}
+
+func (g *Gossip) resetDetected() {
+	atomic.StoreInt32(&g.detected, 0)
+}
+
+func (g *Gossip) expireConflicts() {
+	timeout := g.ConflictTimeout
+	if timeout == 0 {
+		timeout = 3 * g.Interval
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for node, seen := range g.conflicts {
+		if time.Since(seen) > timeout {
+			delete(g.conflicts, node)
+		}
+	}
+	if len(g.conflicts) == 0 && g.Conflicted() {
+		log.Infof("conflict of worker id %d is resolved", g.workerID)
+		atomic.StoreInt32(&g.conflicted, 0)
+	}
+}
@@ -0,0 +1,125 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newTestGossip(t *testing.T, network string, workerID uint) *Gossip {
+	g, err := NewGossip(network, "127.0.0.1:0", workerID, []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Interval = 50 * time.Millisecond
+	return g
+}
+
+func waitFor(t *testing.T, cond func() bool, msg string) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testGossip(t *testing.T, network string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newTestGossip(t, network, 1)
+	b := newTestGossip(t, network, 2)
+	c := newTestGossip(t, network, 1)
+	peers := []string{a.Addr().String(), b.Addr().String()}
+	a.Peers, b.Peers = peers, peers
+	// a does not know c, but c knows a
+	c.Peers = []string{a.Addr().String()}
+
+	app := newTestApp(t, nil)
+	app.SetGossip(a)
+	if _, err := app.NextID(); !errors.Is(err, ErrWorkerIDUnconfirmed) {
+		t.Errorf("app must refuse to issue IDs before gossip: %v", err)
+	}
+
+	go a.Run(ctx)
+	go b.Run(ctx)
+	time.Sleep(200 * time.Millisecond)
+	if a.Conflicted() || b.Conflicted() {
+		t.Fatal("different worker ids must not be conflicted")
+	}
+	if !a.Confirmed() {
+		t.Fatal("worker id must be confirmed after a round")
+	}
+	if _, err := app.NextID(); err != nil {
+		t.Errorf("app must issue IDs after gossip: %s", err)
+	}
+
+	ctxC, cancelC := context.WithCancel(ctx)
+	go c.Run(ctxC)
+	waitFor(t, func() bool { return a.Conflicted() && c.Conflicted() }, "same worker ids must be conflicted")
+	if b.Conflicted() {
+		t.Error("b must not be conflicted")
+	}
+
+	if _, err := app.NextID(); !errors.Is(err, ErrWorkerIDConflicted) {
+		t.Errorf("app must refuse to issue IDs: %v", err)
+	}
+	if s := app.GetStats(); s.WorkerIDConflict != 1 {
+		t.Errorf("unexpected worker_id_conflict %d", s.WorkerIDConflict)
+	}
+
+	cancelC()
+	waitFor(t, func() bool { return !a.Conflicted() }, "conflict must be resolved after c is stopped")
+	if _, err := app.NextID(); err != nil {
+		t.Errorf("app must issue IDs after resolved: %s", err)
+	}
+}
+
+func TestGossipUDP(t *testing.T) {
+	testGossip(t, "udp")
+}
+
+func TestGossipTCP(t *testing.T) {
+	testGossip(t, "tcp")
+}
+
+func TestGossipUnsigned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := newTestGossip(t, "udp", 1)
+	go a.Run(ctx)
+
+	// spoofed by another key or without signatures
+	b, err := NewGossip("udp", "127.0.0.1:0", 1, []byte("wrong"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Interval = 50 * time.Millisecond
+	b.Peers = []string{a.Addr().String()}
+	go b.Run(ctx)
+	conn, err := net.Dial("udp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte(`{"node":"spoofed","worker_id":1,"addr":"127.0.0.1:1","time":` + strconv.FormatInt(now().UnixMilli(), 10) + `}`))
+
+	// replayed
+	c := newTestGossip(t, "udp", 1)
+	setNowFunc(func() time.Time { return time.Now().Add(-time.Minute) })
+	replayed := c.message(false)
+	setNowFunc(time.Now)
+	conn.Write(replayed)
+
+	time.Sleep(300 * time.Millisecond)
+	if a.Conflicted() {
+		t.Error("unsigned announcements must be ignored")
+	}
+}
@@ -2,9 +2,12 @@ package katsubushi
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"net"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/kayac/go-katsubushi/v2/grpc"
 	"github.com/pkg/errors"
@@ -13,6 +16,11 @@ import (
 	grpc_recovery "github.com/grpc-ecosystem/go-grpc-middleware/recovery"
 	gogrpc "google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
@@ -21,6 +29,70 @@ const (
 	MaxGRPCBulkSize = 1000
 )
 
+// grpcNamespaceKey is a metadata key of namespaces for rate limits.
+const grpcNamespaceKey = "namespace"
+
+// grpcLimitRate returns RESOURCE_EXHAUSTED when n IDs requested are over rate limits,
+// or INVALID_ARGUMENT when n is over the burst of them.
+func (app *App) grpcLimitRate(ctx context.Context, n int) error {
+	var addr net.Addr
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr
+	}
+	var namespace string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if v := md.Get(grpcNamespaceKey); len(v) > 0 {
+			namespace = v[0]
+		}
+	}
+	if err := app.limitRateOf(addr, namespace, n); err != nil {
+		log.Warn(err)
+		if isInvalidArgument(err) {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
+
+// grpcAuthorizationKey is a metadata key of SASL credentials by Basic authentication.
+const grpcAuthorizationKey = "authorization"
+
+// grpcAuthenticate returns UNAUTHENTICATED when SASL is enabled and
+// the metadata does not have valid SASL credentials by Basic authentication.
+func (app *App) grpcAuthenticate(ctx context.Context) error {
+	if app.saslAuth == nil {
+		return nil
+	}
+	var user, password string
+	var ok bool
+	if md, mok := metadata.FromIncomingContext(ctx); mok {
+		if v := md.Get(grpcAuthorizationKey); len(v) > 0 {
+			user, password, ok = parseBasicAuth(v[0])
+		}
+	}
+	if !ok {
+		return status.Error(codes.Unauthenticated, ErrAuthRequired.Error())
+	}
+	if !app.saslAuth.verify(user, password) {
+		log.Warnf("authentication failure of user %s", user)
+		return status.Error(codes.Unauthenticated, ErrAuthFailure.Error())
+	}
+	return nil
+}
+
+func parseBasicAuth(s string) (string, string, bool) {
+	const prefix = "Basic "
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	b, err := base64.StdEncoding.DecodeString(s[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(b), ":")
+}
+
 type gRPCGenerator struct {
 	grpc.GeneratorServer
 	app *App
@@ -28,6 +100,9 @@ type gRPCGenerator struct {
 
 func (sv *gRPCGenerator) Fetch(ctx context.Context, req *grpc.FetchRequest) (*grpc.FetchResponse, error) {
 	atomic.AddInt64(&sv.app.cmdGet, 1)
+	if err := sv.app.grpcLimitRate(ctx, 1); err != nil {
+		return nil, err
+	}
 
 	id, err := sv.app.NextID()
 	if err != nil {
@@ -48,6 +123,9 @@ func (sv *gRPCGenerator) FetchMulti(ctx context.Context, req *grpc.FetchMultiReq
 	if n == 0 {
 		n = 1
 	}
+	if err := sv.app.grpcLimitRate(ctx, n); err != nil {
+		return nil, err
+	}
 	ids := make([]uint64, 0, n)
 	for i := 0; i < n; i++ {
 		id, err := sv.app.NextID()
@@ -62,19 +140,162 @@ func (sv *gRPCGenerator) FetchMulti(ctx context.Context, req *grpc.FetchMultiReq
 	return res, nil
 }
 
-func (app *App) RunGRPCServer(ctx context.Context, cfg *Config) error {
-	svGen := &gRPCGenerator{app: app}
-	svStats := &gRPCStats{app: app}
+func (sv *gRPCGenerator) FetchRange(ctx context.Context, req *grpc.FetchRangeRequest) (*grpc.FetchRangeResponse, error) {
+	atomic.AddInt64(&sv.app.cmdGet, 1)
+	n := int(req.N)
+	if err := validateRangeSize(n); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := sv.app.grpcLimitRate(ctx, n); err != nil {
+		return nil, err
+	}
+	ranges, err := sv.app.NextRanges(n)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get id ranges")
+	}
+	res := &grpc.FetchRangeResponse{
+		Ranges: make([]*grpc.IDRange, 0, len(ranges)),
+	}
+	for _, r := range ranges {
+		res.Ranges = append(res.Ranges, &grpc.IDRange{Start: r.Start, Count: uint32(r.Count)})
+	}
+	return res, nil
+}
 
-	opts := []grpc_recovery.Option{
-		grpc_recovery.WithRecoveryHandler(grpcRecoveryFunc),
+type gRPCWorkerLeaser struct {
+	grpc.WorkerLeaserServer
+	app *App
+}
+
+func (sv *gRPCWorkerLeaser) Lease(ctx context.Context, req *grpc.WorkerLeaseRequest) (*grpc.WorkerLeaseResponse, error) {
+	if err := sv.app.grpcAuthenticate(ctx); err != nil {
+		return nil, err
 	}
-	s := gogrpc.NewServer(grpc_middleware.WithUnaryServerChain(
-		grpc_recovery.UnaryServerInterceptor(opts...),
-	))
-	grpc.RegisterGeneratorServer(s, svGen)
-	grpc.RegisterStatsServer(s, svStats)
-	reflection.Register(s)
+	if err := sv.app.grpcLimitRate(ctx, 1); err != nil {
+		return nil, err
+	}
+	var addr net.Addr
+	if p, ok := peer.FromContext(ctx); ok {
+		addr = p.Addr
+	}
+	l, err := sv.app.leaseWorker(addr)
+	if err != nil {
+		return nil, workerLeaseStatus(err)
+	}
+	return workerLeaseResponse(l), nil
+}
+
+func (sv *gRPCWorkerLeaser) Renew(ctx context.Context, req *grpc.WorkerRenewRequest) (*grpc.WorkerLeaseResponse, error) {
+	if err := sv.app.grpcAuthenticate(ctx); err != nil {
+		return nil, err
+	}
+	if err := sv.app.grpcLimitRate(ctx, 1); err != nil {
+		return nil, err
+	}
+	l, err := sv.app.renewWorker(uint(req.WorkerId), req.Token)
+	if err != nil {
+		return nil, workerLeaseStatus(err)
+	}
+	return workerLeaseResponse(l), nil
+}
+
+func (sv *gRPCWorkerLeaser) Release(ctx context.Context, req *grpc.WorkerReleaseRequest) (*grpc.WorkerReleaseResponse, error) {
+	if err := sv.app.grpcAuthenticate(ctx); err != nil {
+		return nil, err
+	}
+	if err := sv.app.grpcLimitRate(ctx, 1); err != nil {
+		return nil, err
+	}
+	if err := sv.app.releaseWorker(uint(req.WorkerId), req.Token); err != nil {
+		return nil, workerLeaseStatus(err)
+	}
+	return &grpc.WorkerReleaseResponse{}, nil
+}
+
+func workerLeaseResponse(l *WorkerLease) *grpc.WorkerLeaseResponse {
+	return &grpc.WorkerLeaseResponse{
+		WorkerId: uint32(l.WorkerID),
+		Token:    l.Token,
+		TtlMs:    l.TTL.Milliseconds(),
+	}
+}
+
+func workerLeaseStatus(err error) error {
+	switch err {
+	case ErrWorkerLeaseNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrWorkerLeaseDisabled:
+		return status.Error(codes.Unimplemented, err.Error())
+	}
+	return status.Error(codes.ResourceExhausted, err.Error())
+}
+
+// GRPCWorkerLeaseClient is a WorkerLeaseClient via gRPC.
+type GRPCWorkerLeaseClient struct {
+	client        grpc.WorkerLeaserClient
+	authorization string
+}
+
+// NewGRPCWorkerLeaseClient creates GRPCWorkerLeaseClient.
+func NewGRPCWorkerLeaseClient(cc gogrpc.ClientConnInterface) *GRPCWorkerLeaseClient {
+	return &GRPCWorkerLeaseClient{client: grpc.NewWorkerLeaserClient(cc)}
+}
+
+// SetBasicAuth sets SASL credentials to lease worker IDs from katsubushi servers with SASL enabled.
+func (c *GRPCWorkerLeaseClient) SetBasicAuth(user, password string) {
+	c.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+func (c *GRPCWorkerLeaseClient) outgoingContext(ctx context.Context) context.Context {
+	if c.authorization == "" {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, grpcAuthorizationKey, c.authorization)
+}
+
+// LeaseWorker leases a worker ID via gRPC to generate IDs locally. See LeasedGenerator.
+func (c *GRPCWorkerLeaseClient) LeaseWorker(ctx context.Context) (*WorkerLease, error) {
+	res, err := c.client.Lease(c.outgoingContext(ctx), &grpc.WorkerLeaseRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return workerLeaseFromResponse(res), nil
+}
+
+// RenewWorker renews the lease of the worker ID via gRPC.
+func (c *GRPCWorkerLeaseClient) RenewWorker(ctx context.Context, l *WorkerLease) (*WorkerLease, error) {
+	res, err := c.client.Renew(c.outgoingContext(ctx), &grpc.WorkerRenewRequest{WorkerId: uint32(l.WorkerID), Token: l.Token})
+	if status.Code(err) == codes.NotFound {
+		return nil, ErrWorkerLeaseNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return workerLeaseFromResponse(res), nil
+}
+
+// ReleaseWorker releases the lease of the worker ID via gRPC.
+func (c *GRPCWorkerLeaseClient) ReleaseWorker(ctx context.Context, l *WorkerLease) error {
+	_, err := c.client.Release(c.outgoingContext(ctx), &grpc.WorkerReleaseRequest{WorkerId: uint32(l.WorkerID), Token: l.Token})
+	if status.Code(err) == codes.NotFound {
+		return ErrWorkerLeaseNotFound
+	}
+	return err
+}
+
+func workerLeaseFromResponse(res *grpc.WorkerLeaseResponse) *WorkerLease {
+	return &WorkerLease{
+		WorkerID: uint(res.WorkerId),
+		Token:    res.Token,
+		TTL:      time.Duration(res.TtlMs) * time.Millisecond,
+	}
+}
+
+func (app *App) RunGRPCServer(ctx context.Context, cfg *Config) error {
+	creds := insecure.NewCredentials()
+	if cfg.TLSConfig != nil {
+		creds = credentials.NewTLS(cfg.TLSConfig)
+	}
+	s := app.newGRPCServer(creds)
 
 	listener := cfg.GRPCListener
 	if listener == nil {
@@ -84,15 +305,80 @@ func (app *App) RunGRPCServer(ctx context.Context, cfg *Config) error {
 			return errors.Wrap(err, "failed to listen")
 		}
 	}
+	app.addHandoffListener("grpc", listener)
 	listener = app.wrapListener(listener)
+	app.setMaxConnections(cfg.MaxConnections)
+	limitConnections(listener, app.connLimitOf(cfg, muxGRPC))
+	app.setListenAddr("grpc_addr", listener.Addr())
+	done := make(chan struct{})
 	go func() {
 		<-ctx.Done()
 		log.Infof("Shutting down gRPC server")
-		s.Stop()
+		app.stopGRPCServer(s, cfg.ShutdownTimeout)
+		close(done)
 	}()
 
 	log.Infof("Listening gRPC server at %s", listener.Addr())
-	return s.Serve(listener)
+	if err := s.Serve(listener); err != nil {
+		return err
+	}
+	// wait for draining
+	<-done
+	return nil
+}
+
+func (app *App) newGRPCServer(creds credentials.TransportCredentials) *gogrpc.Server {
+	svGen := &gRPCGenerator{app: app}
+	svStats := &gRPCStats{app: app}
+	svWorkerLeaser := &gRPCWorkerLeaser{app: app}
+
+	opts := []grpc_recovery.Option{
+		grpc_recovery.WithRecoveryHandler(grpcRecoveryFunc),
+	}
+	s := gogrpc.NewServer(
+		grpc_middleware.WithUnaryServerChain(
+			grpc_recovery.UnaryServerInterceptor(opts...),
+			rejectConnInterceptor,
+		),
+		gogrpc.Creds(limitedCredentials{creds}),
+	)
+	grpc.RegisterGeneratorServer(s, svGen)
+	grpc.RegisterStatsServer(s, svStats)
+	grpc.RegisterWorkerLeaserServer(s, svWorkerLeaser)
+	healthpb.RegisterHealthServer(s, app.health)
+	reflection.Register(s)
+	return s
+}
+
+// limitedCredentials marks connections over the limit of connections by rejectedAuthInfo.
+type limitedCredentials struct {
+	credentials.TransportCredentials
+}
+
+func (c limitedCredentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ServerHandshake(rawConn)
+	if err == nil && isRejectedConn(rawConn) {
+		info = rejectedAuthInfo{info}
+	}
+	return conn, info, err
+}
+
+func (c limitedCredentials) Clone() credentials.TransportCredentials {
+	return limitedCredentials{c.TransportCredentials.Clone()}
+}
+
+type rejectedAuthInfo struct {
+	credentials.AuthInfo
+}
+
+// rejectConnInterceptor responds RESOURCE_EXHAUSTED to requests on connections over the limit of connections.
+func rejectConnInterceptor(ctx context.Context, req interface{}, info *gogrpc.UnaryServerInfo, handler gogrpc.UnaryHandler) (interface{}, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		if _, rejected := p.AuthInfo.(rejectedAuthInfo); rejected {
+			return nil, status.Error(codes.ResourceExhausted, ErrTooManyConnections.Error())
+		}
+	}
+	return handler(ctx, req)
 }
 
 func grpcRecoveryFunc(p interface{}) error {
@@ -108,14 +394,17 @@ type gRPCStats struct {
 func (sv *gRPCStats) Get(ctx context.Context, req *grpc.StatsRequest) (*grpc.StatsResponse, error) {
 	st := sv.app.GetStats()
 	return &grpc.StatsResponse{
-		Pid:              int32(st.Pid),
-		Uptime:           st.Uptime,
-		Time:             st.Time,
-		Version:          st.Version,
-		CurrConnections:  st.CurrConnections,
-		TotalConnections: st.TotalConnections,
-		CmdGet:           st.CmdGet,
-		GetHits:          st.GetHits,
-		GetMisses:        st.GetMisses,
+		Pid:                 int32(st.Pid),
+		Uptime:              st.Uptime,
+		Time:                st.Time,
+		Version:             st.Version,
+		CurrConnections:     st.CurrConnections,
+		TotalConnections:    st.TotalConnections,
+		CmdGet:              st.CmdGet,
+		GetHits:             st.GetHits,
+		GetMisses:           st.GetMisses,
+		WorkerIdFallback:    st.WorkerIDFallback,
+		WorkerIdConflict:    st.WorkerIDConflict,
+		RejectedConnections: st.RejectedConnections,
 	}, nil
 }
@@ -199,6 +199,404 @@ func (x *FetchMultiResponse) GetIds() []uint64 {
 	return nil
 }
 
+type FetchRangeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	N uint32 `protobuf:"varint,1,opt,name=n,proto3" json:"n,omitempty"`
+}
+
+func (x *FetchRangeRequest) Reset() {
+	*x = FetchRangeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FetchRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchRangeRequest) ProtoMessage() {}
+
+func (x *FetchRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchRangeRequest.ProtoReflect.Descriptor instead.
+func (*FetchRangeRequest) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *FetchRangeRequest) GetN() uint32 {
+	if x != nil {
+		return x.N
+	}
+	return 0
+}
+
+type IDRange struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start uint64 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	Count uint32 `protobuf:"varint,2,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (x *IDRange) Reset() {
+	*x = IDRange{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *IDRange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IDRange) ProtoMessage() {}
+
+func (x *IDRange) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IDRange.ProtoReflect.Descriptor instead.
+func (*IDRange) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *IDRange) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *IDRange) GetCount() uint32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+type FetchRangeResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Ranges []*IDRange `protobuf:"bytes,1,rep,name=ranges,proto3" json:"ranges,omitempty"`
+}
+
+func (x *FetchRangeResponse) Reset() {
+	*x = FetchRangeResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *FetchRangeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*FetchRangeResponse) ProtoMessage() {}
+
+func (x *FetchRangeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use FetchRangeResponse.ProtoReflect.Descriptor instead.
+func (*FetchRangeResponse) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *FetchRangeResponse) GetRanges() []*IDRange {
+	if x != nil {
+		return x.Ranges
+	}
+	return nil
+}
+
+type WorkerLeaseRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *WorkerLeaseRequest) Reset() {
+	*x = WorkerLeaseRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WorkerLeaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerLeaseRequest) ProtoMessage() {}
+
+func (x *WorkerLeaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerLeaseRequest.ProtoReflect.Descriptor instead.
+func (*WorkerLeaseRequest) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{7}
+}
+
+type WorkerRenewRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	WorkerId uint32 `protobuf:"varint,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Token    string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (x *WorkerRenewRequest) Reset() {
+	*x = WorkerRenewRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WorkerRenewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerRenewRequest) ProtoMessage() {}
+
+func (x *WorkerRenewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerRenewRequest.ProtoReflect.Descriptor instead.
+func (*WorkerRenewRequest) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *WorkerRenewRequest) GetWorkerId() uint32 {
+	if x != nil {
+		return x.WorkerId
+	}
+	return 0
+}
+
+func (x *WorkerRenewRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type WorkerReleaseRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	WorkerId uint32 `protobuf:"varint,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Token    string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+}
+
+func (x *WorkerReleaseRequest) Reset() {
+	*x = WorkerReleaseRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WorkerReleaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerReleaseRequest) ProtoMessage() {}
+
+func (x *WorkerReleaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerReleaseRequest.ProtoReflect.Descriptor instead.
+func (*WorkerReleaseRequest) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *WorkerReleaseRequest) GetWorkerId() uint32 {
+	if x != nil {
+		return x.WorkerId
+	}
+	return 0
+}
+
+func (x *WorkerReleaseRequest) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+type WorkerLeaseResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	WorkerId uint32 `protobuf:"varint,1,opt,name=worker_id,json=workerId,proto3" json:"worker_id,omitempty"`
+	Token    string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	TtlMs    int64  `protobuf:"varint,3,opt,name=ttl_ms,json=ttlMs,proto3" json:"ttl_ms,omitempty"`
+}
+
+func (x *WorkerLeaseResponse) Reset() {
+	*x = WorkerLeaseResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WorkerLeaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerLeaseResponse) ProtoMessage() {}
+
+func (x *WorkerLeaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerLeaseResponse.ProtoReflect.Descriptor instead.
+func (*WorkerLeaseResponse) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *WorkerLeaseResponse) GetWorkerId() uint32 {
+	if x != nil {
+		return x.WorkerId
+	}
+	return 0
+}
+
+func (x *WorkerLeaseResponse) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *WorkerLeaseResponse) GetTtlMs() int64 {
+	if x != nil {
+		return x.TtlMs
+	}
+	return 0
+}
+
+type WorkerReleaseResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *WorkerReleaseResponse) Reset() {
+	*x = WorkerReleaseResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_main_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WorkerReleaseResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WorkerReleaseResponse) ProtoMessage() {}
+
+func (x *WorkerReleaseResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_main_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WorkerReleaseResponse.ProtoReflect.Descriptor instead.
+func (*WorkerReleaseResponse) Descriptor() ([]byte, []int) {
+	return file_main_proto_rawDescGZIP(), []int{11}
+}
+
 type StatsRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -208,7 +606,7 @@ type StatsRequest struct {
 func (x *StatsRequest) Reset() {
 	*x = StatsRequest{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_main_proto_msgTypes[4]
+		mi := &file_main_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -221,7 +619,7 @@ func (x *StatsRequest) String() string {
 func (*StatsRequest) ProtoMessage() {}
 
 func (x *StatsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_main_proto_msgTypes[4]
+	mi := &file_main_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -234,7 +632,7 @@ func (x *StatsRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatsRequest.ProtoReflect.Descriptor instead.
 func (*StatsRequest) Descriptor() ([]byte, []int) {
-	return file_main_proto_rawDescGZIP(), []int{4}
+	return file_main_proto_rawDescGZIP(), []int{12}
 }
 
 type StatsResponse struct {
@@ -242,21 +640,24 @@ type StatsResponse struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Pid              int32  `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
-	Uptime           int64  `protobuf:"varint,2,opt,name=uptime,proto3" json:"uptime,omitempty"`
-	Time             int64  `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
-	Version          string `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
-	CurrConnections  int64  `protobuf:"varint,5,opt,name=curr_connections,json=currConnections,proto3" json:"curr_connections,omitempty"`
-	TotalConnections int64  `protobuf:"varint,6,opt,name=total_connections,json=totalConnections,proto3" json:"total_connections,omitempty"`
-	CmdGet           int64  `protobuf:"varint,7,opt,name=cmd_get,json=cmdGet,proto3" json:"cmd_get,omitempty"`
-	GetHits          int64  `protobuf:"varint,8,opt,name=get_hits,json=getHits,proto3" json:"get_hits,omitempty"`
-	GetMisses        int64  `protobuf:"varint,9,opt,name=get_misses,json=getMisses,proto3" json:"get_misses,omitempty"`
+	Pid                 int32  `protobuf:"varint,1,opt,name=pid,proto3" json:"pid,omitempty"`
+	Uptime              int64  `protobuf:"varint,2,opt,name=uptime,proto3" json:"uptime,omitempty"`
+	Time                int64  `protobuf:"varint,3,opt,name=time,proto3" json:"time,omitempty"`
+	Version             string `protobuf:"bytes,4,opt,name=version,proto3" json:"version,omitempty"`
+	CurrConnections     int64  `protobuf:"varint,5,opt,name=curr_connections,json=currConnections,proto3" json:"curr_connections,omitempty"`
+	TotalConnections    int64  `protobuf:"varint,6,opt,name=total_connections,json=totalConnections,proto3" json:"total_connections,omitempty"`
+	CmdGet              int64  `protobuf:"varint,7,opt,name=cmd_get,json=cmdGet,proto3" json:"cmd_get,omitempty"`
+	GetHits             int64  `protobuf:"varint,8,opt,name=get_hits,json=getHits,proto3" json:"get_hits,omitempty"`
+	GetMisses           int64  `protobuf:"varint,9,opt,name=get_misses,json=getMisses,proto3" json:"get_misses,omitempty"`
+	WorkerIdFallback    int64  `protobuf:"varint,10,opt,name=worker_id_fallback,json=workerIdFallback,proto3" json:"worker_id_fallback,omitempty"`
+	WorkerIdConflict    int64  `protobuf:"varint,11,opt,name=worker_id_conflict,json=workerIdConflict,proto3" json:"worker_id_conflict,omitempty"`
+	RejectedConnections int64  `protobuf:"varint,12,opt,name=rejected_connections,json=rejectedConnections,proto3" json:"rejected_connections,omitempty"`
 }
 
 func (x *StatsResponse) Reset() {
 	*x = StatsResponse{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_main_proto_msgTypes[5]
+		mi := &file_main_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -269,7 +670,7 @@ func (x *StatsResponse) String() string {
 func (*StatsResponse) ProtoMessage() {}
 
 func (x *StatsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_main_proto_msgTypes[5]
+	mi := &file_main_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -282,7 +683,7 @@ func (x *StatsResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use StatsResponse.ProtoReflect.Descriptor instead.
 func (*StatsResponse) Descriptor() ([]byte, []int) {
-	return file_main_proto_rawDescGZIP(), []int{5}
+	return file_main_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *StatsResponse) GetPid() int32 {
@@ -348,6 +749,27 @@ func (x *StatsResponse) GetGetMisses() int64 {
 	return 0
 }
 
+func (x *StatsResponse) GetWorkerIdFallback() int64 {
+	if x != nil {
+		return x.WorkerIdFallback
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetWorkerIdConflict() int64 {
+	if x != nil {
+		return x.WorkerIdConflict
+	}
+	return 0
+}
+
+func (x *StatsResponse) GetRejectedConnections() int64 {
+	if x != nil {
+		return x.RejectedConnections
+	}
+	return 0
+}
+
 var File_main_proto protoreflect.FileDescriptor
 
 var file_main_proto_rawDesc = []byte{
@@ -360,25 +782,62 @@ var file_main_proto_rawDesc = []byte{
 	0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04, 0x52, 0x02, 0x69, 0x64, 0x22, 0x26, 0x0a, 0x12,
 	0x46, 0x65, 0x74, 0x63, 0x68, 0x4d, 0x75, 0x6c, 0x74, 0x69, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
 	0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x04, 0x52,
-	0x03, 0x69, 0x64, 0x73, 0x22, 0x0e, 0x0a, 0x0c, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71,
-	0x75, 0x65, 0x73, 0x74, 0x22, 0x92, 0x02, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65,
-	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x69, 0x64, 0x18, 0x01, 0x20,
-	0x01, 0x28, 0x05, 0x52, 0x03, 0x70, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x75, 0x70, 0x74, 0x69,
-	0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x75, 0x70, 0x74, 0x69, 0x6d, 0x65,
-	0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04,
-	0x74, 0x69, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
-	0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x29,
-	0x0a, 0x10, 0x63, 0x75, 0x72, 0x72, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f,
-	0x6e, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x63, 0x75, 0x72, 0x72, 0x43, 0x6f,
-	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x2b, 0x0a, 0x11, 0x74, 0x6f, 0x74,
-	0x61, 0x6c, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x06,
-	0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x43, 0x6f, 0x6e, 0x6e, 0x65,
-	0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x63, 0x6d, 0x64, 0x5f, 0x67, 0x65,
-	0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x63, 0x6d, 0x64, 0x47, 0x65, 0x74, 0x12,
-	0x19, 0x0a, 0x08, 0x67, 0x65, 0x74, 0x5f, 0x68, 0x69, 0x74, 0x73, 0x18, 0x08, 0x20, 0x01, 0x28,
-	0x03, 0x52, 0x07, 0x67, 0x65, 0x74, 0x48, 0x69, 0x74, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x67, 0x65,
-	0x74, 0x5f, 0x6d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09,
-	0x67, 0x65, 0x74, 0x4d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x32, 0x9a, 0x01, 0x0a, 0x09, 0x47, 0x65,
+	0x03, 0x69, 0x64, 0x73, 0x22, 0x21, 0x0a, 0x11, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x61, 0x6e,
+	0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x0c, 0x0a, 0x01, 0x6e, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x01, 0x6e, 0x22, 0x35, 0x0a, 0x07, 0x49, 0x44, 0x52, 0x61, 0x6e,
+	0x67, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x14, 0x0a, 0x05, 0x63, 0x6f, 0x75, 0x6e,
+	0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x05, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x22, 0x41,
+	0x0a, 0x12, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70,
+	0x6f, 0x6e, 0x73, 0x65, 0x12, 0x2b, 0x0a, 0x06, 0x72, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x13, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68,
+	0x69, 0x2e, 0x49, 0x44, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x06, 0x72, 0x61, 0x6e, 0x67, 0x65,
+	0x73, 0x22, 0x14, 0x0a, 0x12, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4c, 0x65, 0x61, 0x73, 0x65,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x47, 0x0a, 0x12, 0x57, 0x6f, 0x72, 0x6b, 0x65,
+	0x72, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a,
+	0x09, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x08, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f,
+	0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e,
+	0x22, 0x49, 0x0a, 0x14, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1b, 0x0a, 0x09, 0x77, 0x6f, 0x72, 0x6b,
+	0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x77, 0x6f, 0x72,
+	0x6b, 0x65, 0x72, 0x49, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x22, 0x5f, 0x0a, 0x13, 0x57,
+	0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e,
+	0x73, 0x65, 0x12, 0x1b, 0x0a, 0x09, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x08, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x49, 0x64, 0x12,
+	0x14, 0x0a, 0x05, 0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05,
+	0x74, 0x6f, 0x6b, 0x65, 0x6e, 0x12, 0x15, 0x0a, 0x06, 0x74, 0x74, 0x6c, 0x5f, 0x6d, 0x73, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x05, 0x74, 0x74, 0x6c, 0x4d, 0x73, 0x22, 0x17, 0x0a, 0x15,
+	0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x0e, 0x0a, 0x0c, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0xa1, 0x03, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x70, 0x69, 0x64, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x03, 0x70, 0x69, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x75, 0x70, 0x74,
+	0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x75, 0x70, 0x74, 0x69, 0x6d,
+	0x65, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x29, 0x0a, 0x10, 0x63, 0x75, 0x72, 0x72, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69,
+	0x6f, 0x6e, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0f, 0x63, 0x75, 0x72, 0x72, 0x43,
+	0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x2b, 0x0a, 0x11, 0x74, 0x6f,
+	0x74, 0x61, 0x6c, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18,
+	0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x74, 0x6f, 0x74, 0x61, 0x6c, 0x43, 0x6f, 0x6e, 0x6e,
+	0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x12, 0x17, 0x0a, 0x07, 0x63, 0x6d, 0x64, 0x5f, 0x67,
+	0x65, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x63, 0x6d, 0x64, 0x47, 0x65, 0x74,
+	0x12, 0x19, 0x0a, 0x08, 0x67, 0x65, 0x74, 0x5f, 0x68, 0x69, 0x74, 0x73, 0x18, 0x08, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x07, 0x67, 0x65, 0x74, 0x48, 0x69, 0x74, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x67,
+	0x65, 0x74, 0x5f, 0x6d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x18, 0x09, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x67, 0x65, 0x74, 0x4d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x12, 0x2c, 0x0a, 0x12, 0x77, 0x6f,
+	0x72, 0x6b, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x5f, 0x66, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b,
+	0x18, 0x0a, 0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x49, 0x64,
+	0x46, 0x61, 0x6c, 0x6c, 0x62, 0x61, 0x63, 0x6b, 0x12, 0x2c, 0x0a, 0x12, 0x77, 0x6f, 0x72, 0x6b,
+	0x65, 0x72, 0x5f, 0x69, 0x64, 0x5f, 0x63, 0x6f, 0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x18, 0x0b,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x10, 0x77, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x49, 0x64, 0x43, 0x6f,
+	0x6e, 0x66, 0x6c, 0x69, 0x63, 0x74, 0x12, 0x31, 0x0a, 0x14, 0x72, 0x65, 0x6a, 0x65, 0x63, 0x74,
+	0x65, 0x64, 0x5f, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x0c,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x13, 0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x43, 0x6f,
+	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x32, 0xe9, 0x01, 0x0a, 0x09, 0x47, 0x65,
 	0x6e, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72, 0x12, 0x3e, 0x0a, 0x05, 0x46, 0x65, 0x74, 0x63, 0x68,
 	0x12, 0x18, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x46, 0x65,
 	0x74, 0x63, 0x68, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x6b, 0x61, 0x74,
@@ -388,13 +847,34 @@ var file_main_proto_rawDesc = []byte{
 	0x68, 0x69, 0x2e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x4d, 0x75, 0x6c, 0x74, 0x69, 0x52, 0x65, 0x71,
 	0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68,
 	0x69, 0x2e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x4d, 0x75, 0x6c, 0x74, 0x69, 0x52, 0x65, 0x73, 0x70,
-	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x32, 0x45, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12,
-	0x3c, 0x0a, 0x03, 0x47, 0x65, 0x74, 0x12, 0x18, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75,
-	0x73, 0x68, 0x69, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
-	0x1a, 0x19, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x53, 0x74,
-	0x61, 0x74, 0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x11, 0x5a,
-	0x0f, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2f, 0x67, 0x72, 0x70, 0x63,
-	0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x4d, 0x0a, 0x0a, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52,
+	0x61, 0x6e, 0x67, 0x65, 0x12, 0x1d, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68,
+	0x69, 0x2e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69,
+	0x2e, 0x46, 0x65, 0x74, 0x63, 0x68, 0x52, 0x61, 0x6e, 0x67, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x00, 0x32, 0xf8, 0x01, 0x0a, 0x0c, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72,
+	0x4c, 0x65, 0x61, 0x73, 0x65, 0x72, 0x12, 0x4a, 0x0a, 0x05, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x12,
+	0x1e, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72,
+	0x6b, 0x65, 0x72, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a,
+	0x1f, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72,
+	0x6b, 0x65, 0x72, 0x4c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x22, 0x00, 0x12, 0x4a, 0x0a, 0x05, 0x52, 0x65, 0x6e, 0x65, 0x77, 0x12, 0x1e, 0x2e, 0x6b, 0x61,
+	0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x52,
+	0x65, 0x6e, 0x65, 0x77, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1f, 0x2e, 0x6b, 0x61,
+	0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x4c,
+	0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x50,
+	0x0a, 0x07, 0x52, 0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x12, 0x20, 0x2e, 0x6b, 0x61, 0x74, 0x73,
+	0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x52, 0x65, 0x6c,
+	0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x6b, 0x61,
+	0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x57, 0x6f, 0x72, 0x6b, 0x65, 0x72, 0x52,
+	0x65, 0x6c, 0x65, 0x61, 0x73, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00,
+	0x32, 0x45, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x3c, 0x0a, 0x03, 0x47, 0x65, 0x74,
+	0x12, 0x18, 0x2e, 0x6b, 0x61, 0x74, 0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x53, 0x74,
+	0x61, 0x74, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x19, 0x2e, 0x6b, 0x61, 0x74,
+	0x73, 0x75, 0x62, 0x75, 0x73, 0x68, 0x69, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x52, 0x65, 0x73,
+	0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x11, 0x5a, 0x0f, 0x6b, 0x61, 0x74, 0x73, 0x75,
+	0x62, 0x75, 0x73, 0x68, 0x69, 0x2f, 0x67, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x33,
 }
 
 var (
@@ -409,27 +889,44 @@ func file_main_proto_rawDescGZIP() []byte {
 	return file_main_proto_rawDescData
 }
 
-var file_main_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_main_proto_msgTypes = make([]protoimpl.MessageInfo, 14)
 var file_main_proto_goTypes = []interface{}{
-	(*FetchRequest)(nil),       // 0: katsubushi.FetchRequest
-	(*FetchMultiRequest)(nil),  // 1: katsubushi.FetchMultiRequest
-	(*FetchResponse)(nil),      // 2: katsubushi.FetchResponse
-	(*FetchMultiResponse)(nil), // 3: katsubushi.FetchMultiResponse
-	(*StatsRequest)(nil),       // 4: katsubushi.StatsRequest
-	(*StatsResponse)(nil),      // 5: katsubushi.StatsResponse
+	(*FetchRequest)(nil),          // 0: katsubushi.FetchRequest
+	(*FetchMultiRequest)(nil),     // 1: katsubushi.FetchMultiRequest
+	(*FetchResponse)(nil),         // 2: katsubushi.FetchResponse
+	(*FetchMultiResponse)(nil),    // 3: katsubushi.FetchMultiResponse
+	(*FetchRangeRequest)(nil),     // 4: katsubushi.FetchRangeRequest
+	(*IDRange)(nil),               // 5: katsubushi.IDRange
+	(*FetchRangeResponse)(nil),    // 6: katsubushi.FetchRangeResponse
+	(*WorkerLeaseRequest)(nil),    // 7: katsubushi.WorkerLeaseRequest
+	(*WorkerRenewRequest)(nil),    // 8: katsubushi.WorkerRenewRequest
+	(*WorkerReleaseRequest)(nil),  // 9: katsubushi.WorkerReleaseRequest
+	(*WorkerLeaseResponse)(nil),   // 10: katsubushi.WorkerLeaseResponse
+	(*WorkerReleaseResponse)(nil), // 11: katsubushi.WorkerReleaseResponse
+	(*StatsRequest)(nil),          // 12: katsubushi.StatsRequest
+	(*StatsResponse)(nil),         // 13: katsubushi.StatsResponse
 }
 var file_main_proto_depIdxs = []int32{
-	0, // 0: katsubushi.Generator.Fetch:input_type -> katsubushi.FetchRequest
-	1, // 1: katsubushi.Generator.FetchMulti:input_type -> katsubushi.FetchMultiRequest
-	4, // 2: katsubushi.Stats.Get:input_type -> katsubushi.StatsRequest
-	2, // 3: katsubushi.Generator.Fetch:output_type -> katsubushi.FetchResponse
-	3, // 4: katsubushi.Generator.FetchMulti:output_type -> katsubushi.FetchMultiResponse
-	5, // 5: katsubushi.Stats.Get:output_type -> katsubushi.StatsResponse
-	3, // [3:6] is the sub-list for method output_type
-	0, // [0:3] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	5,  // 0: katsubushi.FetchRangeResponse.ranges:type_name -> katsubushi.IDRange
+	0,  // 1: katsubushi.Generator.Fetch:input_type -> katsubushi.FetchRequest
+	1,  // 2: katsubushi.Generator.FetchMulti:input_type -> katsubushi.FetchMultiRequest
+	4,  // 3: katsubushi.Generator.FetchRange:input_type -> katsubushi.FetchRangeRequest
+	7,  // 4: katsubushi.WorkerLeaser.Lease:input_type -> katsubushi.WorkerLeaseRequest
+	8,  // 5: katsubushi.WorkerLeaser.Renew:input_type -> katsubushi.WorkerRenewRequest
+	9,  // 6: katsubushi.WorkerLeaser.Release:input_type -> katsubushi.WorkerReleaseRequest
+	12, // 7: katsubushi.Stats.Get:input_type -> katsubushi.StatsRequest
+	2,  // 8: katsubushi.Generator.Fetch:output_type -> katsubushi.FetchResponse
+	3,  // 9: katsubushi.Generator.FetchMulti:output_type -> katsubushi.FetchMultiResponse
+	6,  // 10: katsubushi.Generator.FetchRange:output_type -> katsubushi.FetchRangeResponse
+	10, // 11: katsubushi.WorkerLeaser.Lease:output_type -> katsubushi.WorkerLeaseResponse
+	10, // 12: katsubushi.WorkerLeaser.Renew:output_type -> katsubushi.WorkerLeaseResponse
+	11, // 13: katsubushi.WorkerLeaser.Release:output_type -> katsubushi.WorkerReleaseResponse
+	13, // 14: katsubushi.Stats.Get:output_type -> katsubushi.StatsResponse
+	8,  // [8:15] is the sub-list for method output_type
+	1,  // [1:8] is the sub-list for method input_type
+	1,  // [1:1] is the sub-list for extension type_name
+	1,  // [1:1] is the sub-list for extension extendee
+	0,  // [0:1] is the sub-list for field type_name
 }
 
 func init() { file_main_proto_init() }
@@ -487,7 +984,7 @@ func file_main_proto_init() {
 			}
 		}
 		file_main_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*StatsRequest); i {
+			switch v := v.(*FetchRangeRequest); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -499,6 +996,102 @@ func file_main_proto_init() {
 			}
 		}
 		file_main_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*IDRange); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*FetchRangeResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WorkerLeaseRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WorkerRenewRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WorkerReleaseRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WorkerLeaseResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WorkerReleaseResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatsRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_main_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*StatsResponse); i {
 			case 0:
 				return &v.state
@@ -517,9 +1110,9 @@ func file_main_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_main_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   14,
 			NumExtensions: 0,
-			NumServices:   2,
+			NumServices:   3,
 		},
 		GoTypes:           file_main_proto_goTypes,
 		DependencyIndexes: file_main_proto_depIdxs,
@@ -24,6 +24,7 @@ const _ = grpc.SupportPackageIsVersion7
 type GeneratorClient interface {
 	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchResponse, error)
 	FetchMulti(ctx context.Context, in *FetchMultiRequest, opts ...grpc.CallOption) (*FetchMultiResponse, error)
+	FetchRange(ctx context.Context, in *FetchRangeRequest, opts ...grpc.CallOption) (*FetchRangeResponse, error)
 }
 
 type generatorClient struct {
@@ -52,12 +53,22 @@ func (c *generatorClient) FetchMulti(ctx context.Context, in *FetchMultiRequest,
 	return out, nil
 }
 
+func (c *generatorClient) FetchRange(ctx context.Context, in *FetchRangeRequest, opts ...grpc.CallOption) (*FetchRangeResponse, error) {
+	out := new(FetchRangeResponse)
+	err := c.cc.Invoke(ctx, "/katsubushi.Generator/FetchRange", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // GeneratorServer is the server API for Generator service.
 // All implementations must embed UnimplementedGeneratorServer
 // for forward compatibility
 type GeneratorServer interface {
 	Fetch(context.Context, *FetchRequest) (*FetchResponse, error)
 	FetchMulti(context.Context, *FetchMultiRequest) (*FetchMultiResponse, error)
+	FetchRange(context.Context, *FetchRangeRequest) (*FetchRangeResponse, error)
 	mustEmbedUnimplementedGeneratorServer()
 }
 
@@ -71,6 +82,9 @@ func (UnimplementedGeneratorServer) Fetch(context.Context, *FetchRequest) (*Fetc
 func (UnimplementedGeneratorServer) FetchMulti(context.Context, *FetchMultiRequest) (*FetchMultiResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method FetchMulti not implemented")
 }
+func (UnimplementedGeneratorServer) FetchRange(context.Context, *FetchRangeRequest) (*FetchRangeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FetchRange not implemented")
+}
 func (UnimplementedGeneratorServer) mustEmbedUnimplementedGeneratorServer() {}
 
 // UnsafeGeneratorServer may be embedded to opt out of forward compatibility for this service.
@@ -120,6 +134,24 @@ func _Generator_FetchMulti_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Generator_FetchRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRangeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GeneratorServer).FetchRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/katsubushi.Generator/FetchRange",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GeneratorServer).FetchRange(ctx, req.(*FetchRangeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Generator_ServiceDesc is the grpc.ServiceDesc for Generator service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -135,6 +167,168 @@ var Generator_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "FetchMulti",
 			Handler:    _Generator_FetchMulti_Handler,
 		},
+		{
+			MethodName: "FetchRange",
+			Handler:    _Generator_FetchRange_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "main.proto",
+}
+
+// WorkerLeaserClient is the client API for WorkerLeaser service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type WorkerLeaserClient interface {
+	Lease(ctx context.Context, in *WorkerLeaseRequest, opts ...grpc.CallOption) (*WorkerLeaseResponse, error)
+	Renew(ctx context.Context, in *WorkerRenewRequest, opts ...grpc.CallOption) (*WorkerLeaseResponse, error)
+	Release(ctx context.Context, in *WorkerReleaseRequest, opts ...grpc.CallOption) (*WorkerReleaseResponse, error)
+}
+
+type workerLeaserClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewWorkerLeaserClient(cc grpc.ClientConnInterface) WorkerLeaserClient {
+	return &workerLeaserClient{cc}
+}
+
+func (c *workerLeaserClient) Lease(ctx context.Context, in *WorkerLeaseRequest, opts ...grpc.CallOption) (*WorkerLeaseResponse, error) {
+	out := new(WorkerLeaseResponse)
+	err := c.cc.Invoke(ctx, "/katsubushi.WorkerLeaser/Lease", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerLeaserClient) Renew(ctx context.Context, in *WorkerRenewRequest, opts ...grpc.CallOption) (*WorkerLeaseResponse, error) {
+	out := new(WorkerLeaseResponse)
+	err := c.cc.Invoke(ctx, "/katsubushi.WorkerLeaser/Renew", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *workerLeaserClient) Release(ctx context.Context, in *WorkerReleaseRequest, opts ...grpc.CallOption) (*WorkerReleaseResponse, error) {
+	out := new(WorkerReleaseResponse)
+	err := c.cc.Invoke(ctx, "/katsubushi.WorkerLeaser/Release", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// WorkerLeaserServer is the server API for WorkerLeaser service.
+// All implementations must embed UnimplementedWorkerLeaserServer
+// for forward compatibility
+type WorkerLeaserServer interface {
+	Lease(context.Context, *WorkerLeaseRequest) (*WorkerLeaseResponse, error)
+	Renew(context.Context, *WorkerRenewRequest) (*WorkerLeaseResponse, error)
+	Release(context.Context, *WorkerReleaseRequest) (*WorkerReleaseResponse, error)
+	mustEmbedUnimplementedWorkerLeaserServer()
+}
+
+// UnimplementedWorkerLeaserServer must be embedded to have forward compatible implementations.
+type UnimplementedWorkerLeaserServer struct {
+}
+
+func (UnimplementedWorkerLeaserServer) Lease(context.Context, *WorkerLeaseRequest) (*WorkerLeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Lease not implemented")
+}
+func (UnimplementedWorkerLeaserServer) Renew(context.Context, *WorkerRenewRequest) (*WorkerLeaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Renew not implemented")
+}
+func (UnimplementedWorkerLeaserServer) Release(context.Context, *WorkerReleaseRequest) (*WorkerReleaseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Release not implemented")
+}
+func (UnimplementedWorkerLeaserServer) mustEmbedUnimplementedWorkerLeaserServer() {}
+
+// UnsafeWorkerLeaserServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to WorkerLeaserServer will
+// result in compilation errors.
+type UnsafeWorkerLeaserServer interface {
+	mustEmbedUnimplementedWorkerLeaserServer()
+}
+
+func RegisterWorkerLeaserServer(s grpc.ServiceRegistrar, srv WorkerLeaserServer) {
+	s.RegisterService(&WorkerLeaser_ServiceDesc, srv)
+}
+
+func _WorkerLeaser_Lease_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WorkerLeaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerLeaserServer).Lease(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/katsubushi.WorkerLeaser/Lease",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerLeaserServer).Lease(ctx, req.(*WorkerLeaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerLeaser_Renew_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WorkerRenewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerLeaserServer).Renew(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/katsubushi.WorkerLeaser/Renew",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerLeaserServer).Renew(ctx, req.(*WorkerRenewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _WorkerLeaser_Release_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WorkerReleaseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(WorkerLeaserServer).Release(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/katsubushi.WorkerLeaser/Release",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(WorkerLeaserServer).Release(ctx, req.(*WorkerReleaseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// WorkerLeaser_ServiceDesc is the grpc.ServiceDesc for WorkerLeaser service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var WorkerLeaser_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "katsubushi.WorkerLeaser",
+	HandlerType: (*WorkerLeaserServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Lease",
+			Handler:    _WorkerLeaser_Lease_Handler,
+		},
+		{
+			MethodName: "Renew",
+			Handler:    _WorkerLeaser_Renew_Handler,
+		},
+		{
+			MethodName: "Release",
+			Handler:    _WorkerLeaser_Release_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "main.proto",
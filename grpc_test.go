@@ -87,6 +87,26 @@ func TestGRPCMulti(t *testing.T) {
 	}
 }
 
+func TestGRPCRange(t *testing.T) {
+	client, close, err := newgRPCClient()
+	defer close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.FetchRange(context.Background(), &grpc.FetchRangeRequest{N: 5000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges := make([]katsubushi.IDRange, 0, len(res.Ranges))
+	for _, r := range res.Ranges {
+		ranges = append(ranges, katsubushi.IDRange{Start: r.Start, Count: int(r.Count)})
+	}
+	if n := katsubushi.NewIDRanges(ranges).Len(); n != 5000 {
+		t.Errorf("ranges should contain 5000 IDs but %d", n)
+	}
+	t.Logf("gRPC fetched ranges: %v", ranges)
+}
+
 func BenchmarkGRPCClientFetch(b *testing.B) {
 	b.ResetTimer()
 
@@ -3,12 +3,14 @@ package katsubushi
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
 	"strconv"
 	"strings"
 	"sync"
@@ -22,19 +24,18 @@ const (
 	MaxHTTPBulkSize = 1000
 )
 
+// rejectedConnKey is a context key of requests on connections over the limit of connections.
+type rejectedConnKey struct{}
+
 func (app *App) RunHTTPServer(ctx context.Context, cfg *Config) error {
-	mux := http.NewServeMux()
-	mux.HandleFunc(fmt.Sprintf("/%sid", cfg.HTTPPathPrefix), app.HTTPGetSingleID)
-	mux.HandleFunc(fmt.Sprintf("/%sids", cfg.HTTPPathPrefix), app.HTTPGetMultiID)
-	mux.HandleFunc(fmt.Sprintf("/%sstats", cfg.HTTPPathPrefix), app.HTTPGetStats)
-	s := &http.Server{
-		Handler: mux,
-	}
+	s := app.newHTTPServer(cfg)
 	// shutdown
+	done := make(chan struct{})
 	go func() {
 		<-ctx.Done()
 		log.Infof("Shutting down HTTP server")
-		s.Shutdown(ctx)
+		app.shutdownHTTPServer(s, cfg.ShutdownTimeout)
+		close(done)
 	}()
 
 	listener := cfg.HTTPListener
@@ -45,9 +46,88 @@ func (app *App) RunHTTPServer(ctx context.Context, cfg *Config) error {
 			return errors.Wrap(err, "failed to listen")
 		}
 	}
+	app.addHandoffListener("http", listener)
 	listener = app.wrapListener(listener)
+	app.setMaxConnections(cfg.MaxConnections)
+	limitConnections(listener, app.connLimitOf(cfg, muxHTTP))
+	if cfg.TLSConfig != nil {
+		listener = tls.NewListener(listener, cfg.TLSConfig)
+	}
+	app.setListenAddr("http_addr", listener.Addr())
 	log.Infof("Listening HTTP server at %s", listener.Addr())
-	return s.Serve(listener)
+	err := s.Serve(listener)
+	if err == http.ErrServerClosed {
+		// wait for draining
+		<-done
+	}
+	return err
+}
+
+func (app *App) newHTTPServer(cfg *Config) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("/%sid", cfg.HTTPPathPrefix), app.HTTPGetSingleID)
+	mux.HandleFunc(fmt.Sprintf("/%sids", cfg.HTTPPathPrefix), app.HTTPGetMultiID)
+	mux.HandleFunc(fmt.Sprintf("/%srange", cfg.HTTPPathPrefix), app.HTTPGetRange)
+	mux.HandleFunc(fmt.Sprintf("/%sstats", cfg.HTTPPathPrefix), app.HTTPGetStats)
+	mux.HandleFunc(fmt.Sprintf("/%sworker/lease", cfg.HTTPPathPrefix), app.HTTPWorkerLease)
+	mux.HandleFunc(fmt.Sprintf("/%sworker/renew", cfg.HTTPPathPrefix), app.HTTPWorkerLease)
+	mux.HandleFunc(fmt.Sprintf("/%sworker/release", cfg.HTTPPathPrefix), app.HTTPWorkerLease)
+	mux.HandleFunc(fmt.Sprintf("/%shealth", cfg.HTTPPathPrefix), app.HTTPGetHealth)
+	return &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			if req.Context().Value(rejectedConnKey{}) != nil {
+				w.Header().Set("Connection", "close")
+				http.Error(w, ErrTooManyConnections.Error(), http.StatusServiceUnavailable)
+				return
+			}
+			mux.ServeHTTP(w, req)
+		}),
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			if isRejectedConn(conn) {
+				return context.WithValue(ctx, rejectedConnKey{}, true)
+			}
+			return ctx
+		},
+	}
+}
+
+// httpRateLimited responds 429 Too Many Requests when n IDs requested by req are over rate limits,
+// or 400 Bad Request when n is over the burst of them.
+// The namespace of the request is "ns" parameter.
+func (app *App) httpRateLimited(w http.ResponseWriter, req *http.Request, n int) bool {
+	addr, _ := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+	err := app.limitRateOf(addr, req.FormValue("ns"), n)
+	if err == nil {
+		return false
+	}
+	log.Warn(err)
+	if isInvalidArgument(err) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return true
+	}
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+	return true
+}
+
+// httpAuthenticated verifies SASL credentials by Basic authentication of req when SASL is enabled.
+// It responds 401 Unauthorized when they are missing or wrong.
+func (app *App) httpAuthenticated(w http.ResponseWriter, req *http.Request) bool {
+	if app.saslAuth == nil {
+		return true
+	}
+	user, password, ok := req.BasicAuth()
+	if ok && app.saslAuth.verify(user, password) {
+		return true
+	}
+	err := ErrAuthRequired
+	if ok {
+		err = ErrAuthFailure
+		log.Warnf("authentication failure of user %s", user)
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="katsubushi"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+	return false
 }
 
 func (app *App) HTTPGetSingleID(w http.ResponseWriter, req *http.Request) {
@@ -56,6 +136,9 @@ func (app *App) HTTPGetSingleID(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 	atomic.AddInt64(&app.cmdGet, 1)
+	if app.httpRateLimited(w, req, 1) {
+		return
+	}
 	id, err := app.NextID()
 	if err != nil {
 		log.Error(err)
@@ -97,6 +180,9 @@ func (app *App) HTTPGetMultiID(w http.ResponseWriter, req *http.Request) {
 		w.Write([]byte(msg))
 		return
 	}
+	if app.httpRateLimited(w, req, int(n)) {
+		return
+	}
 	ids := make([]string, 0, n)
 	for i := int64(0); i < n; i++ {
 		id, err := app.NextID()
@@ -119,6 +205,55 @@ func (app *App) HTTPGetMultiID(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+type httpIDRange struct {
+	Start string `json:"start"`
+	Count int    `json:"count"`
+}
+
+// HTTPGetRange handles GET /range?n=(number_of_ids) to reserve IDs as contiguous ranges.
+func (app *App) HTTPGetRange(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	atomic.AddInt64(&app.cmdGet, 1)
+	n, err := strconv.Atoi(req.FormValue("n"))
+	if err == nil {
+		err = validateRangeSize(n)
+	}
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if app.httpRateLimited(w, req, n) {
+		return
+	}
+	ranges, err := app.NextRanges(n)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	log.Debugf("Generated ID ranges: %v", ranges)
+	if strings.Contains(req.Header.Get("Accept"), "application/json") {
+		rs := make([]httpIDRange, 0, len(ranges))
+		for _, r := range ranges {
+			rs = append(rs, httpIDRange{Start: strconv.FormatUint(r.Start, 10), Count: r.Count})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Ranges []httpIDRange `json:"ranges"`
+		}{rs})
+	} else {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, r := range ranges {
+			fmt.Fprintf(w, "%d %d\n", r.Start, r.Count)
+		}
+	}
+}
+
 func (app *App) HTTPGetStats(w http.ResponseWriter, req *http.Request) {
 	if req.Method != "GET" {
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -135,11 +270,85 @@ func (app *App) HTTPGetStats(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// HTTPGetHealth handles GET /health, which responds 503 Service Unavailable while draining on shutdown.
+func (app *App) HTTPGetHealth(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	if app.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, "draining")
+		return
+	}
+	fmt.Fprint(w, "OK")
+}
+
+type httpWorkerLease struct {
+	WorkerID uint   `json:"worker_id"`
+	Token    string `json:"token"`
+	TTL      int64  `json:"ttl_ms"`
+}
+
+// HTTPWorkerLease handles POST /worker/lease, /worker/renew and /worker/release.
+// renew and release require worker_id and token parameters.
+// They require SASL credentials by Basic authentication when SASL is enabled.
+func (app *App) HTTPWorkerLease(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !app.httpAuthenticated(w, req) || app.httpRateLimited(w, req, 1) {
+		return
+	}
+	var l *WorkerLease
+	var err error
+	op := path.Base(req.URL.Path)
+	if op == "lease" {
+		addr, _ := net.ResolveTCPAddr("tcp", req.RemoteAddr)
+		l, err = app.leaseWorker(addr)
+	} else {
+		id, perr := strconv.ParseUint(req.FormValue("worker_id"), 10, 64)
+		if perr != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		token := req.FormValue("token")
+		if op == "renew" {
+			l, err = app.renewWorker(uint(id), token)
+		} else {
+			err = app.releaseWorker(uint(id), token)
+		}
+	}
+	switch {
+	case err == ErrWorkerLeaseNotFound, err == ErrWorkerLeaseDisabled:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error(err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	case l == nil:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(httpWorkerLease{
+		WorkerID: l.WorkerID,
+		Token:    l.Token,
+		TTL:      l.TTL.Milliseconds(),
+	})
+}
+
 type HTTPClient struct {
 	client     *http.Client
 	urls       []*url.URL
 	pathPrefix string
 	pool       *sync.Pool
+
+	user     string
+	password string
 }
 
 // NewHTTPClient creates HTTPClient
@@ -172,6 +381,21 @@ func (c *HTTPClient) SetTimeout(t time.Duration) {
 	c.client.Timeout = t
 }
 
+// SetTLSConfig sets cfg to connect to katsubushi servers by https URLs.
+func (c *HTTPClient) SetTLSConfig(cfg *tls.Config) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = cfg
+	// the HTTP API is served over HTTP/1.1. HTTP/2 is gRPC on multiplexed listeners.
+	t.ForceAttemptHTTP2 = false
+	c.client.Transport = t
+}
+
+// SetBasicAuth sets SASL credentials to lease worker IDs from katsubushi servers with SASL enabled.
+func (c *HTTPClient) SetBasicAuth(user, password string) {
+	c.user = user
+	c.password = password
+}
+
 // Fetch fetches id from katsubushi via HTTP
 func (c *HTTPClient) Fetch(ctx context.Context) (uint64, error) {
 	errs := errors.New("no servers available")
@@ -256,3 +480,117 @@ func (c *HTTPClient) FetchMulti(ctx context.Context, n int) ([]uint64, error) {
 	}
 	return nil, errs
 }
+
+// FetchRange fetches n IDs as contiguous ranges via HTTP.
+// IDs are expanded lazily by IDRanges.
+func (c *HTTPClient) FetchRange(ctx context.Context, n int) (*IDRanges, error) {
+	errs := errors.New("no servers available")
+	for _, _u := range c.urls {
+		u := *_u
+		u.Path = fmt.Sprintf("/%srange", c.pathPrefix)
+		u.RawQuery = fmt.Sprintf("n=%d", n)
+		ranges, err := c.fetchRange(ctx, u.String())
+		if err != nil {
+			errs = errors.Wrapf(errs, "failed to fetch ranges from %s: %s", _u, err)
+			continue
+		}
+		return NewIDRanges(ranges), nil
+	}
+	return nil, errs
+}
+
+func (c *HTTPClient) fetchRange(ctx context.Context, u string) ([]IDRange, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	b := c.pool.Get().(*bytes.Buffer)
+	defer func() {
+		b.Reset()
+		c.pool.Put(b)
+	}()
+	if _, err := io.Copy(b, resp.Body); err != nil {
+		return nil, err
+	}
+	var ranges []IDRange
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		start, count, _ := strings.Cut(line, " ")
+		r, err := parseIDRange(start, count)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// LeaseWorker leases a worker ID via HTTP to generate IDs locally. See LeasedGenerator.
+func (c *HTTPClient) LeaseWorker(ctx context.Context) (*WorkerLease, error) {
+	errs := errors.New("no servers available")
+	for i := range c.urls {
+		l, err := c.workerLease(ctx, i, "lease", nil)
+		if err != nil {
+			errs = errors.Wrapf(errs, "failed to lease worker id from %s: %s", c.urls[i], err)
+			continue
+		}
+		return l, nil
+	}
+	return nil, errs
+}
+
+// RenewWorker renews the lease of the worker ID via HTTP on the server which leased it.
+func (c *HTTPClient) RenewWorker(ctx context.Context, l *WorkerLease) (*WorkerLease, error) {
+	return c.workerLease(ctx, l.server, "renew", l)
+}
+
+// ReleaseWorker releases the lease of the worker ID via HTTP on the server which leased it.
+func (c *HTTPClient) ReleaseWorker(ctx context.Context, l *WorkerLease) error {
+	_, err := c.workerLease(ctx, l.server, "release", l)
+	return err
+}
+
+func (c *HTTPClient) workerLease(ctx context.Context, server int, op string, l *WorkerLease) (*WorkerLease, error) {
+	u := *c.urls[server]
+	u.Path = fmt.Sprintf("/%sworker/%s", c.pathPrefix, op)
+	form := url.Values{}
+	if l != nil {
+		form.Set("worker_id", strconv.FormatUint(uint64(l.WorkerID), 10))
+		form.Set("token", l.Token)
+	}
+	req, _ := http.NewRequestWithContext(ctx, "POST", u.String(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if c.user != "" {
+		req.SetBasicAuth(c.user, c.password)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, ErrWorkerLeaseNotFound
+	case http.StatusUnauthorized:
+		return nil, ErrAuthFailure
+	default:
+		return nil, errors.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	var res httpWorkerLease
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+	return &WorkerLease{
+		WorkerID: res.WorkerID,
+		Token:    res.Token,
+		TTL:      time.Duration(res.TTL) * time.Millisecond,
+		server:   server,
+	}, nil
+}
@@ -222,3 +222,63 @@ func BenchmarkHTTPClientFetch(b *testing.B) {
 		}
 	})
 }
+
+func TestHTTPRangeCS(t *testing.T) {
+	u := fmt.Sprintf("http://localhost:%d", httpPort)
+	client, err := katsubushi.NewHTTPClient([]string{u}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.FetchRange(context.Background(), 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 5000 {
+		t.Errorf("ranges should contain 5000 IDs but %d", r.Len())
+	}
+	var last uint64
+	for id, ok := r.Next(); ok; id, ok = r.Next() {
+		if id <= last {
+			t.Fatalf("id %d should be larger than %d", id, last)
+		}
+		last = id
+	}
+	t.Logf("HTTP fetched ranges: %v", r.Ranges())
+}
+
+func TestHTTPRangeJSON(t *testing.T) {
+	req := httptest.NewRequest("GET", "/range?n=10", nil)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+
+	httpApp.HTTPGetRange(w, req)
+	if w.Code != 200 {
+		t.Errorf("status code should be 200 but %d", w.Code)
+	}
+	var res struct {
+		Ranges []struct {
+			Start string `json:"start"`
+			Count int    `json:"count"`
+		} `json:"ranges"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, r := range res.Ranges {
+		if _, err := strconv.ParseUint(r.Start, 10, 64); err != nil {
+			t.Errorf("start should be a number uint64: %v", err)
+		}
+		total += r.Count
+	}
+	if total != 10 {
+		t.Errorf("ranges should contain 10 IDs but %d", total)
+	}
+
+	req = httptest.NewRequest("GET", fmt.Sprintf("/range?n=%d", katsubushi.MaxRangeSize+1), nil)
+	w = httptest.NewRecorder()
+	httpApp.HTTPGetRange(w, req)
+	if w.Code != 400 {
+		t.Errorf("status code should be 400 but %d", w.Code)
+	}
+}
@@ -0,0 +1,117 @@
+package katsubushi
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ListenAddr is an address to listen the memcached protocol.
+type ListenAddr struct {
+	// Network is tcp, tcp4, tcp6 or unix.
+	Network string
+
+	// Address is host:port for TCP, and a path of the socket for unix.
+	Address string
+
+	// MaxConnections limits connections of the address. 0 means unlimited.
+	MaxConnections int
+
+	// Mode is a permission of the socket for unix. 0 means the default by umask.
+	Mode os.FileMode
+
+	// DisableTLS serves plain connections on the address even if Config.TLSConfig is set,
+	// for local clients over unix domain sockets.
+	DisableTLS bool
+}
+
+// ParseListenAddr parses an address as a URL like "tcp://:11212", "tcp6://[::1]:11212" or
+// "unix:///var/run/katsubushi.sock?mode=0660". Options are given by query parameters,
+// max_connections, mode and tls=false.
+// An address without a scheme is a TCP address, or a path of a unix domain socket when it begins with "/" or ".".
+func ParseListenAddr(s string) (ListenAddr, error) {
+	if !strings.Contains(s, "://") {
+		if strings.HasPrefix(s, "/") || strings.HasPrefix(s, ".") {
+			return ListenAddr{Network: "unix", Address: s}, nil
+		}
+		return ListenAddr{Network: "tcp", Address: s}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return ListenAddr{}, fmt.Errorf("invalid listen address %s: %w", s, err)
+	}
+	a := ListenAddr{Network: u.Scheme}
+	switch u.Scheme {
+	case "tcp", "tcp4", "tcp6":
+		a.Address = u.Host
+	case "unix":
+		a.Address = u.Host + u.Path
+	default:
+		return ListenAddr{}, fmt.Errorf("invalid listen address %s: unsupported network %s", s, u.Scheme)
+	}
+	if a.Address == "" {
+		return ListenAddr{}, fmt.Errorf("invalid listen address %s: no address", s)
+	}
+	q := u.Query()
+	if v := q.Get("max_connections"); v != "" {
+		if a.MaxConnections, err = strconv.Atoi(v); err != nil {
+			return ListenAddr{}, fmt.Errorf("invalid max_connections of %s: %w", s, err)
+		}
+	}
+	if v := q.Get("mode"); v != "" {
+		if a.Network != "unix" {
+			return ListenAddr{}, fmt.Errorf("invalid listen address %s: mode is only for unix", s)
+		}
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil {
+			return ListenAddr{}, fmt.Errorf("invalid mode of %s: %w", s, err)
+		}
+		a.Mode = os.FileMode(mode)
+	}
+	if v := q.Get("tls"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return ListenAddr{}, fmt.Errorf("invalid tls of %s: %w", s, err)
+		}
+		a.DisableTLS = !enabled
+	}
+	return a, nil
+}
+
+// String returns the address as a URL.
+func (a ListenAddr) String() string {
+	return a.Network + "://" + a.Address
+}
+
+// listenAddrsOf returns addresses to listen by kc. Port or Sockpath is used when Listens is empty.
+func listenAddrsOf(kc *Config) []ListenAddr {
+	if len(kc.Listens) > 0 {
+		return kc.Listens
+	}
+	if kc.Sockpath != "" {
+		// NOTE: gomemcache expect filepath contains slashes.
+		return []ListenAddr{{Network: "unix", Address: filepath.ToSlash(kc.Sockpath)}}
+	}
+	return []ListenAddr{{Network: "tcp", Address: fmt.Sprintf(":%d", kc.Port)}}
+}
+
+// ListenerAddr starts listen on a.
+func (app *App) ListenerAddr(a ListenAddr) (net.Listener, error) {
+	l, err := net.Listen(a.Network, a.Address)
+	if err != nil {
+		return nil, err
+	}
+	if a.Network == "unix" && a.Mode != 0 {
+		if err := os.Chmod(a.Address, a.Mode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	l = app.wrapListener(l)
+	limitConnections(l, newConnLimit(a.MaxConnections))
+	return l, nil
+}
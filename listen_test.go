@@ -0,0 +1,106 @@
+package katsubushi
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseListenAddr(t *testing.T) {
+	tests := []struct {
+		s      string
+		expect ListenAddr
+	}{
+		{"tcp://:11212", ListenAddr{Network: "tcp", Address: ":11212"}},
+		{"tcp6://[::1]:11212?max_connections=10", ListenAddr{Network: "tcp6", Address: "[::1]:11212", MaxConnections: 10}},
+		{"unix:///var/run/katsubushi.sock?mode=0660&tls=false", ListenAddr{Network: "unix", Address: "/var/run/katsubushi.sock", Mode: 0660, DisableTLS: true}},
+		{"unix://./katsubushi.sock", ListenAddr{Network: "unix", Address: "./katsubushi.sock"}},
+		{"localhost:11212", ListenAddr{Network: "tcp", Address: "localhost:11212"}},
+		{"/tmp/katsubushi.sock", ListenAddr{Network: "unix", Address: "/tmp/katsubushi.sock"}},
+	}
+	for _, tt := range tests {
+		a, err := ParseListenAddr(tt.s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %s", tt.s, err)
+			continue
+		}
+		if a != tt.expect {
+			t.Errorf("unexpected address of %s: %#v", tt.s, a)
+		}
+	}
+
+	for _, s := range []string{
+		"udp://:11212",
+		"tcp://",
+		"tcp://:11212?mode=0660",
+		"unix:///tmp/katsubushi.sock?mode=rw",
+		"tcp://:11212?max_connections=many",
+	} {
+		if _, err := ParseListenAddr(s); err == nil {
+			t.Errorf("%s must be invalid", s)
+		}
+	}
+}
+
+func TestAppListens(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, _, _ := newTestCertificate(t)
+	sockpath := filepath.Join(t.TempDir(), "katsubushi.sock")
+	app := newTestApp(t, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- app.RunServer(ctx, &Config{
+			Listens: []ListenAddr{
+				{Network: "tcp", Address: "localhost:0"},
+				{Network: "unix", Address: sockpath, Mode: 0600, DisableTLS: true},
+			},
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		})
+	}()
+	<-app.Ready()
+
+	if info, err := os.Stat(sockpath); err != nil {
+		t.Fatal(err)
+	} else if info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected mode: %s", info.Mode())
+	}
+
+	// plain on the unix domain socket
+	client, err := newTestClientSock(sockpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+	// TLS on TCP
+	conn, err := tls.Dial("tcp", app.Listener.Addr().String(), newTestClientTLSConfig(t, cert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	stats, err := app.statsOf("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, s := range stats {
+		if s.name == "addr" {
+			found = s.value == app.Listener.Addr().String()+","+sockpath
+		}
+	}
+	if !found {
+		t.Errorf("addr of settings must have all addresses: %v", stats)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+}
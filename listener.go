@@ -1,11 +1,21 @@
 package katsubushi
 
 import (
+	"crypto/tls"
+	"errors"
 	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// ErrTooManyConnections is an error responded to connections over the limit of connections.
+var ErrTooManyConnections = errors.New("too many connections")
+
+// rejectedConnLifetime is a time to close rejected connections after accepted,
+// to respond errors to requests already sent by HTTP and gRPC clients.
+const rejectedConnLifetime = 3 * time.Second
+
 func (app *App) wrapListener(l net.Listener) net.Listener {
 	if _, wrapped := l.(*monitListener); wrapped {
 		// already wrapped
@@ -17,30 +27,197 @@ func (app *App) wrapListener(l net.Listener) net.Listener {
 	}
 }
 
+// setMaxConnections sets the limit of connections over all listeners. 0 means unlimited.
+func (app *App) setMaxConnections(max int) {
+	if max > 0 {
+		atomic.StoreInt64(&app.maxConnections, int64(max))
+	}
+}
+
+// connLimit is a limit of connections shared by listeners.
+type connLimit struct {
+	max int64
+
+	// accessed atomically
+	curr int64
+}
+
+// newConnLimit creates connLimit. It returns nil for max <= 0, which means unlimited.
+func newConnLimit(max int) *connLimit {
+	if max <= 0 {
+		return nil
+	}
+	return &connLimit{max: int64(max)}
+}
+
+// connLimitOf returns the limit of connections of the protocol by cfg, shared by all servers of app.
+func (app *App) connLimitOf(cfg *Config, protocol int) *connLimit {
+	app.connLimitsOnce.Do(func() {
+		app.connLimits = [3]*connLimit{
+			muxMemcache: newConnLimit(cfg.MaxMemcacheConnections),
+			muxHTTP:     newConnLimit(cfg.MaxHTTPConnections),
+			muxGRPC:     newConnLimit(cfg.MaxGRPCConnections),
+		}
+	})
+	return app.connLimits[protocol]
+}
+
+// limitConnections limits connections of l wrapped by wrapListener by lim.
+// It must be called before accepting connections.
+func limitConnections(l net.Listener, lim *connLimit) {
+	if ml, ok := l.(*monitListener); ok && lim != nil {
+		ml.limits = append(ml.limits, lim)
+	}
+}
+
 type monitListener struct {
 	net.Listener
 	app *App
+
+	limits []*connLimit
+}
+
+// acquire counts a new connection, and returns false when it is over the limits.
+func (l *monitListener) acquire() bool {
+	curr := atomic.AddInt64(&l.app.currConnections, 1)
+	ok := true
+	if max := atomic.LoadInt64(&l.app.maxConnections); max > 0 && curr > max {
+		ok = false
+	}
+	for _, lim := range l.limits {
+		if atomic.AddInt64(&lim.curr, 1) > lim.max {
+			ok = false
+		}
+	}
+	if !ok {
+		l.release()
+	}
+	return ok
+}
+
+func (l *monitListener) release() {
+	atomic.AddInt64(&l.app.currConnections, -1)
+	for _, lim := range l.limits {
+		atomic.AddInt64(&lim.curr, -1)
+	}
 }
 
 func (l *monitListener) Accept() (net.Conn, error) {
-	conn, err := l.Listener.Accept()
-	if err != nil {
-		return nil, err
+	var conn net.Conn
+	for {
+		var err error
+		conn, err = l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		p := l.app.proxyProtocol
+		if p == nil {
+			break
+		}
+		if p.trusted(conn.RemoteAddr()) {
+			conn = newProxyConn(conn, p)
+			break
+		}
+		if !p.Required {
+			break
+		}
+		log.Warnf("Rejected a connection from untrusted source %s", conn.RemoteAddr())
+		conn.Close()
+	}
+
+	if !l.acquire() {
+		atomic.AddInt64(&l.app.rejectedConnections, 1)
+		log.Warnf("Rejecting a connection on %s: %s", l.Addr(), ErrTooManyConnections)
+		time.AfterFunc(rejectedConnLifetime, func() { conn.Close() })
+		// the protocol responds the error and closes it
+		return &monitConn{Conn: conn, rejected: true}, nil
 	}
-	atomic.AddInt64(&l.app.currConnections, 1)
 	atomic.AddInt64(&l.app.totalConnections, 1)
-	return &monitConn{conn, l.app, &sync.Once{}}, nil
+	return &monitConn{Conn: conn, l: l}, nil
 }
 
 type monitConn struct {
 	net.Conn
-	app  *App
-	once *sync.Once
+	l *monitListener
+
+	mu       sync.Mutex
+	released bool
+	// limits are counted after accepted by limitConn.
+	limits []*connLimit
+
+	// rejected is true when the connection is over the limit of connections.
+	rejected bool
 }
 
 func (c *monitConn) Close() error {
-	c.once.Do(func() {
-		atomic.AddInt64(&c.app.currConnections, -1)
-	})
+	c.release()
 	return c.Conn.Close()
 }
+
+func (c *monitConn) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.releaseLocked()
+}
+
+func (c *monitConn) releaseLocked() {
+	if c.rejected || c.released {
+		return
+	}
+	c.released = true
+	c.l.release()
+	for _, lim := range c.limits {
+		atomic.AddInt64(&lim.curr, -1)
+	}
+}
+
+// limitConn counts conn by lim after accepted, for connections on multiplexed listeners
+// whose protocol is known after sniffing. conn is rejected when it is over lim.
+func limitConn(conn net.Conn, lim *connLimit) {
+	c := monitConnOf(conn)
+	if c == nil || lim == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.rejected || c.released {
+		return
+	}
+	if atomic.AddInt64(&lim.curr, 1) <= lim.max {
+		c.limits = append(c.limits, lim)
+		return
+	}
+	atomic.AddInt64(&lim.curr, -1)
+	c.releaseLocked()
+	c.rejected = true
+	atomic.AddInt64(&c.l.app.rejectedConnections, 1)
+	log.Warnf("Rejecting a connection on %s: %s", c.l.Addr(), ErrTooManyConnections)
+	time.AfterFunc(rejectedConnLifetime, func() { conn.Close() })
+}
+
+// monitConnOf returns monitConn under conn, or nil.
+func monitConnOf(conn net.Conn) *monitConn {
+	for {
+		switch c := conn.(type) {
+		case *tls.Conn:
+			conn = c.NetConn()
+		case *bufferedConn:
+			conn = c.Conn
+		case *monitConn:
+			return c
+		default:
+			return nil
+		}
+	}
+}
+
+// isRejectedConn reports whether conn is over the limit of connections.
+func isRejectedConn(conn net.Conn) bool {
+	c := monitConnOf(conn)
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rejected
+}
@@ -0,0 +1,130 @@
+package katsubushi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kayac/go-katsubushi/v2/grpc"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func TestAppMaxConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := newTestApp(t, nil)
+	l, _ := app.ListenerTCP("localhost:0")
+	limitConnections(l, newConnLimit(1))
+	go app.Serve(ctx, l)
+	<-app.Ready()
+	addr := app.Listener.Addr().String()
+
+	client, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+
+	rejected, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejected.conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(rejected.conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "SERVER_ERROR too many connections\r\n" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+
+	stats := app.GetStats()
+	if stats.RejectedConnections != 1 || stats.CurrConnections != 1 {
+		t.Errorf("unexpected stats: %#v", stats)
+	}
+
+	// accepted again after the connection is closed
+	client.conn.Close()
+	waitFor(t, func() bool { return atomic.LoadInt64(&app.currConnections) == 0 }, "connection must be closed")
+	client, err = newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+}
+
+func TestHTTPMaxConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a connection of memcached protocol reaches the limit over all listeners
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.conn.Close()
+	go app.RunHTTPServer(ctx, &Config{HTTPListener: l, MaxConnections: 1})
+
+	u := fmt.Sprintf("http://%s/id", l.Addr())
+	var res *http.Response
+	waitFor(t, func() bool {
+		res, err = http.Get(u)
+		return err == nil
+	}, "failed to request")
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+	if n := app.GetStats().RejectedConnections; n != 1 {
+		t.Errorf("unexpected rejected_connections: %d", n)
+	}
+}
+
+func TestGRPCMaxConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	app := newTestApp(t, nil)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunGRPCServer(ctx, &Config{GRPCListener: l, MaxGRPCConnections: 1})
+
+	fetch := func() error {
+		conn, err := gogrpc.Dial(l.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel2()
+		_, err = grpc.NewGeneratorClient(conn).Fetch(ctx2, &grpc.FetchRequest{}, gogrpc.WaitForReady(true))
+		return err
+	}
+	if err := fetch(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fetch(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
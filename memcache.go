@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"io"
 	"net"
@@ -14,12 +15,16 @@ import (
 
 const memcacheDefaultTimeout = 1 * time.Second
 
+var memdAuthenticated = []byte("OK")
+
 type memcacheClient struct {
-	addr    string
-	conn    net.Conn
-	timeout time.Duration
-	mu      sync.Mutex
-	rw      *bufio.ReadWriter
+	addr      string
+	conn      net.Conn
+	timeout   time.Duration
+	authToken string
+	tlsConfig *tls.Config
+	mu        sync.Mutex
+	rw        *bufio.ReadWriter
 }
 
 func newMemcacheClient(addr string) *memcacheClient {
@@ -35,12 +40,55 @@ func (c *memcacheClient) SetTimeout(t time.Duration) {
 	c.timeout = t
 }
 
+func (c *memcacheClient) SetAuthToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.authToken = token
+}
+
+func (c *memcacheClient) SetTLSConfig(cfg *tls.Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tlsConfig = cfg
+}
+
 func (c *memcacheClient) connect(ctx context.Context) error {
 	var err error
-	d := net.Dialer{Timeout: c.timeout}
-	c.conn, err = d.DialContext(ctx, "tcp", c.addr)
+	d := &net.Dialer{Timeout: c.timeout}
+	if c.tlsConfig != nil {
+		td := tls.Dialer{NetDialer: d, Config: c.tlsConfig}
+		c.conn, err = td.DialContext(ctx, "tcp", c.addr)
+	} else {
+		c.conn, err = d.DialContext(ctx, "tcp", c.addr)
+	}
+	if err != nil {
+		return err
+	}
 	c.rw = bufio.NewReadWriter(bufio.NewReader(c.conn), bufio.NewWriter(c.conn))
-	return err
+	if c.authToken != "" {
+		if err := c.auth(); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *memcacheClient) auth() error {
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	io.WriteString(c.rw, "AUTH "+c.authToken)
+	c.rw.Write(memdSep)
+	if err := c.rw.Flush(); err != nil {
+		return err
+	}
+	res, _, err := c.rw.ReadLine()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(res, memdAuthenticated) {
+		return errors.New("authentication failed: " + string(res))
+	}
+	return nil
 }
 
 func (c *memcacheClient) close() error {
@@ -52,11 +100,20 @@ func (c *memcacheClient) close() error {
 }
 
 func (c *memcacheClient) Get(ctx context.Context, key string) (uint64, error) {
+	value, err := c.GetBytes(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// GetBytes gets a raw value of key.
+func (c *memcacheClient) GetBytes(ctx context.Context, key string) ([]byte, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.conn == nil {
 		if err := c.connect(ctx); err != nil {
-			return 0, err
+			return nil, err
 		}
 	}
 	c.conn.SetDeadline(time.Now().Add(c.timeout))
@@ -66,24 +123,24 @@ func (c *memcacheClient) Get(ctx context.Context, key string) (uint64, error) {
 	c.rw.Write(memdSep)
 	if err := c.rw.Flush(); err != nil {
 		c.close()
-		return 0, err
+		return nil, err
 	}
 
-	id, err := readValue(c.rw.Reader)
+	value, err := readBytes(c.rw.Reader)
 	if err != nil {
 		c.close()
-		return 0, err
+		return nil, err
 	}
 	end, _, err := c.rw.ReadLine()
 	if err != nil {
 		c.close()
-		return 0, err
+		return nil, err
 	}
 	if !bytes.Equal(end, memdEnd) {
 		c.close()
-		return 0, errors.New("unexpected response. not END")
+		return nil, errors.New("unexpected response. not END")
 	}
-	return id, nil
+	return value, nil
 }
 
 func (c *memcacheClient) GetMulti(ctx context.Context, keys []string) ([]uint64, error) {
@@ -127,25 +184,57 @@ func (c *memcacheClient) GetMulti(ctx context.Context, keys []string) ([]uint64,
 	return ids, nil
 }
 
+// Command sends a command line and returns a response line.
+func (c *memcacheClient) Command(ctx context.Context, line string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		if err := c.connect(ctx); err != nil {
+			return nil, err
+		}
+	}
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	io.WriteString(c.rw, line)
+	c.rw.Write(memdSep)
+	if err := c.rw.Flush(); err != nil {
+		c.close()
+		return nil, err
+	}
+	res, _, err := c.rw.ReadLine()
+	if err != nil {
+		c.close()
+		return nil, err
+	}
+	return res, nil
+}
+
 func readValue(r *bufio.Reader) (uint64, error) {
-	line, _, err := r.ReadLine()
+	value, err := readBytes(r)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(value), 10, 64)
 	if err != nil {
 		return 0, err
 	}
+	return id, nil
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	line, _, err := r.ReadLine()
+	if err != nil {
+		return nil, err
+	}
 	if len(line) == 0 {
-		return 0, errors.New("unexpected response")
+		return nil, errors.New("unexpected response")
 	}
 	fields := bytes.Fields(line)
 	if len(fields) != 4 || !bytes.Equal(fields[0], memdValue) {
-		return 0, errors.New("unexpected response. not VALUE")
+		return nil, errors.New("unexpected response. not VALUE: " + string(line))
 	}
 	value, _, err := r.ReadLine()
 	if err != nil {
-		return 0, err
-	}
-	id, err := strconv.ParseUint(string(value), 10, 64)
-	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return id, nil
+	return value, nil
 }
@@ -0,0 +1,168 @@
+package katsubushi
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var (
+	memdMetaValue = []byte("VA ")
+	memdMetaHit   = []byte("HD")
+	memdMetaMiss  = []byte("EN\r\n")
+	memdMetaNoop  = []byte("MN\r\n")
+	memdMetaDebug = []byte("ME ")
+)
+
+// metaFlags is flags of meta commands, as "v", "k" or "O<opaque>".
+type metaFlags []string
+
+func (fs metaFlags) has(flag byte) bool {
+	for _, f := range fs {
+		if f[0] == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeReturnFlags writes flags to return in a response of key and the value size.
+func (fs metaFlags) writeReturnFlags(w io.Writer, key string, size int) {
+	for _, f := range fs {
+		var ret string
+		switch f[0] {
+		case 'O':
+			ret = f
+		case 'k':
+			ret = "k" + key
+		case 'b':
+			if fs.has('k') {
+				ret = "b"
+			}
+		case 's':
+			ret = "s" + strconv.Itoa(size)
+		case 'f':
+			ret = "f0"
+		case 'c':
+			ret = "c0"
+		case 't':
+			ret = "t-1"
+		}
+		if ret != "" {
+			w.Write(memdSpc)
+			io.WriteString(w, ret)
+		}
+	}
+}
+
+// metaKey decodes key by the flags.
+func (fs metaFlags) metaKey(key string) (string, error) {
+	if !fs.has('b') {
+		return key, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return "", invalidArgumentError("invalid base64 key: " + key)
+	}
+	return string(b), nil
+}
+
+// quietCmd is a command with q flag. Its response is not flushed until a following command as mn.
+type quietCmd interface {
+	quiet() bool
+}
+
+func parseMetaCmd(name string, args []string) (MemdCmd, error) {
+	if name == "MN" {
+		return MemdCmdMetaNoop(0), nil
+	}
+	if len(args) == 0 {
+		return nil, invalidArgumentError(strings.ToLower(name) + " command needs key as second parameter")
+	}
+	flags := metaFlags(args[1:])
+	if _, err := flags.metaKey(args[0]); err != nil {
+		return nil, err
+	}
+	if name == "MG" {
+		return &MemdCmdMetaGet{Key: args[0], Flags: flags}, nil
+	}
+	return &MemdCmdMetaDebug{Key: args[0], Flags: flags}, nil
+}
+
+// MemdCmdMetaGet defines mg command of meta protocol.
+type MemdCmdMetaGet struct {
+	Key   string
+	Flags []string
+}
+
+// Execute generates new ID.
+// It responds "VA <size> <flags>" and the ID with v flag, or "HD <flags>" without v flag.
+// q flag suppresses only EN for a miss, which never happens, so it only defers flushing.
+func (cmd *MemdCmdMetaGet) Execute(app *App, w io.Writer) error {
+	flags := metaFlags(cmd.Flags)
+	key, _ := flags.metaKey(cmd.Key)
+	v, err := app.valueOf(key)
+	if err != nil {
+		log.Warn(err)
+		return app.writeErrorOf(w, err)
+	}
+	if flags.has('v') {
+		w.Write(memdMetaValue)
+		io.WriteString(w, strconv.Itoa(len(v)))
+	} else {
+		w.Write(memdMetaHit)
+	}
+	flags.writeReturnFlags(w, cmd.Key, len(v))
+	w.Write(memdSep)
+	if flags.has('v') {
+		io.WriteString(w, v)
+		w.Write(memdSep)
+	}
+	return nil
+}
+
+func (cmd *MemdCmdMetaGet) quiet() bool {
+	return metaFlags(cmd.Flags).has('q')
+}
+
+// MemdCmdMetaNoop defines mn command of meta protocol.
+type MemdCmdMetaNoop int
+
+// Execute writes MN for the end of pipelined commands.
+func (cmd MemdCmdMetaNoop) Execute(app *App, w io.Writer) error {
+	_, err := w.Write(memdMetaNoop)
+	return err
+}
+
+// MemdCmdMetaDebug defines me command of meta protocol.
+type MemdCmdMetaDebug struct {
+	Key   string
+	Flags []string
+}
+
+// Execute writes an ID in the key decoded as "ME <key> time=<time> worker_id=<worker_id> sequence=<sequence>".
+// It responds "EN" when the key is not an ID, which is suppressed with q flag.
+func (cmd *MemdCmdMetaDebug) Execute(app *App, w io.Writer) error {
+	flags := metaFlags(cmd.Flags)
+	key, _ := flags.metaKey(cmd.Key)
+	id, err := strconv.ParseUint(key, 10, 64)
+	if err != nil {
+		if flags.has('q') {
+			return nil
+		}
+		_, err := w.Write(memdMetaMiss)
+		return err
+	}
+	t, workerID, sequence := Dump(id)
+	w.Write(memdMetaDebug)
+	io.WriteString(w, cmd.Key)
+	fmt.Fprintf(w, " time=%s worker_id=%d sequence=%d", t.UTC().Format("2006-01-02T15:04:05.000Z07:00"), workerID, sequence)
+	_, err = w.Write(memdSep)
+	return err
+}
+
+func (cmd *MemdCmdMetaDebug) quiet() bool {
+	return metaFlags(cmd.Flags).has('q')
+}
@@ -0,0 +1,119 @@
+package katsubushi
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestAppMetaGet(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd    string
+		expect string
+	}{
+		{"mg id v", `^VA \d+\r\n\d+\r\n$`},
+		{"mg id v k O123 s f t c", `^VA \d+ kid O123 s\d+ f0 t-1 c0\r\n\d+\r\n$`},
+		{"mg aWQ= b k v", `^VA \d+ b kaWQ=\r\n\d+\r\n$`},
+		{"mg id O123", `^HD O123\r\n$`},
+		{"mg range:10 v", `^VA \d+\r\n\d+:10\r\n$`},
+		{"mg range:x v", `^CLIENT_ERROR invalid range key: range:x\r\n$`},
+		{"mg", `^CLIENT_ERROR mg command needs key as second parameter\r\n$`},
+		{"mg !!! b v", `^CLIENT_ERROR invalid base64 key: !!!\r\n$`},
+	}
+	for _, tt := range tests {
+		resp, err := client.Command(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(tt.expect).Match(resp) {
+			t.Errorf("unexpected response of %s: %q", tt.cmd, resp)
+		}
+	}
+}
+
+func TestAppMetaPipeline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// EN of me is suppressed by q flag, and HD of mg is not
+	fmt.Fprint(conn, "mg a v q Oa\r\nmg c q Oc\r\nme foo q\r\nmg b v q Ob\r\nmn\r\n")
+	r := bufio.NewReader(conn)
+	var lines []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+		if line == "MN\r\n" {
+			break
+		}
+	}
+	if len(lines) != 6 {
+		t.Fatalf("unexpected responses: %q", lines)
+	}
+	if !regexp.MustCompile(`^VA \d+ Oa\r\n$`).MatchString(lines[0]) {
+		t.Errorf("unexpected response: %q", lines[0])
+	}
+	if lines[2] != "HD Oc\r\n" {
+		t.Errorf("unexpected response: %q", lines[2])
+	}
+	if !regexp.MustCompile(`^VA \d+ Ob\r\n$`).MatchString(lines[3]) {
+		t.Errorf("unexpected response: %q", lines[3])
+	}
+	if lines[5] != "MN\r\n" {
+		t.Errorf("unexpected response: %q", lines[5])
+	}
+}
+
+func TestAppMetaDebug(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := app.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, workerID, sequence := Dump(id)
+	key := strconv.FormatUint(id, 10)
+	resp, err := client.Command("me " + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf("ME %s time=%s worker_id=%d sequence=%d\r\n", key, ts.UTC().Format("2006-01-02T15:04:05.000Z07:00"), workerID, sequence)
+	if string(resp) != expect {
+		t.Errorf("unexpected response: %q", resp)
+	}
+
+	resp, err = client.Command("me foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "EN\r\n" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+}
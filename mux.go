@@ -0,0 +1,227 @@
+package katsubushi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// protocols of connections on multiplexed listeners
+const (
+	muxMemcache = iota
+	muxHTTP
+	muxGRPC
+)
+
+var (
+	http2Preface    = []byte("PRI * HTTP/2.0\r\n")
+	httpRequestLine = regexp.MustCompile(`^[A-Z]+ \S+ HTTP/1\.[01]\r?\n$`)
+)
+
+// sniffProtocol peeks the beginning of r to decide the protocol, without consuming it.
+// A memcached binary request begins with the magic byte, an HTTP/1.1 request begins with a request line
+// and an HTTP/2 (gRPC) connection begins with the client preface. The others are the memcached text protocol.
+func sniffProtocol(r *bufio.Reader) (int, error) {
+	b, err := r.Peek(1)
+	if err != nil {
+		return 0, err
+	}
+	if b[0] == magicRequest {
+		return muxMemcache, nil
+	}
+	line, err := peekLine(r)
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// too long for HTTP request lines
+		return muxMemcache, nil
+	} else if err != nil {
+		return 0, err
+	}
+	switch {
+	case bytes.Equal(line, http2Preface):
+		return muxGRPC, nil
+	case httpRequestLine.Match(line):
+		return muxHTTP, nil
+	}
+	return muxMemcache, nil
+}
+
+// peekLine peeks the first line of r including LF.
+func peekLine(r *bufio.Reader) ([]byte, error) {
+	for {
+		b, _ := r.Peek(r.Buffered())
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			return b[:i+1], nil
+		}
+		if _, err := r.Peek(len(b) + 1); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// bufferedConn is a connection read through r, which has bytes already peeked.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// muxListener is a listener accepting connections dispatched by a multiplexer.
+type muxListener struct {
+	addr   net.Addr
+	connCh chan net.Conn
+
+	once sync.Once
+	done chan struct{}
+}
+
+func newMuxListener(addr net.Addr) *muxListener {
+	return &muxListener{
+		addr:   addr,
+		connCh: make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+}
+
+func (l *muxListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *muxListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *muxListener) Addr() net.Addr {
+	return l.addr
+}
+
+func (l *muxListener) dispatch(conn net.Conn) {
+	select {
+	case l.connCh <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// multiplexer dispatches connections of HTTP and gRPC on listeners of the memcached protocol to the servers.
+type multiplexer struct {
+	http *muxListener
+	grpc *muxListener
+}
+
+// startMultiplexer starts HTTP and gRPC servers serving connections on the listener of addr.
+// Connections dispatched are already TLS handshaked by the listener, and counted by the limit of the protocol.
+// The returned channel is closed after the servers are shut down on ctx done.
+func (app *App) startMultiplexer(ctx context.Context, cfg *Config, addr net.Addr) <-chan struct{} {
+	m := &multiplexer{
+		http: newMuxListener(addr),
+		grpc: newMuxListener(addr),
+	}
+	hs := app.newHTTPServer(cfg)
+	gs := app.newGRPCServer(limitedCredentials{insecure.NewCredentials()})
+	go func() {
+		if err := hs.Serve(m.http); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warnf("Error on multiplexed HTTP server: %s", err)
+		}
+	}()
+	go func() {
+		if err := gs.Serve(m.grpc); err != nil {
+			log.Warnf("Error on multiplexed gRPC server: %s", err)
+		}
+	}()
+	done := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		log.Infof("Shutting down multiplexed HTTP and gRPC servers")
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			app.shutdownHTTPServer(hs, cfg.ShutdownTimeout)
+		}()
+		go func() {
+			defer wg.Done()
+			app.stopGRPCServer(gs, cfg.ShutdownTimeout)
+		}()
+		wg.Wait()
+		close(done)
+	}()
+	app.setListenAddr("http_addr", addr)
+	app.setListenAddr("grpc_addr", addr)
+	app.mux = m
+	return done
+}
+
+// dispatch sniffs the protocol of conn, and hands it over to the server of HTTP or gRPC.
+// It returns a connection of the memcached protocol, or nil when conn is handed over or closed.
+func (m *multiplexer) dispatch(ctx context.Context, app *App, conn net.Conn) net.Conn {
+	// unblock sniffing on shutdown
+	sniffed := make(chan struct{})
+	defer close(sniffed)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-app.drainCh:
+			conn.Close()
+		case <-sniffed:
+		}
+	}()
+
+	app.extendDeadline(conn)
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			log.Warnf("TLS handshake error from %s: %s", conn.RemoteAddr().String(), err)
+			conn.Close()
+			return nil
+		}
+	}
+	r := bufio.NewReader(conn)
+	p, err := sniffProtocol(r)
+	if err != nil {
+		if !errors.Is(err, io.EOF) && !strings.Contains(err.Error(), "i/o timeout") {
+			log.Warnf("error on sniffing protocol from %s: %s", conn.RemoteAddr().String(), err)
+		}
+		conn.Close()
+		return nil
+	}
+	limitConn(conn, app.connLimits[p])
+	bconn := &bufferedConn{Conn: conn, r: r}
+	switch p {
+	case muxHTTP:
+		conn.SetDeadline(time.Time{})
+		m.http.dispatch(bconn)
+		return nil
+	case muxGRPC:
+		conn.SetDeadline(time.Time{})
+		m.grpc.dispatch(bconn)
+		return nil
+	}
+	return bconn
+}
+
+// multiplexTLSConfig returns a TLS config negotiating HTTP/2 for gRPC clients on multiplexed listeners.
+func multiplexTLSConfig(c *tls.Config) *tls.Config {
+	c = c.Clone()
+	c.NextProtos = []string{"h2", "http/1.1"}
+	return c
+}
@@ -0,0 +1,190 @@
+package katsubushi
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kayac/go-katsubushi/v2/grpc"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestSniffProtocol(t *testing.T) {
+	tests := []struct {
+		data   string
+		expect int
+	}{
+		{"GET id\r\n", muxMemcache},
+		{"gets id\r\n", muxMemcache},
+		{"\x80\x00\x00\x02", muxMemcache},
+		{"GET /id HTTP/1.1\r\nHost: localhost\r\n\r\n", muxHTTP},
+		{"POST /worker/lease HTTP/1.0\r\n\r\n", muxHTTP},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", muxGRPC},
+		{"GET " + strings.Repeat("x", 5000) + "\r\n", muxMemcache},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(strings.NewReader(tt.data))
+		p, err := sniffProtocol(r)
+		if err != nil {
+			t.Errorf("failed to sniff %q: %s", tt.data, err)
+			continue
+		}
+		if p != tt.expect {
+			t.Errorf("unexpected protocol of %q: %d", tt.data, p)
+		}
+		// nothing is consumed
+		if b, _ := io.ReadAll(r); string(b) != tt.data {
+			t.Errorf("data must not be consumed: %q", b)
+		}
+	}
+}
+
+func newTestAppMultiplex(ctx context.Context, t *testing.T, tlsConfig *tls.Config) (*App, string) {
+	app := newTestApp(t, nil)
+	go app.RunServer(ctx, &Config{
+		Listens:   []ListenAddr{{Network: "tcp", Address: "localhost:0"}},
+		Multiplex: true,
+		TLSConfig: tlsConfig,
+	})
+	<-app.Ready()
+	return app, app.Listener.Addr().String()
+}
+
+func TestMultiplex(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, addr := newTestAppMultiplex(ctx, t, nil)
+
+	// text
+	client, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+
+	// binary
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(newTestBRequest(opcodeGet, 1, "id"))
+	if res := readTestBResponse(t, conn); res.status != [2]byte{} {
+		t.Errorf("unexpected status: %x", res.status)
+	}
+
+	// HTTP
+	res, err := http.Get(fmt.Sprintf("http://%s/id", addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status: %d", res.StatusCode)
+	}
+
+	// gRPC
+	gconn, err := gogrpc.Dial(addr, gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gconn.Close()
+	ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel2()
+	if r, err := grpc.NewGeneratorClient(gconn).Fetch(ctx2, &grpc.FetchRequest{}, gogrpc.WaitForReady(true)); err != nil || r.Id == 0 {
+		t.Errorf("failed to fetch: %v %s", r, err)
+	}
+}
+
+func TestMultiplexConnectionLimits(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestApp(t, nil)
+	go app.RunServer(ctx, &Config{
+		Listens:                []ListenAddr{{Network: "tcp", Address: "localhost:0"}},
+		Multiplex:              true,
+		MaxMemcacheConnections: 1,
+		MaxHTTPConnections:     1,
+	})
+	<-app.Ready()
+	addr := app.Listener.Addr().String()
+
+	client, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+
+	// HTTP connections are counted by the limit of HTTP, not memcached
+	get := func() int {
+		c := &http.Client{Transport: &http.Transport{}}
+		res, err := c.Get(fmt.Sprintf("http://%s/id", addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		// keep the connection alive
+		return res.StatusCode
+	}
+	if code := get(); code != http.StatusOK {
+		t.Errorf("HTTP connection must be accepted: %d", code)
+	}
+	if code := get(); code != http.StatusServiceUnavailable {
+		t.Errorf("HTTP connection over the limit must be rejected: %d", code)
+	}
+	if n := app.GetStats().RejectedConnections; n != 1 {
+		t.Errorf("unexpected rejected_connections: %d", n)
+	}
+}
+
+func TestMultiplexTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, certFile, keyFile := newTestCertificate(t)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newTestAppMultiplex(ctx, t, serverConfig)
+
+	c := NewClient(addr)
+	c.SetTLSConfig(newTestClientTLSConfig(t, cert))
+	if id, err := c.Fetch(ctx); err != nil || id == 0 {
+		t.Fatalf("failed to fetch over TLS: %d %s", id, err)
+	}
+
+	hc, err := NewHTTPClient([]string{"https://" + addr}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hc.SetTLSConfig(newTestClientTLSConfig(t, cert))
+	if id, err := hc.Fetch(ctx); err != nil || id == 0 {
+		t.Fatalf("failed to fetch by HTTP over TLS: %d %s", id, err)
+	}
+
+	gconn, err := gogrpc.Dial(addr, gogrpc.WithTransportCredentials(credentials.NewTLS(newTestClientTLSConfig(t, cert))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gconn.Close()
+	ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel2()
+	if _, err := grpc.NewGeneratorClient(gconn).Fetch(ctx2, &grpc.FetchRequest{}, gogrpc.WaitForReady(true)); err != nil {
+		t.Errorf("failed to fetch by gRPC over TLS: %s", err)
+	}
+}
@@ -0,0 +1,270 @@
+package katsubushi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is the default timeout to read a PROXY protocol header.
+var DefaultProxyHeaderTimeout = 5 * time.Second
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyV1MaxLength is the maximum length of a PROXY protocol v1 header including CRLF.
+const proxyV1MaxLength = 107
+
+// errors of PROXY protocol
+var (
+	ErrProxyHeaderRequired = errors.New("PROXY protocol header required")
+	ErrInvalidProxyHeader  = errors.New("invalid PROXY protocol header")
+)
+
+// ProxyProtocol accepts PROXY protocol v1 and v2 headers sent by load balancers,
+// to use the addresses of the original clients as remote addresses of connections.
+type ProxyProtocol struct {
+	// TrustedNetworks is networks of proxies allowed to send headers. Empty means no sources with IP addresses are trusted,
+	// not to let clients forge their addresses. Headers from untrusted sources are not parsed.
+	TrustedNetworks []*net.IPNet
+
+	// Required rejects connections without headers and connections from untrusted sources.
+	Required bool
+
+	// HeaderTimeout is a timeout to read a header.
+	HeaderTimeout time.Duration
+}
+
+// NewProxyProtocol creates ProxyProtocol trusting networks, which are CIDRs or IP addresses.
+func NewProxyProtocol(networks []string, required bool) (*ProxyProtocol, error) {
+	p := &ProxyProtocol{
+		Required:      required,
+		HeaderTimeout: DefaultProxyHeaderTimeout,
+	}
+	for _, s := range networks {
+		n, err := parseNetwork(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted network: %w", err)
+		}
+		p.TrustedNetworks = append(p.TrustedNetworks, n)
+	}
+	return p, nil
+}
+
+// parseNetwork parses a CIDR or an IP address as a network.
+func parseNetwork(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, n, err := net.ParseCIDR(s)
+		return n, err
+	}
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", s)
+	}
+	bits := 8 * net.IPv6len
+	if ip.To4() != nil {
+		ip, bits = ip.To4(), 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// SetProxyProtocol makes all listeners accept PROXY protocol headers by p.
+func (app *App) SetProxyProtocol(p *ProxyProtocol) {
+	app.proxyProtocol = p
+}
+
+// trusted reports whether headers from addr are accepted. Sources without IP addresses, as Unix domain sockets, are trusted.
+func (p *ProxyProtocol) trusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return true
+	}
+	for _, n := range p.TrustedNetworks {
+		if n.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// mode returns a mode of p for stats.
+func (p *ProxyProtocol) mode() string {
+	switch {
+	case p == nil:
+		return "no"
+	case p.Required:
+		return "required"
+	}
+	return "optional"
+}
+
+// proxyConn is a connection from a proxy. The header is read lazily on the first Read or RemoteAddr,
+// not to block the accept loop.
+type proxyConn struct {
+	net.Conn
+	p *ProxyProtocol
+	r *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func newProxyConn(conn net.Conn, p *ProxyProtocol) *proxyConn {
+	return &proxyConn{
+		Conn: conn,
+		p:    p,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		c.remoteAddr = c.Conn.RemoteAddr()
+		if c.p.HeaderTimeout > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(c.p.HeaderTimeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		addr, err := readProxyHeader(c.r)
+		if err == nil && addr == nil && c.p.Required {
+			err = ErrProxyHeaderRequired
+		}
+		if err != nil {
+			log.Warnf("Rejected a connection from %s: %s", c.remoteAddr, err)
+			c.err = err
+			return
+		}
+		if addr != nil {
+			c.remoteAddr = addr
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the original client sent by the proxy.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	return c.remoteAddr
+}
+
+// hasPrefix reports whether r begins with prefix. It peeks byte by byte not to wait for bytes never sent.
+func hasPrefix(r *bufio.Reader, prefix []byte) (bool, error) {
+	for i := 1; i <= len(prefix); i++ {
+		b, err := r.Peek(i)
+		if err != nil {
+			return false, err
+		}
+		if b[i-1] != prefix[i-1] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readProxyHeader reads a PROXY protocol header from r, and returns the source address in it.
+// It returns nil without error when r has no header, or the header has no address as LOCAL command.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	if ok, err := hasPrefix(r, proxyV1Prefix); err != nil {
+		return nil, err
+	} else if ok {
+		return readProxyHeaderV1(r)
+	}
+	if ok, err := hasPrefix(r, proxyV2Signature); err != nil {
+		return nil, err
+	} else if ok {
+		return readProxyHeaderV2(r)
+	}
+	return nil, nil
+}
+
+// readProxyHeaderV1 reads "PROXY TCP4|TCP6 <src> <dst> <src port> <dst port>\r\n" or "PROXY UNKNOWN ...\r\n".
+func readProxyHeaderV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: too long", ErrInvalidProxyHeader)
+		}
+	}
+	if !bytes.HasSuffix(line, memdSep) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+	fields := strings.Split(string(line[:len(line)-memdSepLen]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyHeaderV2 reads a binary header of PROXY protocol v2.
+func readProxyHeaderV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, verCmd>>4)
+	}
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch verCmd & 0x0f {
+	case 0x00: // LOCAL
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidProxyHeader, verCmd&0x0f)
+	}
+
+	var ipLen int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		ipLen = net.IPv4len
+	case 0x2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC and AF_UNIX have no IP address
+		return nil, nil
+	}
+	// source address, destination address, source port and destination port
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("%w: too short addresses", ErrInvalidProxyHeader)
+	}
+	ip := make(net.IP, ipLen)
+	copy(ip, body[:ipLen])
+	port := binary.BigEndian.Uint16(body[2*ipLen:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
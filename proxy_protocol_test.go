@@ -0,0 +1,175 @@
+package katsubushi
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestProxyHeaderV2(cmd, family byte, addrs []byte) []byte {
+	b := append([]byte{}, proxyV2Signature...)
+	b = append(b, 0x20|cmd, family, 0, byte(len(addrs)))
+	return append(b, addrs...)
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	ipv4 := []byte{192, 0, 2, 1, 192, 0, 2, 2, 0x30, 0x39, 0x2b, 0xbc}
+	ipv6 := append(append(append([]byte{}, net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0x30, 0x39, 0x2b, 0xbc)
+	tests := []struct {
+		name   string
+		header []byte
+		addr   string
+		err    error
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.0.2.1 192.0.2.2 12345 11212\r\n"), "192.0.2.1:12345", nil},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::2 12345 11212\r\n"), "[2001:db8::1]:12345", nil},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", nil},
+		{"v1 invalid", []byte("PROXY TCP4 192.0.2.1\r\n"), "", ErrInvalidProxyHeader},
+		{"v1 too long", []byte("PROXY " + strings.Repeat("x", 200) + "\r\n"), "", ErrInvalidProxyHeader},
+		{"v2 ipv4", newTestProxyHeaderV2(0x1, 0x11, ipv4), "192.0.2.1:12345", nil},
+		{"v2 ipv6", newTestProxyHeaderV2(0x1, 0x21, ipv6), "[2001:db8::1]:12345", nil},
+		{"v2 ipv4 with TLV", newTestProxyHeaderV2(0x1, 0x11, append(ipv4, 0x04, 0x00, 0x01, 0xff)), "192.0.2.1:12345", nil},
+		{"v2 local", newTestProxyHeaderV2(0x0, 0x00, nil), "", nil},
+		{"v2 short", newTestProxyHeaderV2(0x1, 0x11, ipv4[:8]), "", ErrInvalidProxyHeader},
+		{"none", []byte("GET id\r\n"), "", nil},
+		{"none binary", []byte{0x80, 0x0a, 0x00, 0x00}, "", nil},
+	}
+	for _, tt := range tests {
+		r := bufio.NewReader(bytes.NewReader(append(tt.header, "GET id\r\n"...)))
+		addr, err := readProxyHeader(r)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if (addr == nil && tt.addr != "") || (addr != nil && addr.String() != tt.addr) {
+			t.Errorf("%s: unexpected address %v, expected %q", tt.name, addr, tt.addr)
+		}
+	}
+}
+
+func TestNewProxyProtocol(t *testing.T) {
+	p, err := NewProxyProtocol([]string{"192.0.2.0/24", "2001:db8::1", "127.0.0.1"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr, trusted := range map[string]bool{
+		"192.0.2.10:1234":     true,
+		"198.51.100.1:1234":   false,
+		"[2001:db8::1]:1234":  true,
+		"[2001:db8::2]:1234":  false,
+		"127.0.0.1:1234":      true,
+		"127.0.0.2:1234":      false,
+		"[::ffff:7f00:1]:123": true,
+	} {
+		a, _ := net.ResolveTCPAddr("tcp", addr)
+		if p.trusted(a) != trusted {
+			t.Errorf("trusted(%s) must be %v", addr, trusted)
+		}
+	}
+
+	// no sources are trusted by default
+	p, err = NewProxyProtocol(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, _ := net.ResolveTCPAddr("tcp", "127.0.0.1:1234"); p.trusted(a) {
+		t.Error("sources must not be trusted without trusted networks")
+	}
+	if _, err := NewProxyProtocol([]string{"example.com"}, false); err == nil {
+		t.Error("invalid network must fail")
+	}
+}
+
+func newTestAppProxyProtocol(ctx context.Context, t *testing.T, networks []string, required bool) string {
+	app := newTestApp(t, nil)
+	p, err := NewProxyProtocol(networks, required)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetProxyProtocol(p)
+	l, _ := app.ListenerTCP("localhost:0")
+	go app.Serve(ctx, l)
+	<-app.Ready()
+	return app.Listener.Addr().String()
+}
+
+func TestAppProxyProtocol(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addr := newTestAppProxyProtocol(ctx, t, []string{"127.0.0.1", "::1"}, false)
+
+	client, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 12345 11212\r\n"))
+	resp, err := client.Command("stats conns")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(resp), "STAT 1:addr 192.0.2.1:12345\r\n") {
+		t.Errorf("unexpected stats conns: %s", resp)
+	}
+
+	// the header is optional
+	client, err = newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Command("GET id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Errorf("unexpected response: %s", resp)
+	}
+}
+
+func TestAppProxyProtocolRequired(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addr := newTestAppProxyProtocol(ctx, t, []string{"127.0.0.1", "::1"}, true)
+
+	client, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.conn.Write(newTestProxyHeaderV2(0x1, 0x11, []byte{192, 0, 2, 1, 192, 0, 2, 2, 0x30, 0x39, 0x2b, 0xbc}))
+	resp, err := client.Command("GET id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Errorf("unexpected response: %s", resp)
+	}
+
+	// connections without headers are rejected
+	client, err = newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if resp, err := client.Command("GET id"); err == nil {
+		t.Errorf("connection without header must be closed: %s", resp)
+	}
+
+	// connections from untrusted sources are rejected
+	client, err = newTestClient(newTestAppProxyProtocol(ctx, t, []string{"192.0.2.0/24"}, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	client.conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 12345 11212\r\n"))
+	if resp, err := client.Command("GET id"); err == nil {
+		t.Errorf("connection from untrusted source must be closed: %s", resp)
+	}
+}
@@ -0,0 +1,167 @@
+package katsubushi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxRangeSize is the maximum number of IDs reserved at once as ranges.
+const MaxRangeSize = 100000
+
+// RangeKeyPrefix is the prefix of memcached keys to fetch ID ranges, as "range:<number_of_ids>".
+const RangeKeyPrefix = "range:"
+
+// IDRange is a block of Count contiguous IDs from Start.
+type IDRange struct {
+	Start uint64
+	Count int
+}
+
+// RangeGenerator is a Generator which reserves contiguous IDs at once.
+type RangeGenerator interface {
+	Generator
+	NextRanges(n int) ([]IDRange, error)
+}
+
+// NextRanges reserves n IDs as contiguous ranges. A range spans within a millisecond.
+func (g *generator) NextRanges(n int) ([]IDRange, error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	var ranges []IDRange
+	for n > 0 {
+		ts := g.timestamp()
+		// for rewind of server clock
+		if ts < g.lastTimestamp {
+			return nil, ErrClockRollbacked
+		}
+		var seq uint
+		if ts == g.lastTimestamp {
+			seq = g.sequence + 1
+			if seq > sequenceMask {
+				ts = g.waitUntilNextTick(ts)
+				seq = 0
+			}
+		}
+		count := sequenceMask + 1 - int(seq)
+		if n < count {
+			count = n
+		}
+		g.lastTimestamp = ts
+		g.sequence = seq + uint(count) - 1
+		ranges = append(ranges, IDRange{
+			Start: (ts << (WorkerIDBits + SequenceBits)) | (uint64(g.workerID) << SequenceBits) | uint64(seq),
+			Count: count,
+		})
+		n -= count
+	}
+	return ranges, nil
+}
+
+// nextRanges reserves n IDs by gen. IDs are generated one by one when gen is not a RangeGenerator.
+func nextRanges(gen Generator, n int) ([]IDRange, error) {
+	if rg, ok := gen.(RangeGenerator); ok {
+		return rg.NextRanges(n)
+	}
+	var ranges []IDRange
+	for i := 0; i < n; i++ {
+		id, err := gen.NextID()
+		if err != nil {
+			return nil, err
+		}
+		if l := len(ranges) - 1; l >= 0 && ranges[l].Start+uint64(ranges[l].Count) == id {
+			ranges[l].Count++
+		} else {
+			ranges = append(ranges, IDRange{Start: id, Count: 1})
+		}
+	}
+	return ranges, nil
+}
+
+func validateRangeSize(n int) error {
+	if n <= 0 || MaxRangeSize < n {
+		return invalidArgumentError(fmt.Sprintf("invalid number of IDs: %d, n should be between 1 and %d", n, MaxRangeSize))
+	}
+	return nil
+}
+
+// formatIDRanges formats ranges as "start:count,start:count,..."
+func formatIDRanges(ranges []IDRange) string {
+	s := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		s = append(s, strconv.FormatUint(r.Start, 10)+":"+strconv.Itoa(r.Count))
+	}
+	return strings.Join(s, ",")
+}
+
+func parseIDRanges(s string) ([]IDRange, error) {
+	var ranges []IDRange
+	for _, f := range strings.Split(s, ",") {
+		start, count, ok := strings.Cut(f, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid range: %s", f)
+		}
+		r, err := parseIDRange(start, count)
+		if err != nil {
+			return nil, err
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+func parseIDRange(start, count string) (IDRange, error) {
+	s, err := strconv.ParseUint(start, 10, 64)
+	if err != nil {
+		return IDRange{}, err
+	}
+	c, err := strconv.Atoi(count)
+	if err != nil {
+		return IDRange{}, err
+	}
+	if c <= 0 {
+		return IDRange{}, fmt.Errorf("invalid count of range: %d", c)
+	}
+	return IDRange{Start: s, Count: c}, nil
+}
+
+// IDRanges expands ID ranges fetched by clients lazily.
+type IDRanges struct {
+	ranges []IDRange
+	i      int
+	j      int
+}
+
+// NewIDRanges creates IDRanges to expand ranges.
+func NewIDRanges(ranges []IDRange) *IDRanges {
+	return &IDRanges{ranges: ranges}
+}
+
+// Ranges returns the ranges.
+func (r *IDRanges) Ranges() []IDRange {
+	return r.ranges
+}
+
+// Len returns the number of IDs not expanded yet.
+func (r *IDRanges) Len() int {
+	n := 0
+	for i := r.i; i < len(r.ranges); i++ {
+		n += r.ranges[i].Count
+	}
+	return n - r.j
+}
+
+// Next returns the next ID. It returns false when all IDs are expanded.
+func (r *IDRanges) Next() (uint64, bool) {
+	if r.i >= len(r.ranges) {
+		return 0, false
+	}
+	id := r.ranges[r.i].Start + uint64(r.j)
+	r.j++
+	if r.j >= r.ranges[r.i].Count {
+		r.i++
+		r.j = 0
+	}
+	return id, true
+}
@@ -0,0 +1,121 @@
+package katsubushi
+
+import (
+	"context"
+	"testing"
+)
+
+func TestGenerateRanges(t *testing.T) {
+	gen, err := NewGenerator(getNextWorkerID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := gen.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// over a sequence of a millisecond
+	n := sequenceMask*2 + 10
+	ranges, err := gen.(RangeGenerator).NextRanges(n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, r := range ranges {
+		if r.Start <= last {
+			t.Errorf("range %d must start after %d", r.Start, last)
+		}
+		first, wid, _ := Dump(r.Start)
+		end, _, _ := Dump(r.Start + uint64(r.Count-1))
+		if !first.Equal(end) {
+			t.Errorf("range %v must be within a millisecond", r)
+		}
+		if uint(wid) != gen.WorkerID() {
+			t.Errorf("unexpected worker id %d", wid)
+		}
+		last = r.Start + uint64(r.Count-1)
+		total += r.Count
+	}
+	if total != n {
+		t.Errorf("ranges must contain %d IDs: %d", n, total)
+	}
+	id, err := gen.NextID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id <= last {
+		t.Errorf("id %d must be larger than the last of ranges %d", id, last)
+	}
+}
+
+func TestNextRangesByNextID(t *testing.T) {
+	gen, err := newDelayedGenerator(getNextWorkerID(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ranges, err := nextRanges(gen, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, r := range ranges {
+		total += r.Count
+	}
+	if total != 10 {
+		t.Errorf("ranges must contain 10 IDs: %v", ranges)
+	}
+}
+
+func TestIDRanges(t *testing.T) {
+	ranges, err := parseIDRanges(formatIDRanges([]IDRange{{Start: 100, Count: 3}, {Start: 200, Count: 2}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewIDRanges(ranges)
+	if r.Len() != 5 {
+		t.Errorf("unexpected len %d", r.Len())
+	}
+	var ids []uint64
+	for id, ok := r.Next(); ok; id, ok = r.Next() {
+		ids = append(ids, id)
+	}
+	expected := []uint64{100, 101, 102, 200, 201}
+	if len(ids) != len(expected) {
+		t.Fatalf("unexpected ids %v", ids)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Errorf("unexpected ids %v", ids)
+		}
+	}
+	if r.Len() != 0 {
+		t.Errorf("unexpected len %d", r.Len())
+	}
+
+	for _, s := range []string{"", "100", "100:0", "x:1"} {
+		if _, err := parseIDRanges(s); err == nil {
+			t.Errorf("%q must be invalid", s)
+		}
+	}
+}
+
+func TestClientFetchRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	c := NewClient(app.Listener.Addr().String())
+
+	r, err := c.FetchRange(ctx, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 5000 {
+		t.Errorf("unexpected len %d", r.Len())
+	}
+	if len(r.Ranges()) < 2 {
+		t.Errorf("5000 IDs must span over milliseconds: %v", r.Ranges())
+	}
+	if _, err := c.FetchRange(ctx, MaxRangeSize+1); err == nil {
+		t.Error("too many IDs must not be fetched")
+	}
+}
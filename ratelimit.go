@@ -0,0 +1,385 @@
+package katsubushi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// keys of rate limits
+const (
+	RateLimitByAddr      = "addr"
+	RateLimitByUser      = "user"
+	RateLimitByNamespace = "namespace"
+)
+
+// rangeNamespace is the namespace of range keys of the memcached protocol.
+const rangeNamespace = "range"
+
+// maxRateLimitBuckets is the number of buckets to start evicting idle buckets.
+const maxRateLimitBuckets = 10000
+
+// ErrRateLimited is an error for requests over rate limits.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+type rateLimitError struct {
+	by  string
+	key string
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s for %s %s", ErrRateLimited, e.by, e.key)
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+// RateLimitRule limits IDs issued per second by a token bucket for each client identified by By.
+type RateLimitRule struct {
+	// By is a key to identify clients, addr, user or namespace.
+	By string `json:"by" yaml:"by"`
+
+	// Match selects clients to apply the rule. It is a CIDR or an IP address for addr, and a user or a namespace for others.
+	// Empty matches all clients.
+	Match string `json:"match" yaml:"match"`
+
+	// Rate is the number of IDs per second.
+	Rate float64 `json:"rate" yaml:"rate"`
+
+	// Burst is the capacity of a bucket. 0 means same as Rate.
+	Burst int `json:"burst" yaml:"burst"`
+
+	network *net.IPNet
+}
+
+func (r *RateLimitRule) validate() error {
+	switch r.By {
+	case RateLimitByAddr:
+		if r.Match == "" {
+			break
+		}
+		n, err := parseNetwork(r.Match)
+		if err != nil {
+			return err
+		}
+		r.network = n
+	case RateLimitByUser, RateLimitByNamespace:
+	default:
+		return fmt.Errorf("invalid by %q, must be addr, user or namespace", r.By)
+	}
+	if r.Rate <= 0 {
+		return fmt.Errorf("rate must be positive: %v", r.Rate)
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("burst must not be negative: %d", r.Burst)
+	}
+	if r.Burst == 0 {
+		r.Burst = int(r.Rate)
+		if r.Burst < 1 {
+			r.Burst = 1
+		}
+	}
+	return nil
+}
+
+func (r *RateLimitRule) matches(c rateLimitClient, key string) bool {
+	if r.network != nil {
+		return r.network.Contains(c.ip)
+	}
+	return r.Match == "" || r.Match == key
+}
+
+// rateLimitClient identifies a client of requests.
+type rateLimitClient struct {
+	ip        net.IP
+	user      string
+	namespace string
+}
+
+func newRateLimitClient(addr net.Addr, user, namespace string) rateLimitClient {
+	c := rateLimitClient{user: user, namespace: namespace}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		c.ip = tcpAddr.IP
+	}
+	return c
+}
+
+// keyOf returns a key of the client by by. It returns false when the client has no key, as a user of anonymous clients.
+func (c rateLimitClient) keyOf(by string) (string, bool) {
+	switch by {
+	case RateLimitByAddr:
+		if c.ip == nil {
+			return "", false
+		}
+		return c.ip.String(), true
+	case RateLimitByUser:
+		return c.user, c.user != ""
+	case RateLimitByNamespace:
+		return c.namespace, c.namespace != ""
+	}
+	return "", false
+}
+
+// rateLimitRequest is a request of n IDs by the client.
+type rateLimitRequest struct {
+	client rateLimitClient
+	n      int
+}
+
+type rateLimitBucketKey struct {
+	rule int
+	key  string
+}
+
+type tokenBucket struct {
+	rule   *RateLimitRule
+	key    string
+	tokens float64
+	last   time.Time
+
+	allowed   int64
+	throttled int64
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rule.Rate
+	if burst := float64(b.rule.Burst); b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+// RateLimiter limits IDs issued to clients by rules.
+type RateLimiter struct {
+	rules []*RateLimitRule
+
+	mu      sync.Mutex
+	buckets map[rateLimitBucketKey]*tokenBucket
+}
+
+// NewRateLimiter creates RateLimiter. A request must satisfy all rules applied to the client.
+func NewRateLimiter(rules []RateLimitRule) (*RateLimiter, error) {
+	l := &RateLimiter{buckets: map[rateLimitBucketKey]*tokenBucket{}}
+	for i := range rules {
+		r := rules[i]
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("invalid rate limit rule #%d: %w", i, err)
+		}
+		l.rules = append(l.rules, &r)
+	}
+	return l, nil
+}
+
+// LoadRateLimiter loads rules of RateLimiter from a JSON or YAML (.yml, .yaml) file.
+//
+//	limits:
+//	  - by: addr
+//	    match: 10.0.1.0/24
+//	    rate: 1000
+//	    burst: 2000
+//	  - by: namespace
+//	    rate: 10000
+func LoadRateLimiter(path string) (*RateLimiter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var conf struct {
+		Limits []RateLimitRule `json:"limits" yaml:"limits"`
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(b, &conf)
+	default:
+		err = json.Unmarshal(b, &conf)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(conf.Limits) == 0 {
+		return nil, fmt.Errorf("no limits in %s", path)
+	}
+	return NewRateLimiter(conf.Limits)
+}
+
+// SetRateLimiter limits IDs issued to clients by l.
+func (app *App) SetRateLimiter(l *RateLimiter) {
+	app.rateLimiter = l
+}
+
+// allow takes tokens of all requests from the buckets, or nothing when any bucket is short.
+func (l *RateLimiter) allow(reqs ...rateLimitRequest) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	var buckets []*tokenBucket
+	need := map[*tokenBucket]float64{}
+	for _, req := range reqs {
+		for i, r := range l.rules {
+			key, ok := req.client.keyOf(r.By)
+			if !ok || !r.matches(req.client, key) {
+				continue
+			}
+			b := l.bucket(i, key, now)
+			if _, exists := need[b]; !exists {
+				buckets = append(buckets, b)
+			}
+			need[b] += float64(req.n)
+		}
+	}
+	for _, b := range buckets {
+		if burst := b.rule.Burst; need[b] > float64(burst) {
+			// never satisfied however long the client waits
+			b.throttled += int64(need[b])
+			return invalidArgumentError(fmt.Sprintf(
+				"%d IDs requested over the burst %d of rate limit for %s %s", int64(need[b]), burst, b.rule.By, b.key))
+		}
+		if b.tokens < need[b] {
+			b.throttled += int64(need[b])
+			return &rateLimitError{by: b.rule.By, key: b.key}
+		}
+	}
+	for _, b := range buckets {
+		b.tokens -= need[b]
+		b.allowed += int64(need[b])
+	}
+	return nil
+}
+
+// bucket returns a refilled bucket of the rule for the key.
+func (l *RateLimiter) bucket(rule int, key string, now time.Time) *tokenBucket {
+	k := rateLimitBucketKey{rule: rule, key: key}
+	b, ok := l.buckets[k]
+	if ok {
+		b.refill(now)
+		return b
+	}
+	if len(l.buckets) >= maxRateLimitBuckets {
+		l.evict(now)
+	}
+	r := l.rules[rule]
+	b = &tokenBucket{rule: r, key: key, tokens: float64(r.Burst), last: now}
+	l.buckets[k] = b
+	return b
+}
+
+// evict removes full buckets, which are same as new ones except for stats.
+func (l *RateLimiter) evict(now time.Time) {
+	for k, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.rule.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// stats returns "<rule>:<by>:<key>:allowed" and "<rule>:<by>:<key>:throttled" of each bucket.
+func (l *RateLimiter) stats() memdStatList {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	keys := make([]rateLimitBucketKey, 0, len(l.buckets))
+	for k := range l.buckets {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].rule != keys[j].rule {
+			return keys[i].rule < keys[j].rule
+		}
+		return keys[i].key < keys[j].key
+	})
+	var s memdStatList
+	for _, k := range keys {
+		b := l.buckets[k]
+		prefix := strconv.Itoa(k.rule) + ":" + b.rule.By + ":" + k.key + ":"
+		s = s.add(prefix+"allowed", b.allowed)
+		s = s.add(prefix+"throttled", b.throttled)
+	}
+	return s
+}
+
+// resetStats resets the numbers of allowed and throttled IDs of buckets.
+func (l *RateLimiter) resetStats() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, b := range l.buckets {
+		b.allowed = 0
+		b.throttled = 0
+	}
+}
+
+// namespaceOf returns a namespace of a key of the memcached protocol.
+func namespaceOf(key string) string {
+	if strings.HasPrefix(key, RangeKeyPrefix) {
+		return rangeNamespace
+	}
+	return key
+}
+
+// numIDsOf returns the number of IDs requested by a key of the memcached protocol.
+func numIDsOf(key string) int {
+	if !strings.HasPrefix(key, RangeKeyPrefix) {
+		return 1
+	}
+	// invalid range keys are rejected later
+	n, err := strconv.Atoi(strings.TrimPrefix(key, RangeKeyPrefix))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// limitRate applies rate limits to cmd of the memcached protocol by the client.
+func (app *App) limitRate(addr net.Addr, user string, cmd MemdCmd) error {
+	if app.rateLimiter == nil {
+		return nil
+	}
+	var keys []string
+	switch c := cmd.(type) {
+	case *MemdCmdGet:
+		keys = c.Keys
+	case *MemdCmdMetaGet:
+		key, _ := metaFlags(c.Flags).metaKey(c.Key)
+		keys = []string{key}
+	case *MemdBCmdGet:
+		keys = []string{c.Key}
+	case *MemdCmdWorker:
+		// a request of leases counts as a request of one ID, same as HTTP and gRPC
+		return app.rateLimiter.allow(rateLimitRequest{
+			client: newRateLimitClient(addr, user, ""),
+			n:      1,
+		})
+	default:
+		return nil
+	}
+	reqs := make([]rateLimitRequest, 0, len(keys))
+	for _, key := range keys {
+		reqs = append(reqs, rateLimitRequest{
+			client: newRateLimitClient(addr, user, namespaceOf(key)),
+			n:      numIDsOf(key),
+		})
+	}
+	return app.rateLimiter.allow(reqs...)
+}
+
+// limitRateOf applies rate limits to n IDs requested by a client of addr for HTTP and gRPC.
+func (app *App) limitRateOf(addr net.Addr, namespace string, n int) error {
+	if app.rateLimiter == nil {
+		return nil
+	}
+	return app.rateLimiter.allow(rateLimitRequest{
+		client: newRateLimitClient(addr, "", namespace),
+		n:      n,
+	})
+}
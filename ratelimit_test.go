@@ -0,0 +1,274 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/mc"
+	"github.com/kayac/go-katsubushi/v2/grpc"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newTestRateLimiter(t *testing.T, rules ...RateLimitRule) *RateLimiter {
+	l, err := NewRateLimiter(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newTestRateLimiter(t,
+		RateLimitRule{By: RateLimitByAddr, Match: "192.0.2.0/24", Rate: 0.001, Burst: 3},
+		RateLimitRule{By: RateLimitByNamespace, Match: "orders", Rate: 0.001, Burst: 5},
+		RateLimitRule{By: RateLimitByUser, Rate: 0.001, Burst: 2},
+	)
+	client := func(addr, user, namespace string) rateLimitClient {
+		a, _ := net.ResolveTCPAddr("tcp", addr)
+		return newRateLimitClient(a, user, namespace)
+	}
+
+	if err := l.allow(rateLimitRequest{client("192.0.2.1:1234", "", ""), 3}); err != nil {
+		t.Fatal(err)
+	}
+	err := l.allow(rateLimitRequest{client("192.0.2.1:1234", "", ""), 1})
+	if !errors.Is(err, ErrRateLimited) || err.Error() != "rate limit exceeded for addr 192.0.2.1" {
+		t.Errorf("unexpected error: %v", err)
+	}
+	// buckets are separated by addresses
+	if err := l.allow(rateLimitRequest{client("192.0.2.2:1234", "", ""), 3}); err != nil {
+		t.Error(err)
+	}
+	// not matched
+	if err := l.allow(rateLimitRequest{client("198.51.100.1:1234", "", "foo"), 100}); err != nil {
+		t.Error(err)
+	}
+
+	// a request must satisfy all rules, and tokens are not taken on failure
+	if err := l.allow(rateLimitRequest{client("198.51.100.1:1234", "bob", "orders"), 3}); err == nil {
+		t.Error("request over the limit of the user must fail")
+	}
+	if err := l.allow(rateLimitRequest{client("198.51.100.1:1234", "", "orders"), 5}); err != nil {
+		t.Errorf("tokens of the namespace must be kept: %s", err)
+	}
+	// a request over the burst never succeeds
+	err = l.allow(rateLimitRequest{client("198.51.100.1:1234", "", "orders"), 6})
+	if !isInvalidArgument(err) || err.Error() != "6 IDs requested over the burst 5 of rate limit for namespace orders" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	stats := l.stats()
+	for _, s := range []memdStat{
+		{"0:addr:192.0.2.1:allowed", "3"},
+		{"0:addr:192.0.2.1:throttled", "1"},
+		{"1:namespace:orders:allowed", "5"},
+		{"2:user:bob:throttled", "3"},
+	} {
+		found := false
+		for _, st := range stats {
+			found = found || st == s
+		}
+		if !found {
+			t.Errorf("stats must contain %v: %v", s, stats)
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	l := newTestRateLimiter(t, RateLimitRule{By: RateLimitByNamespace, Rate: 100})
+	c := rateLimitClient{namespace: "id"}
+	if err := l.allow(rateLimitRequest{c, 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.allow(rateLimitRequest{c, 10}); err == nil {
+		t.Fatal("bucket must be empty")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := l.allow(rateLimitRequest{c, 10}); err != nil {
+		t.Errorf("bucket must be refilled: %s", err)
+	}
+}
+
+func TestLoadRateLimiter(t *testing.T) {
+	l, err := LoadRateLimiter(writeTestFile(t, "limits.yaml", `
+limits:
+  - by: addr
+    match: 10.0.1.1
+    rate: 1000
+    burst: 2000
+  - by: namespace
+    rate: 0.5
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.rules) != 2 || l.rules[0].network.String() != "10.0.1.1/32" || l.rules[1].Burst != 1 {
+		t.Errorf("unexpected rules: %#v %#v", l.rules[0], l.rules[1])
+	}
+	if _, err := LoadRateLimiter(writeTestFile(t, "limits.json", `{"limits":[{"by":"user","rate":10}]}`)); err != nil {
+		t.Error(err)
+	}
+
+	for _, content := range []string{
+		`{"limits":[]}`,
+		`{"limits":[{"by":"host","rate":10}]}`,
+		`{"limits":[{"by":"addr","match":"example.com","rate":10}]}`,
+		`{"limits":[{"by":"user","rate":0}]}`,
+	} {
+		if _, err := LoadRateLimiter(writeTestFile(t, "limits.json", content)); err == nil {
+			t.Errorf("%s must be invalid", content)
+		}
+	}
+}
+
+func TestAppRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByAddr, Rate: 0.001, Burst: 3}))
+
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cmd    string
+		expect string
+	}{
+		{"GET id id", "VALUE id"},
+		{"GET id id", "SERVER_ERROR rate limit exceeded for addr 127.0.0.1\r\n"},
+		{"mg id v", "VA "},
+		{"mg id v", "SERVER_ERROR rate limit exceeded"},
+		{"WORKER LEASE", "SERVER_ERROR rate limit exceeded"},
+		{"GET range:4", "CLIENT_ERROR 4 IDs requested over the burst 3 of rate limit for addr 127.0.0.1\r\n"},
+		{"stats ratelimits", "STAT 0:addr:127.0.0.1:allowed 3\r\nSTAT 0:addr:127.0.0.1:throttled 8\r\nEND\r\n"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Command(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(resp), tt.expect) {
+			t.Errorf("unexpected response of %s: %q", tt.cmd, resp)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(newTestBRequest(opcodeGet, 1, "id"))
+	res := readTestBResponse(t, conn)
+	if res.status != statusBusy || res.value != "rate limit exceeded for addr 127.0.0.1" {
+		t.Errorf("unexpected response: %x %s", res.status, res.value)
+	}
+}
+
+func TestAppRateLimitByUser(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppWithSASL(ctx, t, "")
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByUser, Match: "alice", Rate: 0.001, Burst: 1}))
+
+	cn, err := mc.Dial("tcp", app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cn.Auth("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := cn.Get("id"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := cn.Get("id"); err == nil {
+		t.Error("request over the limit must fail")
+	}
+	stats, err := app.statsOf("ratelimits")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 || stats[1] != (memdStat{"0:user:alice:throttled", "1"}) {
+		t.Errorf("unexpected stats: %v", stats)
+	}
+}
+
+func TestHTTPRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestApp(t, nil)
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByNamespace, Match: "orders", Rate: 0.001, Burst: 10}))
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunHTTPServer(ctx, &Config{HTTPListener: l})
+
+	get := func(path string) int {
+		var res *http.Response
+		waitFor(t, func() bool {
+			res, err = http.Get(fmt.Sprintf("http://%s%s", l.Addr(), path))
+			return err == nil
+		}, "failed to request")
+		res.Body.Close()
+		return res.StatusCode
+	}
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/range?n=11&ns=orders", http.StatusBadRequest},
+		{"/ids?n=10&ns=orders", http.StatusOK},
+		{"/id?ns=orders", http.StatusTooManyRequests},
+		{"/range?n=1&ns=orders", http.StatusTooManyRequests},
+		{"/ids?n=10", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if c := get(tt.path); c != tt.code {
+			t.Errorf("unexpected status of %s: %d", tt.path, c)
+		}
+	}
+}
+
+func TestGRPCRateLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestApp(t, nil)
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByNamespace, Rate: 0.001, Burst: 10}))
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunGRPCServer(ctx, &Config{GRPCListener: l})
+
+	conn, err := gogrpc.Dial(l.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := grpc.NewGeneratorClient(conn)
+	ctx2, cancel2 := context.WithTimeout(metadata.AppendToOutgoingContext(ctx, "namespace", "orders"), 5*time.Second)
+	defer cancel2()
+	if _, err := client.FetchMulti(ctx2, &grpc.FetchMultiRequest{N: 10}, gogrpc.WaitForReady(true)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Fetch(ctx2, &grpc.FetchRequest{}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := client.FetchRange(ctx2, &grpc.FetchRangeRequest{N: 1}); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := client.FetchRange(ctx2, &grpc.FetchRangeRequest{N: 11}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("request over the burst must be invalid: %v", err)
+	}
+}
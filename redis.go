@@ -0,0 +1,223 @@
+package katsubushi
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fujiwara/raus"
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultRedisNamespace is the default namespace of keys in Redis.
+const DefaultRedisNamespace = raus.DefaultNamespace
+
+const (
+	redisDefaultPort    = "6379"
+	sentinelDefaultPort = "26379"
+)
+
+// RedisOptions represents options to connect to Redis.
+type RedisOptions struct {
+	Cluster  bool
+	Addrs    []string
+	Username string
+	Password string
+	DB       int
+
+	// MasterName is a name of the master monitored by Sentinel.
+	// When it is set, Addrs are addresses of Sentinel.
+	MasterName       string
+	SentinelUsername string
+	SentinelPassword string
+
+	TLSConfig *tls.Config
+
+	Namespace string
+}
+
+// ParseRedisURL parses URL for Redis.
+//
+//	redis://[{user}:{password}@]{host}:{port}/{db}?ns={namespace}
+//	rediscluster://[{user}:{password}@]{host}:{port}[,{host}:{port}...]?ns={namespace}
+//	redis-sentinel://[{user}:{password}@]{host}:{port}[,{host}:{port}...]/{db}?master={name}&ns={namespace}
+//
+// rediss, redisclusters and rediss-sentinel schemes connect with TLS.
+func ParseRedisURL(s string) (*RedisOptions, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	op := &RedisOptions{}
+	var useTLS, sentinel bool
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		useTLS = true
+	case "rediscluster":
+		op.Cluster = true
+	case "redisclusters":
+		op.Cluster, useTLS = true, true
+	case "redis-sentinel":
+		sentinel = true
+	case "rediss-sentinel":
+		sentinel, useTLS = true, true
+	default:
+		return nil, fmt.Errorf("invalid scheme %q: must be one of redis, rediss, rediscluster, redisclusters, redis-sentinel or rediss-sentinel", u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("host is required")
+	}
+	hosts := strings.Split(u.Host, ",")
+	if len(hosts) > 1 && !op.Cluster && !sentinel {
+		return nil, fmt.Errorf("multiple hosts are supported only for rediscluster and redis-sentinel")
+	}
+	defaultPort := redisDefaultPort
+	if sentinel {
+		defaultPort = sentinelDefaultPort
+	}
+	for _, h := range hosts {
+		addr, err := redisAddr(h, defaultPort)
+		if err != nil {
+			return nil, err
+		}
+		op.Addrs = append(op.Addrs, addr)
+	}
+
+	if u.User != nil {
+		op.Username = u.User.Username()
+		op.Password, _ = u.User.Password()
+	}
+
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		if op.Cluster {
+			return nil, fmt.Errorf("database is not supported for redis cluster")
+		}
+		op.DB, err = strconv.Atoi(p)
+		if err != nil || op.DB < 0 {
+			return nil, fmt.Errorf("invalid database %q", p)
+		}
+	}
+
+	op.Namespace = DefaultRedisNamespace
+	var tlsCA string
+	var tlsSkipVerify bool
+	for key, values := range u.Query() {
+		v := values[0]
+		switch key {
+		case "ns":
+			if v == "" {
+				return nil, fmt.Errorf("ns must not be empty")
+			}
+			op.Namespace = v
+		case "master":
+			op.MasterName = v
+		case "sentinel_username":
+			op.SentinelUsername = v
+		case "sentinel_password":
+			op.SentinelPassword = v
+		case "tls_ca":
+			tlsCA = v
+		case "tls_insecure_skip_verify":
+			tlsSkipVerify, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tls_insecure_skip_verify %q", v)
+			}
+		default:
+			return nil, fmt.Errorf("unknown parameter %q", key)
+		}
+	}
+
+	if sentinel {
+		if op.MasterName == "" {
+			return nil, fmt.Errorf("master is required for %s", u.Scheme)
+		}
+	} else if op.MasterName != "" || op.SentinelUsername != "" || op.SentinelPassword != "" {
+		return nil, fmt.Errorf("master, sentinel_username and sentinel_password are supported only for redis-sentinel")
+	}
+
+	if useTLS {
+		op.TLSConfig = &tls.Config{
+			InsecureSkipVerify: tlsSkipVerify,
+		}
+		if tlsCA != "" {
+			pool, err := loadCertPool(tlsCA)
+			if err != nil {
+				return nil, err
+			}
+			op.TLSConfig.RootCAs = pool
+		}
+	} else if tlsCA != "" || tlsSkipVerify {
+		return nil, fmt.Errorf("tls_ca and tls_insecure_skip_verify are supported only for TLS schemes")
+	}
+	return op, nil
+}
+
+func redisAddr(host, defaultPort string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("empty host")
+	}
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		// no port
+		return net.JoinHostPort(strings.Trim(host, "[]"), defaultPort), nil
+	}
+	if h == "" {
+		return "", fmt.Errorf("empty host in %q", host)
+	}
+	if n, err := strconv.Atoi(p); err != nil || n <= 0 || n > 65535 {
+		return "", fmt.Errorf("invalid port in %q", host)
+	}
+	return host, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+// NewClient creates a new Redis client.
+func (o *RedisOptions) NewClient() redis.UniversalClient {
+	switch {
+	case o.MasterName != "":
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       o.MasterName,
+			SentinelAddrs:    o.Addrs,
+			SentinelUsername: o.SentinelUsername,
+			SentinelPassword: o.SentinelPassword,
+			Username:         o.Username,
+			Password:         o.Password,
+			DB:               o.DB,
+			TLSConfig:        o.TLSConfig,
+		})
+	case o.Cluster:
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     o.Addrs,
+			Username:  o.Username,
+			Password:  o.Password,
+			TLSConfig: o.TLSConfig,
+		})
+	default:
+		return redis.NewClient(&redis.Options{
+			Network:   "tcp",
+			Addr:      o.Addrs[0],
+			Username:  o.Username,
+			Password:  o.Password,
+			DB:        o.DB,
+			TLSConfig: o.TLSConfig,
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+// ErrLeaseNotFound means that no lease of the worker ID is recorded at the time.
+var ErrLeaseNotFound = errors.New("lease not found")
+
+// Lease is a record of a worker ID held by a process.
+// For a worker ID leased to a client by WorkerLeaser, Hostname is the address of the client,
+// and PID and Version are empty.
+type Lease struct {
+	WorkerID uint       `json:"worker_id"`
+	Hostname string     `json:"hostname"`
+	PID      int        `json:"pid,omitempty"`
+	Version  string     `json:"version,omitempty"`
+	Start    time.Time  `json:"start"`
+	End      *time.Time `json:"end,omitempty"` // nil while holding, or when the process crashed
+}
+
+// WorkerIDRegistry is a backend which records the history of leases of worker IDs.
+type WorkerIDRegistry interface {
+	// LeaseHistory returns leases of workerID in order of Start.
+	LeaseHistory(ctx context.Context, workerID uint) ([]Lease, error)
+}
+
+type lesseeKey struct{}
+
+// withLessee returns ctx to record the client of addr as the holder of worker IDs registered by ctx,
+// instead of this process.
+func withLessee(ctx context.Context, addr net.Addr) context.Context {
+	var host string
+	if addr != nil {
+		host = addr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	return context.WithValue(ctx, lesseeKey{}, host)
+}
+
+// newLease returns a lease of id started now by this process, or by the lessee of ctx.
+func newLease(ctx context.Context, id uint) Lease {
+	l := Lease{
+		WorkerID: id,
+		Start:    now().Truncate(time.Millisecond), // same precision as IDs
+	}
+	if host, ok := ctx.Value(lesseeKey{}).(string); ok {
+		l.Hostname = host
+		return l
+	}
+	l.Hostname, _ = os.Hostname()
+	l.PID = os.Getpid()
+	l.Version = Version
+	return l
+}
+
+// LookupLease returns the lease of workerID at t, that is the latest lease started before t.
+// It returns ErrLeaseNotFound when the lease was already ended at t.
+func LookupLease(ctx context.Context, r WorkerIDRegistry, workerID uint, t time.Time) (*Lease, error) {
+	leases, err := r.LeaseHistory(ctx, workerID)
+	if err != nil {
+		return nil, err
+	}
+	return findLease(leases, t)
+}
+
+func sortLeases(leases []Lease) {
+	sort.SliceStable(leases, func(i, j int) bool {
+		return leases[i].Start.Before(leases[j].Start)
+	})
+}
+
+func findLease(leases []Lease, t time.Time) (*Lease, error) {
+	sortLeases(leases)
+	// the first lease which started after t
+	i := sort.Search(len(leases), func(i int) bool {
+		return leases[i].Start.After(t)
+	})
+	if i == 0 {
+		return nil, ErrLeaseNotFound
+	}
+	l := leases[i-1]
+	if l.End != nil && l.End.Before(t) {
+		return nil, ErrLeaseNotFound
+	}
+	return &l, nil
+}
@@ -0,0 +1,131 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFindLease(t *testing.T) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	end := base.Add(time.Hour)
+	leases := []Lease{
+		{Hostname: "host-c", Start: base.Add(2 * time.Hour)},
+		{Hostname: "host-a", Start: base, End: &end},
+		{Hostname: "host-b", Start: base.Add(90 * time.Minute)},
+	}
+	cases := []struct {
+		at       time.Time
+		hostname string
+	}{
+		{base.Add(-time.Second), ""},
+		{base, "host-a"},
+		{base.Add(30 * time.Minute), "host-a"},
+		{base.Add(80 * time.Minute), ""},
+		{base.Add(100 * time.Minute), "host-b"},
+		{base.Add(24 * time.Hour), "host-c"},
+	}
+	for _, c := range cases {
+		l, err := findLease(leases, c.at)
+		if c.hostname == "" {
+			if !errors.Is(err, ErrLeaseNotFound) {
+				t.Errorf("lease at %s must not be found: %v", c.at, l)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lease at %s must be found: %s", c.at, err)
+			continue
+		}
+		if l.Hostname != c.hostname {
+			t.Errorf("unexpected lease at %s: %s", c.at, l.Hostname)
+		}
+	}
+}
+
+func testLeaseHistory(t *testing.T, r WorkerIDRegistry, alloc WorkerIDAllocator) {
+	ctx, cancel := context.WithCancel(context.Background())
+	id, ch, err := alloc.Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuedAt := now()
+	time.Sleep(10 * time.Millisecond)
+
+	l, err := LookupLease(context.Background(), r, id, issuedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, _ := os.Hostname()
+	if l.WorkerID != id || l.Hostname != hostname || l.PID != os.Getpid() || l.Version != Version {
+		t.Errorf("unexpected lease %#v", l)
+	}
+	if l.End != nil {
+		t.Errorf("holding lease must not have end %s", l.End)
+	}
+
+	cancel()
+	for range ch {
+	}
+	leases, err := r.LeaseHistory(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(leases) != 1 {
+		t.Fatalf("unexpected history %#v", leases)
+	}
+	if leases[0].End == nil || leases[0].End.Before(issuedAt) {
+		t.Errorf("released lease must have end: %v", leases[0].End)
+	}
+	if _, err := LookupLease(context.Background(), r, id, now().Add(time.Second)); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("lease after release must not be found: %v", err)
+	}
+}
+
+func TestRedisAllocatorLeaseHistory(t *testing.T) {
+	a := newTestRedisAllocator(t, 1, 1)
+	testLeaseHistory(t, a, a)
+}
+
+func TestSQLAllocatorLeaseHistory(t *testing.T) {
+	a := newTestSQLAllocator(t, openTestSQLite(t), 1, 1)
+	testLeaseHistory(t, a, a)
+}
+
+func TestRaftAllocatorLeaseHistory(t *testing.T) {
+	nodes := newTestRaftCluster(t, 3, 1, 1)
+	// looked up by a member which did not allocate it
+	testLeaseHistory(t, &RaftRegistry{
+		Peers:  []string{"127.0.0.1:1", nodes[1].Addr()},
+		Secret: []byte("secret"),
+	}, nodes[0])
+
+	if _, err := (&RaftRegistry{Peers: []string{nodes[1].Addr()}, Secret: []byte("wrong")}).LeaseHistory(context.Background(), 1); err == nil {
+		t.Error("history must not be served without the secret")
+	}
+}
+
+func TestWorkerLeaserLeaseHistory(t *testing.T) {
+	r := newTestSQLAllocator(t, openTestSQLite(t), 1, 10)
+	l, err := NewWorkerLeaser(1000, 1000, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Registerer = r
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 12345}
+	wl, err := l.Lease(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease, err := LookupLease(context.Background(), r, wl.WorkerID, now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// attributed to the client, not to this server
+	if lease.Hostname != "192.0.2.1" || lease.PID != 0 || lease.Version != "" {
+		t.Errorf("unexpected lease %#v", lease)
+	}
+}
@@ -0,0 +1,155 @@
+package katsubushi
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	opcodeSASLListMechs = 0x20
+	opcodeSASLAuth      = 0x21
+	opcodeSASLStep      = 0x22
+)
+
+// statusAuthError is a response status of binary protocol for authentication errors.
+var statusAuthError = [2]byte{0x00, 0x20}
+
+// SASLMechanisms is a list of supported SASL mechanisms.
+const SASLMechanisms = "PLAIN"
+
+// errors of authentication
+var (
+	ErrAuthRequired = errors.New("authentication required")
+	ErrAuthFailure  = errors.New("authentication failure")
+)
+
+var respAuthenticated = []byte("OK\r\n")
+
+// SASLAuth authenticates connections by SASL PLAIN on binary protocol.
+type SASLAuth struct {
+	credentials map[string]string
+
+	// TextToken is a shared token to authenticate text protocol connections by "AUTH <token>" command.
+	// Empty means text protocol is disabled.
+	TextToken string
+}
+
+// NewSASLAuth creates SASLAuth with credentials which maps users to passwords.
+func NewSASLAuth(credentials map[string]string) *SASLAuth {
+	return &SASLAuth{credentials: credentials}
+}
+
+// LoadSASLAuth loads credentials from a file, which has "<user>:<password>" lines.
+// Empty lines and lines starting with "#" are ignored.
+func LoadSASLAuth(path string) (*SASLAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	credentials := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("invalid credential at line %d of %s", n, path)
+		}
+		credentials[user] = password
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("no credentials in %s", path)
+	}
+	return NewSASLAuth(credentials), nil
+}
+
+func (a *SASLAuth) verify(user, password string) bool {
+	expected, exists := a.credentials[user]
+	return exists && subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1
+}
+
+// verifyPlain verifies a message of SASL PLAIN mechanism, "[authzid] NUL authcid NUL passwd".
+// It returns the authenticated user, or empty on failure.
+func (a *SASLAuth) verifyPlain(msg []byte) string {
+	fields := bytes.Split(msg, []byte{0})
+	if len(fields) != 3 || !a.verify(string(fields[1]), string(fields[2])) {
+		return ""
+	}
+	return string(fields[1])
+}
+
+func (a *SASLAuth) verifyText(token string) bool {
+	return a.TextToken != "" && subtle.ConstantTimeCompare([]byte(a.TextToken), []byte(token)) == 1
+}
+
+// SetSASLAuth requires connections to authenticate by a.
+func (app *App) SetSASLAuth(a *SASLAuth) {
+	app.saslAuth = a
+}
+
+func isSASLOpcode(opcode byte) bool {
+	return opcode == opcodeSASLListMechs || opcode == opcodeSASLAuth || opcode == opcodeSASLStep
+}
+
+// allowedWithoutAuth reports whether a command of opcode can be used by unauthenticated connections.
+func allowedWithoutAuth(opcode byte) bool {
+	switch opcode {
+	case opcodeVersion, opcodeNoop, opcodeQuit, opcodeQuitQ:
+		return true
+	}
+	return false
+}
+
+// respondToSASL responds to SASL commands of binary protocol, and returns the user when the connection is authenticated.
+func (app *App) respondToSASL(req *bRequest, w io.Writer) (string, error) {
+	if req.opcode == opcodeSASLListMechs {
+		res := newBResponse(req.opcode, req.opaque, bResponseConfig{value: SASLMechanisms})
+		_, err := w.Write(res.Bytes())
+		return "", err
+	}
+	// PLAIN completes in a single step, so STEP is same as AUTH
+	var user string
+	if req.key == "PLAIN" {
+		user = app.saslAuth.verifyPlain([]byte(req.value))
+	}
+	if user == "" {
+		log.Warnf("SASL authentication failure by %s", req.key)
+		return "", app.writeBinaryError(w, req.opcode, req.opaque, statusAuthError, ErrAuthFailure)
+	}
+	res := newBResponse(req.opcode, req.opaque, bResponseConfig{value: "Authenticated"})
+	_, err := w.Write(res.Bytes())
+	return user, err
+}
+
+// authenticateText authenticates a text protocol connection by "AUTH <token>" command in line.
+// It returns io.EOF to disconnect when the text protocol is disabled or authentication failed.
+func (app *App) authenticateText(line []byte, w io.Writer) (bool, error) {
+	if app.saslAuth.TextToken == "" {
+		app.writeErrorOf(w, invalidArgumentError("text protocol is disabled"))
+		return false, io.EOF
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || strings.ToUpper(fields[0]) != "AUTH" {
+		return false, app.writeErrorOf(w, invalidArgumentError(ErrAuthRequired.Error()))
+	}
+	if len(fields) != 2 || !app.saslAuth.verifyText(fields[1]) {
+		log.Warn("text protocol authentication failure")
+		app.writeErrorOf(w, invalidArgumentError(ErrAuthFailure.Error()))
+		return false, io.EOF
+	}
+	_, err := w.Write(respAuthenticated)
+	return true, err
+}
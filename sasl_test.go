@@ -0,0 +1,142 @@
+package katsubushi
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/mc"
+)
+
+func TestLoadSASLAuth(t *testing.T) {
+	path := writeTestFile(t, "pwdb", "# users\nalice:secret\n\nbob:pass:word\n")
+	a, err := LoadSASLAuth(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"bob", "pass:word", true},
+		{"alice", "wrong", false},
+		{"carol", "", false},
+	}
+	for _, tt := range tests {
+		if a.verify(tt.user, tt.password) != tt.ok {
+			t.Errorf("unexpected result of %s:%s", tt.user, tt.password)
+		}
+	}
+	if user := a.verifyPlain([]byte("\x00alice\x00secret")); user != "alice" {
+		t.Errorf("PLAIN message must be verified: %q", user)
+	}
+	if user := a.verifyPlain([]byte("\x00alice\x00wrong")); user != "" {
+		t.Errorf("PLAIN message must not be verified: %q", user)
+	}
+
+	for _, content := range []string{"", "alice", ":secret"} {
+		if _, err := LoadSASLAuth(writeTestFile(t, "pwdb", content)); err == nil {
+			t.Errorf("%q must be invalid", content)
+		}
+	}
+}
+
+func newTestAppWithSASL(ctx context.Context, t *testing.T, token string) *App {
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	a := NewSASLAuth(map[string]string{"alice": "secret"})
+	a.TextToken = token
+	app.SetSASLAuth(a)
+	return app
+}
+
+func TestAppBinarySASL(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppWithSASL(ctx, t, "")
+
+	cn, err := mc.Dial("tcp", app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := cn.Get("id"); err != mc.ErrAuthRequired {
+		t.Errorf("unauthenticated connection must be rejected: %v", err)
+	}
+	if err := cn.Auth("alice", "wrong"); err != mc.ErrAuthRequired {
+		t.Errorf("authentication must fail: %v", err)
+	}
+	if err := cn.Auth("alice", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := cn.Get("id"); err != nil {
+		t.Errorf("authenticated connection must get an id: %s", err)
+	}
+}
+
+func TestAppTextAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppWithSASL(ctx, t, "token")
+
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		cmd    string
+		expect string
+	}{
+		{"GET id", "CLIENT_ERROR authentication required\r\n"},
+		{"AUTH token", "OK\r\n"},
+		{"VERSION", "VERSION " + Version + "\r\n"},
+	}
+	for _, tt := range tests {
+		resp, err := client.Command(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(resp) != tt.expect {
+			t.Errorf("unexpected response of %s: %q", tt.cmd, resp)
+		}
+	}
+
+	// disconnected by a wrong token
+	client, err = newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, _ := client.Command("AUTH wrong"); string(resp) != "CLIENT_ERROR authentication failure\r\n" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+	if _, err := client.conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("connection must be closed: %v", err)
+	}
+
+	c := NewClient(app.Listener.Addr().String())
+	c.SetAuthToken("token")
+	if _, err := c.Fetch(ctx); err != nil {
+		t.Errorf("client must fetch an id with the token: %s", err)
+	}
+}
+
+func TestAppTextDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppWithSASL(ctx, t, "")
+
+	conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET id\r\n"))
+	resp, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resp) != "CLIENT_ERROR text protocol is disabled\r\n" {
+		t.Errorf("unexpected response: %q", resp)
+	}
+}
@@ -0,0 +1,93 @@
+package katsubushi
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gogrpc "google.golang.org/grpc"
+)
+
+// DefaultShutdownTimeout is the default grace period to drain connections on shutdown.
+var DefaultShutdownTimeout = 10 * time.Second
+
+// startDraining marks app draining to report not ready, and to close connections after the current commands.
+func (app *App) startDraining() {
+	app.drainOnce.Do(func() {
+		log.Info("Draining connections")
+		close(app.drainCh)
+		app.health.Shutdown()
+		if atomic.LoadInt32(&app.upgraded) == 0 {
+			// the service keeps running by the new process after Upgrade
+			notify("STOPPING=1")
+		}
+	})
+}
+
+func (app *App) isDraining() bool {
+	select {
+	case <-app.drainCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// drainConns waits for connections of the memcached protocol to finish the current commands,
+// and closes them by closeConns after the shutdown timeout.
+func (app *App) drainConns(conns *sync.WaitGroup, closeConns context.CancelFunc) {
+	app.startDraining()
+	if app.shutdownTimeout <= 0 {
+		closeConns()
+		return
+	}
+	app.conns.Range(func(key, _ interface{}) bool {
+		// idle connections fail to read the next command. see extendDeadline.
+		key.(net.Conn).SetReadDeadline(time.Now())
+		return true
+	})
+
+	done := make(chan struct{})
+	go func() {
+		conns.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Info("All connections are drained")
+	case <-time.After(app.shutdownTimeout):
+		log.Warnf("Closing connections not drained in %s", app.shutdownTimeout)
+		closeConns()
+	}
+}
+
+// shutdownHTTPServer waits for active requests of s to finish, and closes s after timeout.
+func (app *App) shutdownHTTPServer(s *http.Server, timeout time.Duration) {
+	app.startDraining()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		log.Warnf("Closing HTTP connections not drained in %s", timeout)
+		s.Close()
+	}
+}
+
+// stopGRPCServer waits for active RPCs of s to finish, and stops s after timeout.
+func (app *App) stopGRPCServer(s *gogrpc.Server, timeout time.Duration) {
+	app.startDraining()
+	done := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Warnf("Closing gRPC connections not drained in %s", timeout)
+		s.Stop()
+		<-done
+	}
+}
@@ -0,0 +1,127 @@
+package katsubushi
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func runTestAppDrain(t *testing.T, delay, shutdownTimeout time.Duration) (*App, context.CancelFunc, <-chan error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	app := newTestAppDelayed(t, delay)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- app.RunServer(ctx, &Config{
+			Listens:         []ListenAddr{{Network: "tcp", Address: "localhost:0"}},
+			ShutdownTimeout: shutdownTimeout,
+		})
+	}()
+	<-app.Ready()
+	return app, cancel, errCh
+}
+
+func TestDrain(t *testing.T) {
+	app, cancel, errCh := runTestAppDrain(t, time.Second, 5*time.Second)
+	addr := app.Listener.Addr().String()
+
+	idle, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	busy, err := newTestClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := busy.conn.Write([]byte("GET id\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		stats, _ := app.statsOf("conns")
+		for _, s := range stats {
+			if strings.HasSuffix(s.name, ":cmds") && s.value == "1" {
+				return true
+			}
+		}
+		return false
+	}, "the command must be in flight")
+	cancel()
+
+	// the idle connection is closed immediately
+	idle.conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	if b, err := io.ReadAll(idle.conn); err != nil || len(b) != 0 {
+		t.Errorf("idle connection must be closed: %q %v", b, err)
+	}
+	// the in-flight command finishes, and then the connection is closed
+	busy.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if b, err := io.ReadAll(busy.conn); err != nil || !strings.HasPrefix(string(b), "VALUE id") {
+		t.Errorf("in-flight command must finish: %q %v", b, err)
+	}
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+	if !app.isDraining() {
+		t.Error("app must be draining")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	app, cancel, errCh := runTestAppDrain(t, 3*time.Second, 100*time.Millisecond)
+
+	busy, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := busy.conn.Write([]byte("GET id\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("connections must be closed after the shutdown timeout: %s", d)
+	}
+	busy.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if b, err := io.ReadAll(busy.conn); err != nil || len(b) != 0 {
+		t.Errorf("connection must be closed: %q %v", b, err)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	app := newTestApp(t, nil)
+	get := func() int {
+		w := httptest.NewRecorder()
+		app.HTTPGetHealth(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+		return w.Code
+	}
+	grpcStatus := func() healthpb.HealthCheckResponse_ServingStatus {
+		res, err := app.health.Check(context.Background(), &healthpb.HealthCheckRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Status
+	}
+
+	if c := get(); c != http.StatusOK {
+		t.Errorf("unexpected status: %d", c)
+	}
+	if s := grpcStatus(); s != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("unexpected gRPC health: %s", s)
+	}
+	app.startDraining()
+	if c := get(); c != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status while draining: %d", c)
+	}
+	if s := grpcStatus(); s != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("unexpected gRPC health while draining: %s", s)
+	}
+}
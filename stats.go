@@ -0,0 +1,254 @@
+package katsubushi
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var respReset = []byte("RESET\r\n")
+
+// protocols of connections
+const (
+	protocolUnknown int32 = iota
+	protocolText
+	protocolBinary
+)
+
+var protocolNames = map[int32]string{
+	protocolUnknown: "unknown",
+	protocolText:    "text",
+	protocolBinary:  "binary",
+}
+
+// memdStat is a pair of a name and a value of stats.
+type memdStat struct {
+	name  string
+	value string
+}
+
+// memdStatList is a list of stats responded by STATS command.
+type memdStatList []memdStat
+
+func (l memdStatList) add(name string, value interface{}) memdStatList {
+	return append(l, memdStat{name: name, value: fmt.Sprint(value)})
+}
+
+// WriteTo writes stats as "STAT <name> <value>" lines terminated by END.
+func (l memdStatList) WriteTo(w io.Writer) (int64, error) {
+	for _, s := range l {
+		w.Write(memdStatHeader)
+		io.WriteString(w, s.name)
+		w.Write(memdSpc)
+		io.WriteString(w, s.value)
+		w.Write(memdSep)
+	}
+	n, err := w.Write(memdValFooter)
+	return int64(n), err
+}
+
+func (l memdStatList) writeBinaryTo(w io.Writer, opaque [4]byte) error {
+	for _, s := range l {
+		res := newBResponse(opcodeStat, opaque, bResponseConfig{
+			key:   s.name,
+			value: s.value,
+		})
+		if _, err := w.Write(res.Bytes()); err != nil {
+			return err
+		}
+	}
+	// to teminate the sequence
+	emptyRes := newBResponse(opcodeStat, opaque, bResponseConfig{})
+	_, err := w.Write(emptyRes.Bytes())
+	return err
+}
+
+func (s MemdStats) list() memdStatList {
+	statsValue := reflect.ValueOf(s)
+	statsType := reflect.TypeOf(s)
+	l := make(memdStatList, 0, statsType.NumField())
+	for i := 0; i < statsType.NumField(); i++ {
+		field := statsType.Field(i)
+		name := field.Tag.Get("memd")
+		if name == "" {
+			name = strings.ToUpper(field.Name)
+		}
+		l = l.add(name, statsValue.FieldByIndex(field.Index).Interface())
+	}
+	return l
+}
+
+// connStat is stats of a connection for "stats conns".
+type connStat struct {
+	id        int64
+	addr      string
+	startedAt time.Time
+
+	// these values are accessed atomically
+	protocol int32
+	cmds     int64
+}
+
+func (app *App) trackConn(conn net.Conn) *connStat {
+	cs := &connStat{
+		id:        atomic.AddInt64(&app.connSeq, 1),
+		addr:      conn.RemoteAddr().String(),
+		startedAt: time.Now(),
+	}
+	app.conns.Store(conn, cs)
+	return cs
+}
+
+func (app *App) untrackConn(conn net.Conn) {
+	app.conns.Delete(conn)
+}
+
+// countCmd counts a command executed on conn.
+func (app *App) countCmd(conn net.Conn) {
+	if v, ok := app.conns.Load(conn); ok {
+		atomic.AddInt64(&v.(*connStat).cmds, 1)
+	}
+}
+
+func (app *App) setListenAddr(name string, addr net.Addr) {
+	app.listenAddrs.Store(name, addr.String())
+}
+
+// statsOf returns stats of STATS subcommand name, except for reset.
+func (app *App) statsOf(name string) (memdStatList, error) {
+	switch strings.ToLower(name) {
+	case "settings":
+		return app.settingStats(), nil
+	case "conns":
+		return app.connStats(), nil
+	case "generator":
+		return app.generatorStats(), nil
+	case "ratelimits":
+		if app.rateLimiter == nil {
+			return nil, nil
+		}
+		return app.rateLimiter.stats(), nil
+	}
+	return nil, fmt.Errorf("%w: stats %s", errUnknownCommand, name)
+}
+
+func (app *App) settingStats() memdStatList {
+	var l memdStatList
+	l = l.add("worker_id", app.gen.WorkerID())
+	l = l.add("worker_id_bits", WorkerIDBits)
+	l = l.add("sequence_bits", SequenceBits)
+	l = l.add("epoch", Epoch.UnixNano()/int64(time.Millisecond))
+	l = l.add("idle_timeout", int64(app.idleTimeout.Seconds()))
+	l = l.add("shutdown_timeout", int64(app.shutdownTimeout.Seconds()))
+	l = l.add("max_connections", atomic.LoadInt64(&app.maxConnections))
+	if len(app.listeners) > 0 {
+		addrs := make([]string, 0, len(app.listeners))
+		for _, ln := range app.listeners {
+			addrs = append(addrs, ln.Addr().String())
+		}
+		l = l.add("addr", strings.Join(addrs, ","))
+	}
+	for _, name := range []string{"http_addr", "grpc_addr"} {
+		if addr, ok := app.listenAddrs.Load(name); ok {
+			l = l.add(name, addr)
+		}
+	}
+	auth := "no"
+	if app.saslAuth != nil {
+		auth = "yes"
+	}
+	l = l.add("auth_enabled", auth)
+	l = l.add("proxy_protocol", app.proxyProtocol.mode())
+	draining := "no"
+	if app.isDraining() {
+		draining = "yes"
+	}
+	l = l.add("draining", draining)
+	if app.workerLeaser != nil {
+		l = l.add("worker_lease_min_worker_id", app.workerLeaser.MinWorkerID)
+		l = l.add("worker_lease_max_worker_id", app.workerLeaser.MaxWorkerID)
+		l = l.add("worker_lease_ttl", int64(app.workerLeaser.TTL.Seconds()))
+	}
+	l = l.add("max_range_size", MaxRangeSize)
+	return l
+}
+
+func (app *App) connStats() memdStatList {
+	var conns []*connStat
+	app.conns.Range(func(_, v interface{}) bool {
+		conns = append(conns, v.(*connStat))
+		return true
+	})
+	sort.Slice(conns, func(i, j int) bool { return conns[i].id < conns[j].id })
+
+	var l memdStatList
+	now := time.Now()
+	for _, cs := range conns {
+		prefix := strconv.FormatInt(cs.id, 10) + ":"
+		l = l.add(prefix+"addr", cs.addr)
+		l = l.add(prefix+"protocol", protocolNames[atomic.LoadInt32(&cs.protocol)])
+		l = l.add(prefix+"age", int64(now.Sub(cs.startedAt).Seconds()))
+		l = l.add(prefix+"cmds", atomic.LoadInt64(&cs.cmds))
+	}
+	return l
+}
+
+func (app *App) generatorStats() memdStatList {
+	var l memdStatList
+	l = l.add("worker_id", app.gen.WorkerID())
+	g, ok := app.gen.(*generator)
+	if !ok {
+		return l
+	}
+	g.lock.Lock()
+	lastTimestamp, sequence := g.lastTimestamp, g.sequence
+	g.lock.Unlock()
+	l = l.add("last_timestamp", lastTimestamp)
+	l = l.add("last_sequence", sequence)
+	l = l.add("current_timestamp", g.timestamp())
+	l = l.add("started_at", g.startedAt.Unix())
+	return l
+}
+
+// ResetStats resets counters of stats, and clears worker_id_conflict to acknowledge it.
+// worker_id_conflict is kept while the conflict continues,
+// and worker_id_fallback is kept while the fallback worker ID is in use because it is a state, not a counter.
+func (app *App) ResetStats() {
+	atomic.StoreInt64(&app.totalConnections, 0)
+	atomic.StoreInt64(&app.cmdGet, 0)
+	atomic.StoreInt64(&app.getHits, 0)
+	atomic.StoreInt64(&app.getMisses, 0)
+	atomic.StoreInt64(&app.rejectedConnections, 0)
+	if app.gossip != nil {
+		app.gossip.resetDetected()
+	}
+	if app.rateLimiter != nil {
+		app.rateLimiter.resetStats()
+	}
+}
+
+// MemdCmdStatsSub defines STATS command with a subcommand, settings, conns, generator, ratelimits or reset.
+type MemdCmdStatsSub struct {
+	Name string
+}
+
+// Execute writes stats of the subcommand, or RESET for reset.
+func (cmd *MemdCmdStatsSub) Execute(app *App, w io.Writer) error {
+	if strings.ToLower(cmd.Name) == "reset" {
+		app.ResetStats()
+		_, err := w.Write(respReset)
+		return err
+	}
+	l, err := app.statsOf(cmd.Name)
+	if err != nil {
+		return app.writeError(w)
+	}
+	_, err = l.WriteTo(w)
+	return err
+}
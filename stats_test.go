@@ -0,0 +1,131 @@
+package katsubushi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppStatsSubcommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByAddr, Rate: 100}))
+	client, err := newTestClient(app.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Command("GET id"); err != nil {
+		t.Fatal(err)
+	}
+	// counters and acknowledged alerts are reset by stats reset, but the fallback worker ID in use is not
+	app.SetWorkerIDFallback(true)
+	atomic.AddInt64(&app.rejectedConnections, 1)
+	g, err := NewGossip("udp", "127.0.0.1:0", app.gen.WorkerID(), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.close()
+	g.detected = 1
+	app.gossip = g
+
+	tests := []struct {
+		cmd     string
+		expects []string
+	}{
+		{"stats settings", []string{
+			fmt.Sprintf("STAT worker_id %d\r\n", app.gen.WorkerID()),
+			"STAT worker_id_bits 10\r\n",
+			"STAT sequence_bits 12\r\n",
+			"STAT epoch 1420070400000\r\n",
+			"STAT addr " + app.Listener.Addr().String() + "\r\n",
+			"STAT auth_enabled no\r\n",
+			"STAT draining no\r\n",
+		}},
+		{"stats conns", []string{
+			"STAT 1:addr " + client.conn.LocalAddr().String() + "\r\n",
+			"STAT 1:protocol text\r\n",
+			"STAT 1:cmds 3\r\n",
+		}},
+		{"stats generator", []string{
+			fmt.Sprintf("STAT worker_id %d\r\n", app.gen.WorkerID()),
+			"STAT last_timestamp ",
+			"STAT last_sequence 0\r\n",
+		}},
+		{"stats", []string{
+			"STAT worker_id_fallback 1\r\n",
+			"STAT worker_id_conflict 1\r\n",
+			"STAT rejected_connections 1\r\n",
+		}},
+		{"stats ratelimits", []string{":allowed 1\r\n"}},
+		{"stats reset", []string{"RESET\r\n"}},
+		{"stats", []string{
+			"STAT cmd_get 0\r\n",
+			"STAT worker_id_fallback 1\r\n",
+			"STAT worker_id_conflict 0\r\n",
+			"STAT rejected_connections 0\r\n",
+		}},
+		{"stats ratelimits", []string{":allowed 0\r\n", ":throttled 0\r\n"}},
+		{"stats foo", []string{"ERROR\r\n"}},
+	}
+	for _, tt := range tests {
+		resp, err := client.Command(tt.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range tt.expects {
+			if !strings.Contains(string(resp), e) {
+				t.Errorf("%s must contain %q: %s", tt.cmd, e, resp)
+			}
+		}
+	}
+}
+
+func TestAppBinaryStatsSubcommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	conn, err := net.DialTimeout("tcp", app.Listener.Addr().String(), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	readStats := func(key string) map[string]string {
+		conn.Write(newTestBRequest(opcodeStat, 1, key))
+		stats := map[string]string{}
+		for {
+			res := readTestBResponse(t, conn)
+			if res.status != [2]byte{} {
+				t.Errorf("unexpected status of stats %s: %x", key, res.status)
+				return nil
+			}
+			if res.key == "" {
+				return stats
+			}
+			stats[res.key] = res.value
+		}
+	}
+
+	if s := readStats("settings"); s["worker_id"] != fmt.Sprint(app.gen.WorkerID()) {
+		t.Errorf("unexpected settings: %v", s)
+	}
+	if s := readStats("conns"); s["1:protocol"] != "binary" || s["1:cmds"] != "2" {
+		t.Errorf("unexpected conns: %v", s)
+	}
+	if s := readStats("generator"); s["last_timestamp"] != "0" {
+		t.Errorf("unexpected generator: %v", s)
+	}
+	if s := readStats("reset"); len(s) != 0 {
+		t.Errorf("unexpected reset: %v", s)
+	}
+
+	conn.Write(newTestBRequest(opcodeStat, 2, "foo"))
+	if res := readTestBResponse(t, conn); res.status != statusKeyNotFound || res.opaque[3] != 2 {
+		t.Errorf("unexpected response: %#v", res)
+	}
+}
@@ -0,0 +1,128 @@
+package katsubushi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation.
+const listenFDsStart = 3
+
+// SystemdListeners returns listeners passed by systemd socket activation (LISTEN_FDS),
+// grouped by names of FileDescriptorName= in socket units. A name is "unknown" when not specified.
+// It returns nil without error when no listeners are passed.
+// The environment variables are unset not to be inherited by child processes.
+func SystemdListeners() (map[string][]net.Listener, error) {
+	return systemdListeners(listenFDsStart)
+}
+
+func systemdListeners(start int) (map[string][]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	var names []string
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+	ls, err := fileListeners(start, n, names)
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket passed by systemd: %w", err)
+	}
+	return ls, nil
+}
+
+// fileListeners returns listeners of n sequential file descriptors from start, grouped by names.
+// A name is "unknown" when not specified.
+func fileListeners(start, n int, names []string) (map[string][]net.Listener, error) {
+	ls := map[string][]net.Listener{}
+	for i := 0; i < n; i++ {
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(start+i), name)
+		// FileListener duplicates the descriptor with close-on-exec
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ls[name] = append(ls[name], l)
+	}
+	return ls, nil
+}
+
+// sdNotify sends state to systemd by sd_notify protocol. It does nothing without NOTIFY_SOCKET.
+func sdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	if strings.HasPrefix(path, "@") {
+		// abstract namespace
+		path = "\x00" + path[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notify sends state to systemd, and logs a failure.
+func notify(state string) {
+	if err := sdNotify(state); err != nil {
+		log.Warnf("Failed to notify %q to systemd: %s", state, err)
+	}
+}
+
+// watchdogInterval returns an interval of pings to the systemd watchdog, which is half of WatchdogSec=.
+// It returns 0 when the watchdog is disabled.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// notifyReady notifies systemd that app is ready, and starts pings to the watchdog until ctx is done.
+func (app *App) notifyReady(ctx context.Context) {
+	// MAINPID is for a new process started by Upgrade
+	notify(fmt.Sprintf("READY=1\nMAINPID=%d\nSTATUS=Serving with worker ID %d", os.Getpid(), app.gen.WorkerID()))
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				notify("WATCHDOG=1")
+			}
+		}
+	}()
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+package katsubushi
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSystemdListeners(t *testing.T) {
+	var fds []int
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// systemd passes sequential descriptors, duplicated to the lowest ones above 1000
+		fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_DUPFD, 1000)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		f.Close()
+		l.Close()
+		fds = append(fds, int(fd))
+	}
+	if fds[1] != fds[0]+1 {
+		t.Skipf("descriptors are not sequential: %v", fds)
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "2")
+	t.Setenv("LISTEN_FDNAMES", "memcache:http")
+	ls, err := systemdListeners(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ls["memcache"]) != 1 || len(ls["http"]) != 1 {
+		t.Fatalf("unexpected listeners: %v", ls)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS must be unset")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestApp(t, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- app.RunServer(ctx, &Config{Listeners: ls["memcache"]})
+	}()
+	<-app.Ready()
+	client, err := newTestClient(ls["memcache"][0].Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Command("GET id"); err != nil || !strings.HasPrefix(string(resp), "VALUE id") {
+		t.Fatalf("unexpected response: %s %v", resp, err)
+	}
+	ls["http"][0].Close()
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Error(err)
+	}
+
+	// not for this process
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "2")
+	if ls, err := systemdListeners(fds[0]); err != nil || ls != nil {
+		t.Errorf("listeners for another process must be ignored: %v %v", ls, err)
+	}
+}
+
+func TestSDNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	t.Setenv("WATCHDOG_USEC", "100000")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestApp(t, nil)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- app.RunServer(ctx, &Config{Listens: []ListenAddr{{Network: "tcp", Address: "localhost:0"}}})
+	}()
+	<-app.Ready()
+
+	read := func() string {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b := make([]byte, 1024)
+		n, err := conn.Read(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b[:n])
+	}
+	if s := read(); !strings.HasPrefix(s, "READY=1\n") {
+		t.Errorf("unexpected state: %q", s)
+	}
+	if s := read(); s != "WATCHDOG=1" {
+		t.Errorf("unexpected state: %q", s)
+	}
+	cancel()
+	<-errCh
+	for {
+		s := read()
+		if s == "STOPPING=1" {
+			break
+		}
+		if s != "WATCHDOG=1" {
+			t.Fatalf("unexpected state: %q", s)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package katsubushi
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is the default interval to check modification of certificate files.
+var DefaultCertReloadInterval = 10 * time.Second
+
+// CertReloader loads a certificate and key pair, and reloads them when the files are modified.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	// Interval is the minimum interval to check modification of the files on handshakes.
+	Interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	checkedAt time.Time
+}
+
+// NewCertReloader creates CertReloader which loads certFile and keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		Interval: DefaultCertReloadInterval,
+	}
+	modTime, err := r.lastModified()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// lastModified returns the latest modification time of the files.
+func (r *CertReloader) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		st, err := os.Stat(file)
+		if err != nil {
+			return modTime, err
+		}
+		if st.ModTime().After(modTime) {
+			modTime = st.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+func (r *CertReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// GetCertificate returns the certificate for tls.Config.GetCertificate.
+// When the files are modified, it reloads them. The current certificate is kept on failure of reloading,
+// because the files may be in the middle of an update.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checkedAt) < r.Interval {
+		return r.cert, nil
+	}
+	r.checkedAt = time.Now()
+	modTime, err := r.lastModified()
+	if err != nil {
+		log.Warnf("failed to check certificate files: %s", err)
+		return r.cert, nil
+	}
+	if modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.load(modTime); err != nil {
+		log.Warn(err)
+		return r.cert, nil
+	}
+	log.Infof("Reloaded certificate %s", r.certFile)
+	return r.cert, nil
+}
+
+// NewServerTLSConfig creates tls.Config for servers with a certificate reloaded on modification.
+// When clientCAFile is not empty, clients must present certificates signed by the CAs in it.
+func NewServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+	if clientCAFile != "" {
+		pool, err := loadCertPool(clientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
@@ -0,0 +1,191 @@
+package katsubushi
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kayac/go-katsubushi/v2/grpc"
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func newTestClientTLSConfig(t *testing.T, cert tls.Certificate) *tls.Config {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return &tls.Config{RootCAs: pool, ServerName: "localhost"}
+}
+
+func newTestAppTLS(ctx context.Context, t *testing.T, tlsConfig *tls.Config) (*App, string) {
+	app := newTestApp(t, nil)
+	go app.RunServer(ctx, &Config{Port: 0, TLSConfig: tlsConfig})
+	<-app.Ready()
+	port := app.Listener.Addr().(*net.TCPAddr).Port
+	return app, fmt.Sprintf("localhost:%d", port)
+}
+
+func TestTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, certFile, keyFile := newTestCertificate(t)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newTestAppTLS(ctx, t, serverConfig)
+
+	c := NewClient(addr)
+	c.SetTLSConfig(newTestClientTLSConfig(t, cert))
+	if id, err := c.Fetch(ctx); err != nil || id == 0 {
+		t.Fatalf("failed to fetch over TLS: %d %s", id, err)
+	}
+
+	// plain text connections are not accepted
+	plain := NewClient(addr)
+	plain.SetTimeout(time.Second)
+	if _, err := plain.Fetch(ctx); err == nil {
+		t.Fatal("plain text connection must fail")
+	}
+}
+
+func TestTLSClientCertificate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, certFile, keyFile := newTestCertificate(t)
+	// the self-signed certificate is also a CA of client certificates
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, addr := newTestAppTLS(ctx, t, serverConfig)
+
+	c := NewClient(addr)
+	c.SetTLSConfig(newTestClientTLSConfig(t, cert))
+	if _, err := c.Fetch(ctx); err == nil {
+		t.Fatal("connection without client certificate must fail")
+	}
+
+	clientConfig := newTestClientTLSConfig(t, cert)
+	clientConfig.Certificates = []tls.Certificate{cert}
+	c = NewClient(addr)
+	c.SetTLSConfig(clientConfig)
+	if id, err := c.Fetch(ctx); err != nil || id == 0 {
+		t.Fatalf("failed to fetch with client certificate: %d %s", id, err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	cert, certFile, keyFile := newTestCertificate(t)
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Interval = 0
+	got, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Certificate[0], cert.Certificate[0]) {
+		t.Fatal("unexpected certificate")
+	}
+
+	// broken files keep the current certificate
+	if err := os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, future, future)
+	if got, _ := r.GetCertificate(nil); !bytes.Equal(got.Certificate[0], cert.Certificate[0]) {
+		t.Fatal("certificate must be kept on failure of reloading")
+	}
+
+	newCert, newCertFile, newKeyFile := newTestCertificate(t)
+	for src, dst := range map[string]string{newCertFile: certFile, newKeyFile: keyFile} {
+		b, err := os.ReadFile(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		future := time.Now().Add(2 * time.Minute)
+		os.Chtimes(dst, future, future)
+	}
+	if got, _ := r.GetCertificate(nil); !bytes.Equal(got.Certificate[0], newCert.Certificate[0]) {
+		t.Fatal("certificate must be reloaded")
+	}
+}
+
+func TestHTTPTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, certFile, keyFile := newTestCertificate(t)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, nil)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunHTTPServer(ctx, &Config{HTTPListener: l, TLSConfig: serverConfig})
+
+	c, err := NewHTTPClient([]string{fmt.Sprintf("https://localhost:%d", l.Addr().(*net.TCPAddr).Port)}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTLSConfig(newTestClientTLSConfig(t, cert))
+	waitFor(t, func() bool {
+		id, err := c.Fetch(ctx)
+		return err == nil && id != 0
+	}, "failed to fetch over HTTPS")
+}
+
+func TestGRPCTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cert, certFile, keyFile := newTestCertificate(t)
+	serverConfig, err := NewServerTLSConfig(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newTestApp(t, nil)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunGRPCServer(ctx, &Config{GRPCListener: l, TLSConfig: serverConfig})
+
+	conn, err := gogrpc.Dial(
+		fmt.Sprintf("localhost:%d", l.Addr().(*net.TCPAddr).Port),
+		gogrpc.WithTransportCredentials(credentials.NewTLS(newTestClientTLSConfig(t, cert))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx2, cancel2 := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel2()
+	res, err := grpc.NewGeneratorClient(conn).Fetch(ctx2, &grpc.FetchRequest{}, gogrpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Id == 0 {
+		t.Fatal("id should not be 0")
+	}
+}
@@ -0,0 +1,267 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// environment variables to hand over to a new process started by Upgrade
+const (
+	upgradeParentPIDEnv = "KATSUBUSHI_UPGRADE_PARENT_PID"
+	upgradeFDNamesEnv   = "KATSUBUSHI_UPGRADE_FDNAMES"
+	upgradeWorkerIDEnv  = "KATSUBUSHI_UPGRADE_WORKER_ID"
+	upgradeHandoffEnv   = "KATSUBUSHI_UPGRADE_HANDOFF"
+)
+
+// ErrHandedOver means that IDs are issued by the new process started by Upgrade.
+var ErrHandedOver = errors.New("ids are issued by the new process after upgrade")
+
+// upgradeParentPollInterval is an interval to check the parent process is stopped.
+var upgradeParentPollInterval = 10 * time.Millisecond
+
+// handoffListener is a listener to hand over to a new process on upgrade.
+type handoffListener struct {
+	name string
+	l    net.Listener
+}
+
+// addHandoffListener registers a listener of the server of name to hand over on upgrade.
+func (app *App) addHandoffListener(name string, l net.Listener) {
+	if ml, ok := l.(*monitListener); ok {
+		l = ml.Listener
+	}
+	app.handoffMu.Lock()
+	defer app.handoffMu.Unlock()
+	app.handoffListeners = append(app.handoffListeners, handoffListener{name: name, l: l})
+}
+
+// Upgrade starts a new process of the executable with the same arguments, handing over listening sockets
+// of all servers and the worker ID. app stops issuing IDs when Upgrade succeeds, so the caller should
+// shut down app, and call Handoff after releasing the worker ID. The new process waits for Handoff
+// or app to exit before allocating the worker ID.
+func (app *App) Upgrade() (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	app.handoffMu.Lock()
+	hs := append([]handoffListener{}, app.handoffListeners...)
+	app.handoffMu.Unlock()
+
+	files := make([]*os.File, 0, len(hs))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	names := make([]string, 0, len(hs))
+	for _, h := range hs {
+		fl, ok := h.l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener of %s at %s can not be handed over", h.name, h.l.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		names = append(names, h.name)
+	}
+
+	// the new process reads EOF from the pipe on Handoff or exit of app
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, r)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, "KATSUBUSHI_UPGRADE_") {
+			cmd.Env = append(cmd.Env, env)
+		}
+	}
+	cmd.Env = append(cmd.Env,
+		upgradeParentPIDEnv+"="+strconv.Itoa(os.Getpid()),
+		upgradeFDNamesEnv+"="+strings.Join(names, ":"),
+		upgradeWorkerIDEnv+"="+strconv.FormatUint(uint64(app.gen.WorkerID()), 10),
+		upgradeHandoffEnv+"=1",
+	)
+	if err := cmd.Start(); err != nil {
+		w.Close()
+		return nil, fmt.Errorf("failed to start a new process: %w", err)
+	}
+	for _, h := range hs {
+		// the socket file is used by the new process after app stops
+		if ul, ok := h.l.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	// IDs being issued are finished after the lock
+	app.issueMu.Lock()
+	atomic.StoreInt32(&app.upgraded, 1)
+	app.issueMu.Unlock()
+	app.handoffMu.Lock()
+	app.handoffPipe = w
+	app.handoffMu.Unlock()
+	notify(fmt.Sprintf("MAINPID=%d", cmd.Process.Pid))
+	log.Infof("Started a new process %d of %s handing over %d listeners", cmd.Process.Pid, exe, len(files))
+	return cmd.Process, nil
+}
+
+// Handoff lets the new process started by Upgrade allocate the worker ID and issue IDs.
+// It must be called after the worker ID and the other resources held exclusively by app,
+// as the gossip address and the raft store, are released.
+func (app *App) Handoff() error {
+	app.handoffMu.Lock()
+	defer app.handoffMu.Unlock()
+	if app.handoffPipe == nil {
+		return nil
+	}
+	err := app.handoffPipe.Close()
+	app.handoffPipe = nil
+	log.Infof("Handed over issuing IDs to the new process")
+	return err
+}
+
+// Inherited is listening sockets and the worker ID handed over from the parent process by Upgrade.
+type Inherited struct {
+	ParentPID int
+	WorkerID  uint
+
+	// Listeners is grouped by names of servers, memcache, http and grpc.
+	Listeners map[string][]net.Listener
+
+	// handoff is closed by Handoff of the parent process. nil for a parent without it.
+	handoff *os.File
+}
+
+// InheritFromParent returns Inherited when the process is started by Upgrade, or nil.
+// The environment variables are unset not to be inherited by child processes.
+func InheritFromParent() (*Inherited, error) {
+	return inheritFromParent(listenFDsStart)
+}
+
+func inheritFromParent(start int) (*Inherited, error) {
+	pidEnv, namesEnv, workerIDEnv := os.Getenv(upgradeParentPIDEnv), os.Getenv(upgradeFDNamesEnv), os.Getenv(upgradeWorkerIDEnv)
+	handoffEnv := os.Getenv(upgradeHandoffEnv)
+	os.Unsetenv(upgradeParentPIDEnv)
+	os.Unsetenv(upgradeFDNamesEnv)
+	os.Unsetenv(upgradeWorkerIDEnv)
+	os.Unsetenv(upgradeHandoffEnv)
+	if pidEnv == "" {
+		return nil, nil
+	}
+	pid, err := strconv.Atoi(pidEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", upgradeParentPIDEnv, err)
+	}
+	in := &Inherited{ParentPID: pid}
+	if workerIDEnv != "" {
+		id, err := strconv.ParseUint(workerIDEnv, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", upgradeWorkerIDEnv, err)
+		}
+		in.WorkerID = uint(id)
+	}
+	var names []string
+	if namesEnv != "" {
+		names = strings.Split(namesEnv, ":")
+	}
+	if in.Listeners, err = fileListeners(start, len(names), names); err != nil {
+		return nil, fmt.Errorf("invalid socket handed over by the parent process: %w", err)
+	}
+	if handoffEnv != "" {
+		// the pipe follows the sockets
+		in.handoff = os.NewFile(uintptr(start+len(names)), "handoff")
+	}
+	return in, nil
+}
+
+// WaitParent waits for the parent process to stop issuing IDs and to release the worker ID by Handoff, or to exit,
+// and then for the next millisecond not to issue IDs with the same timestamp as the last ones of the parent.
+func (in *Inherited) WaitParent(ctx context.Context) error {
+	if in.handoff != nil {
+		if err := waitEOF(ctx, in.handoff); err != nil {
+			return err
+		}
+	} else if err := in.waitReparented(ctx); err != nil {
+		return err
+	}
+	n := now()
+	time.Sleep(n.Truncate(time.Millisecond).Add(time.Millisecond).Sub(n))
+	return nil
+}
+
+// waitEOF waits for EOF of f written by another process, and closes f.
+func waitEOF(ctx context.Context, f *os.File) error {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(io.Discard, f)
+	}()
+	select {
+	case <-ctx.Done():
+		f.Close()
+		return ctx.Err()
+	case <-done:
+		return f.Close()
+	}
+}
+
+// waitReparented waits for the parent process without Handoff to stop.
+func (in *Inherited) waitReparented(ctx context.Context) error {
+	t := time.NewTicker(upgradeParentPollInterval)
+	defer t.Stop()
+	// the process is reparented after the parent stops
+	for os.Getppid() == in.ParentPID {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// PreferredAllocator holds WorkerID by Registerer at first, as the worker ID inherited from the parent process,
+// and allocates another one by Allocator when it is held by another process.
+type PreferredAllocator struct {
+	Registerer WorkerIDRegisterer
+	Allocator  WorkerIDAllocator
+	WorkerID   uint
+}
+
+// NewPreferredAllocator creates PreferredAllocator.
+func NewPreferredAllocator(r WorkerIDRegisterer, a WorkerIDAllocator, workerID uint) *PreferredAllocator {
+	return &PreferredAllocator{
+		Registerer: r,
+		Allocator:  a,
+		WorkerID:   workerID,
+	}
+}
+
+// Allocate allocates the preferred worker ID if available, or any free worker ID.
+func (a *PreferredAllocator) Allocate(ctx context.Context) (uint, <-chan error, error) {
+	ch, err := a.Registerer.Register(ctx, a.WorkerID)
+	if err == nil {
+		log.Infof("hold the preferred worker id %d", a.WorkerID)
+		return a.WorkerID, ch, nil
+	}
+	if !errors.Is(err, ErrWorkerIDInUse) {
+		return 0, nil, err
+	}
+	log.Infof("the preferred worker id %d is in use, allocating another one", a.WorkerID)
+	return a.Allocator.Allocate(ctx)
+}
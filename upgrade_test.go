@@ -0,0 +1,181 @@
+//go:build linux
+
+package katsubushi
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestInheritFromParent(t *testing.T) {
+	app := newTestApp(t, nil)
+	var fds []int
+	for i := 0; i < 2; i++ {
+		l, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.addHandoffListener("memcache", &monitListener{Listener: l})
+		f, err := l.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the same as ExtraFiles of the new process
+		fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_DUPFD, 1100)
+		if errno != 0 {
+			t.Fatal(errno)
+		}
+		f.Close()
+		defer l.Close()
+		fds = append(fds, int(fd))
+	}
+	// the pipe to wait for Handoff follows the sockets
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	fd, _, errno := syscall.Syscall(syscall.SYS_FCNTL, r.Fd(), syscall.F_DUPFD, 1100)
+	if errno != 0 {
+		t.Fatal(errno)
+	}
+	r.Close()
+	fds = append(fds, int(fd))
+	if fds[1] != fds[0]+1 || fds[2] != fds[1]+1 {
+		t.Skipf("descriptors are not sequential: %v", fds)
+	}
+	for _, h := range app.handoffListeners {
+		if _, ok := h.l.(*net.TCPListener); !ok {
+			t.Errorf("listener to hand over must be unwrapped: %T", h.l)
+		}
+	}
+
+	t.Setenv(upgradeParentPIDEnv, strconv.Itoa(os.Getppid()))
+	t.Setenv(upgradeFDNamesEnv, "memcache:http")
+	t.Setenv(upgradeWorkerIDEnv, "42")
+	t.Setenv(upgradeHandoffEnv, "1")
+	in, err := inheritFromParent(fds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.ParentPID != os.Getppid() || in.WorkerID != 42 {
+		t.Errorf("unexpected inherited: %#v", in)
+	}
+	if len(in.Listeners["memcache"]) != 1 || len(in.Listeners["http"]) != 1 {
+		t.Fatalf("unexpected listeners: %v", in.Listeners)
+	}
+	for _, ls := range in.Listeners {
+		ls[0].Close()
+	}
+	if in.handoff == nil || in.handoff.Fd() != uintptr(fds[2]) {
+		t.Errorf("the pipe must be inherited: %v", in.handoff)
+	}
+	w.Close()
+	if err := in.WaitParent(context.Background()); err != nil {
+		t.Errorf("must return after the pipe is closed: %s", err)
+	}
+	if os.Getenv(upgradeParentPIDEnv) != "" {
+		t.Errorf("%s must be unset", upgradeParentPIDEnv)
+	}
+
+	// not started by Upgrade
+	if in, err := inheritFromParent(fds[0]); err != nil || in != nil {
+		t.Errorf("nothing must be inherited: %v %v", in, err)
+	}
+}
+
+func TestInheritedWaitParent(t *testing.T) {
+	// the parent process has stopped
+	in := &Inherited{ParentPID: os.Getppid() + 1}
+	if err := in.WaitParent(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in = &Inherited{ParentPID: os.Getppid()}
+	if err := in.WaitParent(ctx); err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestAppHandoff(t *testing.T) {
+	app := newTestApp(t, nil)
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same as Upgrade
+	app.issueMu.Lock()
+	app.upgraded = 1
+	app.issueMu.Unlock()
+	app.handoffPipe = w
+	if _, err := app.NextID(); err != ErrHandedOver {
+		t.Errorf("the parent must stop issuing ids: %v", err)
+	}
+
+	in := &Inherited{ParentPID: os.Getppid(), handoff: r}
+	done := make(chan error, 1)
+	go func() {
+		done <- in.WaitParent(context.Background())
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("must wait for Handoff: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	handedOver := now()
+	if err := app.Handoff(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	// the first millisecond of the new process is after the last one of the parent
+	if now().Truncate(time.Millisecond) == handedOver.Truncate(time.Millisecond) {
+		t.Error("must wait for the next millisecond")
+	}
+
+	// canceled
+	r, w, err = os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	in = &Inherited{ParentPID: os.Getppid(), handoff: r}
+	if err := in.WaitParent(ctx); err != context.Canceled {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPreferredAllocator(t *testing.T) {
+	db := openTestSQLite(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r1 := newTestSQLAllocator(t, db, 1, 1023)
+	id1, _, err := NewPreferredAllocator(r1, r1, 42).Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id1 != 42 {
+		t.Errorf("the preferred worker id must be allocated: %d", id1)
+	}
+
+	// 42 is held by r1
+	r2 := newTestSQLAllocator(t, db, 1, 1023)
+	id2, _, err := NewPreferredAllocator(r2, r2, 42).Allocate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id2 == 42 {
+		t.Errorf("worker id %d is allocated twice", id2)
+	}
+}
@@ -0,0 +1,319 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// errors of worker leases
+var (
+	ErrWorkerLeaseNotFound = errors.New("worker lease not found")
+	ErrWorkerLeaseExpired  = errors.New("worker lease expired")
+	ErrWorkerLeaseDisabled = errors.New("worker lease is disabled")
+)
+
+// DefaultWorkerLeaseTTL is the default TTL of worker leases to clients.
+var DefaultWorkerLeaseTTL = 60 * time.Second
+
+// WorkerLease is a worker ID leased to a client to generate IDs locally.
+type WorkerLease struct {
+	WorkerID uint
+	Token    string
+	TTL      time.Duration
+
+	// server is an index of servers of the client which leased it.
+	server int
+}
+
+type workerLease struct {
+	token   string
+	expires time.Time
+
+	// cancel releases the worker ID held by Registerer.
+	cancel context.CancelFunc
+
+	// reserved is true while Registerer is registering the worker ID.
+	reserved bool
+}
+
+// WorkerLeaser leases worker IDs in a reserved range to clients.
+// The range must not be used by any katsubushi servers.
+type WorkerLeaser struct {
+	MinWorkerID uint
+	MaxWorkerID uint
+	TTL         time.Duration
+
+	// ReuseDelay is the duration to keep an expired worker ID from leasing again,
+	// as a margin for the client which has not noticed the expiration yet.
+	ReuseDelay time.Duration
+
+	// Registerer holds leased worker IDs in the backend shared among katsubushi servers,
+	// so that servers leasing the same range or restarted servers never lease a worker ID held by another.
+	// A worker ID is held until ReuseDelay passes after its lease is expired or released.
+	// Leases are kept only in memory of this server when it is nil.
+	Registerer WorkerIDRegisterer
+
+	mu     sync.Mutex
+	leases map[uint]workerLease
+}
+
+// NewWorkerLeaser creates WorkerLeaser.
+func NewWorkerLeaser(min, max uint, ttl time.Duration) (*WorkerLeaser, error) {
+	if err := validateWorkerIDRange(min, max); err != nil {
+		return nil, err
+	}
+	if ttl <= 0 {
+		return nil, errors.New("ttl of worker lease must be positive")
+	}
+	return &WorkerLeaser{
+		MinWorkerID: min,
+		MaxWorkerID: max,
+		TTL:         ttl,
+		ReuseDelay:  ttl,
+		leases:      map[uint]workerLease{},
+	}, nil
+}
+
+// Lease leases an unused worker ID to the client of addr.
+// Registerer records addr as the holder of the worker ID in the history of leases.
+func (l *WorkerLeaser) Lease(addr net.Addr) (*WorkerLease, error) {
+	u, err := uuid.NewRandom()
+	if err != nil {
+		return nil, err
+	}
+	for id := l.MinWorkerID; id <= l.MaxWorkerID; id++ {
+		ok, err := l.lease(id, u.String(), addr)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			log.Infof("leased worker id %d to a client %s", id, addr)
+			return &WorkerLease{WorkerID: id, Token: u.String(), TTL: l.TTL}, nil
+		}
+	}
+	return nil, fmt.Errorf("no more available worker id between %d and %d", l.MinWorkerID, l.MaxWorkerID)
+}
+
+// lease tries to lease id with token. It returns false when id is in use.
+// id is reserved while Registerer registers it without the lock, not to block other leases.
+func (l *WorkerLeaser) lease(id uint, token string, addr net.Addr) (bool, error) {
+	l.mu.Lock()
+	n := now()
+	// a worker ID held by Registerer is removed by watch after the backend releases it
+	if wl, exists := l.leases[id]; exists && (wl.reserved || wl.cancel != nil || n.Before(wl.expires.Add(l.ReuseDelay))) {
+		l.mu.Unlock()
+		return false, nil
+	}
+	if l.Registerer == nil {
+		l.leases[id] = workerLease{token: token, expires: n.Add(l.TTL)}
+		l.mu.Unlock()
+		return true, nil
+	}
+	l.leases[id] = workerLease{reserved: true}
+	l.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(withLessee(context.Background(), addr))
+	ch, err := l.Registerer.Register(ctx, id)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		cancel()
+		delete(l.leases, id)
+		if errors.Is(err, ErrWorkerIDInUse) {
+			return false, nil
+		}
+		return false, err
+	}
+	l.leases[id] = workerLease{token: token, expires: now().Add(l.TTL), cancel: cancel}
+	go l.watch(id, ch)
+	return true, nil
+}
+
+// watch releases id held by Registerer when ReuseDelay passes after the lease is expired or released.
+// The lease is removed after the backend releases id or reports the loss of it.
+func (l *WorkerLeaser) watch(id uint, ch <-chan error) {
+	timer := time.NewTimer(l.TTL + l.ReuseDelay)
+	defer timer.Stop()
+	for {
+		select {
+		case err, ok := <-ch:
+			l.mu.Lock()
+			wl := l.leases[id]
+			if ok {
+				// stop renewing the lease, and wait for closing ch
+				log.Warnf("lost worker id %d leased to a client: %s", id, err)
+				wl.expires = time.Time{}
+				l.leases[id] = wl
+				l.mu.Unlock()
+				continue
+			}
+			wl.cancel()
+			delete(l.leases, id)
+			l.mu.Unlock()
+			return
+		case <-timer.C:
+			l.mu.Lock()
+			wl := l.leases[id]
+			if d := wl.expires.Add(l.ReuseDelay).Sub(now()); d > 0 {
+				timer.Reset(d)
+			} else {
+				wl.cancel()
+			}
+			l.mu.Unlock()
+		}
+	}
+}
+
+// Renew extends the lease of id.
+func (l *WorkerLeaser) Renew(id uint, token string) (*WorkerLease, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := now()
+	wl, exists := l.leases[id]
+	if !exists || wl.token != token || !n.Before(wl.expires) {
+		return nil, ErrWorkerLeaseNotFound
+	}
+	wl.expires = n.Add(l.TTL)
+	l.leases[id] = wl
+	return &WorkerLease{WorkerID: id, Token: token, TTL: l.TTL}, nil
+}
+
+// Release releases the lease of id.
+func (l *WorkerLeaser) Release(id uint, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	wl, exists := l.leases[id]
+	if !exists || wl.token != token || wl.expires.IsZero() {
+		return ErrWorkerLeaseNotFound
+	}
+	if wl.cancel != nil {
+		// the client has stopped using id, so the backend releases it without ReuseDelay
+		wl.expires = time.Time{}
+		l.leases[id] = wl
+		wl.cancel()
+	} else {
+		delete(l.leases, id)
+	}
+	log.Infof("released worker id %d leased to a client", id)
+	return nil
+}
+
+// WorkerLeaseClient is a client to lease a worker ID from katsubushi servers.
+type WorkerLeaseClient interface {
+	LeaseWorker(ctx context.Context) (*WorkerLease, error)
+	RenewWorker(ctx context.Context, l *WorkerLease) (*WorkerLease, error)
+	ReleaseWorker(ctx context.Context, l *WorkerLease) error
+}
+
+// LeasedGenerator is a Generator which generates IDs locally with a worker ID leased from katsubushi servers.
+// It renews the lease in background, and stops generating when the lease is expired.
+type LeasedGenerator struct {
+	client WorkerLeaseClient
+
+	mu      sync.RWMutex
+	gen     *generator
+	lease   *WorkerLease
+	expires time.Time
+}
+
+// NewLeasedGenerator leases a worker ID by c and creates LeasedGenerator.
+// The lease is renewed until ctx is done, and released after that.
+func NewLeasedGenerator(ctx context.Context, c WorkerLeaseClient) (*LeasedGenerator, error) {
+	g := &LeasedGenerator{client: c}
+	if err := g.acquire(ctx); err != nil {
+		return nil, err
+	}
+	go g.renewLoop(ctx)
+	return g, nil
+}
+
+// WorkerID returns the leased worker ID.
+func (g *LeasedGenerator) WorkerID() uint {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.gen.workerID
+}
+
+// NextID generates new ID. It returns ErrWorkerLeaseExpired when the lease is expired.
+func (g *LeasedGenerator) NextID() (uint64, error) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if !now().Before(g.expires) {
+		return 0, ErrWorkerLeaseExpired
+	}
+	return g.gen.NextID()
+}
+
+func (g *LeasedGenerator) acquire(ctx context.Context) error {
+	// the lease starts before sending the request on the client side
+	start := now()
+	l, err := g.client.LeaseWorker(ctx)
+	if err != nil {
+		return err
+	}
+	n := now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.gen == nil || g.gen.workerID != l.WorkerID || !n.Before(g.expires) {
+		g.gen = &generator{workerID: l.WorkerID, startedAt: n, offset: n.Sub(Epoch)}
+	}
+	g.lease = l
+	g.expires = start.Add(l.TTL)
+	return nil
+}
+
+func (g *LeasedGenerator) renew(ctx context.Context) error {
+	g.mu.RLock()
+	lease := g.lease
+	g.mu.RUnlock()
+
+	start := now()
+	l, err := g.client.RenewWorker(ctx, lease)
+	if errors.Is(err, ErrWorkerLeaseNotFound) {
+		log.Warnf("lease of worker id %d is lost, leasing again", lease.WorkerID)
+		return g.acquire(ctx)
+	} else if err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.lease = l
+	g.expires = start.Add(l.TTL)
+	return nil
+}
+
+func (g *LeasedGenerator) renewLoop(ctx context.Context) {
+	for {
+		g.mu.RLock()
+		interval := g.lease.TTL / 3
+		g.mu.RUnlock()
+		select {
+		case <-ctx.Done():
+			g.release()
+			return
+		case <-time.After(interval):
+		}
+		if err := g.renew(ctx); err != nil {
+			log.Warnf("failed to renew a worker lease: %s", err)
+		}
+	}
+}
+
+func (g *LeasedGenerator) release() {
+	g.mu.Lock()
+	g.expires = time.Time{}
+	lease := g.lease
+	g.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultClientTimeout)
+	defer cancel()
+	if err := g.client.ReleaseWorker(ctx, lease); err != nil {
+		log.Warnf("failed to release a lease of worker id %d: %s", lease.WorkerID, err)
+	}
+}
@@ -0,0 +1,342 @@
+package katsubushi
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestWorkerLeaser(t *testing.T) {
+	l, err := NewWorkerLeaser(1000, 1001, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.ReuseDelay = 200 * time.Millisecond
+
+	l1, err := l.Lease(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := l.Lease(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l1.WorkerID == l2.WorkerID {
+		t.Errorf("worker id %d is leased twice", l1.WorkerID)
+	}
+	if _, err := l.Lease(nil); err == nil {
+		t.Error("lease must fail when all worker ids are leased")
+	}
+
+	if _, err := l.Renew(l1.WorkerID, "invalid"); err != ErrWorkerLeaseNotFound {
+		t.Errorf("renew with invalid token must fail: %v", err)
+	}
+	if err := l.Release(l2.WorkerID, l2.Token); err != nil {
+		t.Fatal(err)
+	}
+	if l3, err := l.Lease(nil); err != nil {
+		t.Error(err)
+	} else if l3.WorkerID != l2.WorkerID {
+		t.Errorf("released worker id %d must be leased again: %d", l2.WorkerID, l3.WorkerID)
+	}
+
+	// l1 is expired but kept until ReuseDelay passes
+	time.Sleep(300 * time.Millisecond)
+	if _, err := l.Renew(l1.WorkerID, l1.Token); err != ErrWorkerLeaseNotFound {
+		t.Errorf("expired lease must not be renewed: %v", err)
+	}
+	if _, err := l.Lease(nil); err == nil {
+		t.Error("expired worker id must not be leased before ReuseDelay")
+	}
+	time.Sleep(200 * time.Millisecond)
+	if _, err := l.Lease(nil); err != nil {
+		t.Errorf("expired worker id must be leased after ReuseDelay: %s", err)
+	}
+}
+
+func TestWorkerLeaserRegisterer(t *testing.T) {
+	db := openTestSQLite(t)
+	newLeaser := func() *WorkerLeaser {
+		l, err := NewWorkerLeaser(1000, 1001, time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.Registerer = newTestSQLAllocator(t, db, 1, 10)
+		return l
+	}
+	// two servers lease the same range
+	la, lb := newLeaser(), newLeaser()
+	l1, err := la.Lease(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l2, err := lb.Lease(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l1.WorkerID == l2.WorkerID {
+		t.Errorf("worker id %d is leased twice by servers", l1.WorkerID)
+	}
+	if _, err := la.Lease(nil); err == nil {
+		t.Error("lease must fail when all worker ids are leased by servers")
+	}
+
+	// the restarted server has lost the leases in memory
+	lc := newLeaser()
+	if _, err := lc.Lease(nil); err == nil {
+		t.Error("lease must fail when all worker ids are held in the backend")
+	}
+	if err := lb.Release(l2.WorkerID, l2.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lb.Renew(l2.WorkerID, l2.Token); err != ErrWorkerLeaseNotFound {
+		t.Errorf("released lease must not be renewed: %v", err)
+	}
+	var l3 *WorkerLease
+	waitFor(t, func() bool {
+		l3, err = lc.Lease(nil)
+		return err == nil
+	}, "released worker id must be leased by another server")
+	if l3.WorkerID != l2.WorkerID {
+		t.Errorf("released worker id %d must be leased again: %d", l2.WorkerID, l3.WorkerID)
+	}
+}
+
+// blockingRegisterer blocks Register until unblocked while blocking is set.
+type blockingRegisterer struct {
+	WorkerIDRegisterer
+	blocking int32
+	unblock  chan struct{}
+}
+
+func (r *blockingRegisterer) Register(ctx context.Context, id uint) (<-chan error, error) {
+	if atomic.LoadInt32(&r.blocking) == 1 {
+		<-r.unblock
+	}
+	return holdUntilDone(ctx), nil
+}
+
+func TestWorkerLeaserSlowRegisterer(t *testing.T) {
+	l, err := NewWorkerLeaser(1000, 1001, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &blockingRegisterer{unblock: make(chan struct{})}
+	l.Registerer = r
+	l1, err := l.Lease(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	atomic.StoreInt32(&r.blocking, 1)
+	leased := make(chan *WorkerLease, 1)
+	go func() {
+		l2, err := l.Lease(nil)
+		if err != nil {
+			t.Error(err)
+		}
+		leased <- l2
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// not blocked by the lease waiting for the backend
+	renewed := make(chan error, 1)
+	go func() {
+		_, err := l.Renew(l1.WorkerID, l1.Token)
+		renewed <- err
+	}()
+	select {
+	case err := <-renewed:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("renew must not wait for the backend")
+	}
+	if _, err := l.Renew(1001, ""); err != ErrWorkerLeaseNotFound {
+		t.Errorf("reserved worker id must not be renewed: %v", err)
+	}
+
+	close(r.unblock)
+	if l2 := <-leased; l2 == nil || l2.WorkerID == l1.WorkerID {
+		t.Errorf("unexpected lease %v", l2)
+	}
+}
+
+func newTestWorkerLeaseApp(t *testing.T, ctx context.Context, ttl time.Duration) *App {
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	l, err := NewWorkerLeaser(1010, 1012, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetWorkerLeaser(l)
+	return app
+}
+
+func testLeasedGenerator(t *testing.T, ctx context.Context, c WorkerLeaseClient) {
+	genCtx, cancel := context.WithCancel(ctx)
+	g, err := NewLeasedGenerator(genCtx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := g.WorkerID(); id < 1010 || 1012 < id {
+		t.Errorf("unexpected worker id %d", id)
+	}
+	var last uint64
+	// generates IDs over the TTL by renewing the lease
+	for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+		id, err := g.NextID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, wid, _ := Dump(id); uint(wid) != g.WorkerID() {
+			t.Errorf("unexpected worker id of %d", id)
+		}
+		if id <= last {
+			t.Errorf("id %d must be larger than %d", id, last)
+		}
+		last = id
+	}
+
+	cancel()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := g.NextID(); !errors.Is(err, ErrWorkerLeaseExpired) {
+		t.Errorf("released generator must not generate ids: %v", err)
+	}
+}
+
+func TestLeasedGeneratorMemcache(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestWorkerLeaseApp(t, ctx, 300*time.Millisecond)
+	testLeasedGenerator(t, ctx, NewClient(app.Listener.Addr().String()))
+}
+
+func TestLeasedGeneratorHTTP(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestWorkerLeaseApp(t, ctx, 300*time.Millisecond)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunHTTPServer(ctx, &Config{HTTPListener: l})
+
+	c, err := NewHTTPClient([]string{"http://" + l.Addr().String()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLeasedGenerator(t, ctx, c)
+}
+
+func TestLeasedGeneratorGRPC(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestWorkerLeaseApp(t, ctx, 300*time.Millisecond)
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunGRPCServer(ctx, &Config{GRPCListener: l})
+
+	conn, err := gogrpc.Dial(l.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	testLeasedGenerator(t, ctx, NewGRPCWorkerLeaseClient(conn))
+}
+
+func TestWorkerLeaseAuth(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestWorkerLeaseApp(t, ctx, time.Second)
+	app.SetSASLAuth(NewSASLAuth(map[string]string{"alice": "secret"}))
+	app.SetRateLimiter(newTestRateLimiter(t, RateLimitRule{By: RateLimitByAddr, Rate: 0.001, Burst: 2}))
+
+	hl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunHTTPServer(ctx, &Config{HTTPListener: hl})
+	hc, err := NewHTTPClient([]string{"http://" + hl.Addr().String()}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gl, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.RunGRPCServer(ctx, &Config{GRPCListener: gl})
+	conn, err := gogrpc.Dial(gl.Addr().String(), gogrpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	gc := NewGRPCWorkerLeaseClient(conn)
+
+	for name, c := range map[string]interface {
+		WorkerLeaseClient
+		SetBasicAuth(user, password string)
+	}{"http": hc, "grpc": gc} {
+		if _, err := c.LeaseWorker(ctx); err == nil {
+			t.Errorf("%s: lease without credentials must fail", name)
+		}
+		c.SetBasicAuth("alice", "wrong")
+		if _, err := c.LeaseWorker(ctx); err == nil {
+			t.Errorf("%s: lease with wrong credentials must fail", name)
+		}
+		c.SetBasicAuth("alice", "secret")
+		if _, err := c.LeaseWorker(ctx); err != nil {
+			t.Errorf("%s: lease with credentials must succeed: %s", name, err)
+		}
+	}
+	// the burst is consumed by the leases above
+	if _, err := hc.LeaseWorker(ctx); err == nil {
+		t.Error("lease over the rate limit must fail")
+	}
+}
+
+// unavailableLeaseClient leases a worker ID but fails to renew it.
+type unavailableLeaseClient struct {
+	WorkerLeaseClient
+}
+
+func (c unavailableLeaseClient) RenewWorker(ctx context.Context, l *WorkerLease) (*WorkerLease, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestLeasedGeneratorLapse(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestWorkerLeaseApp(t, ctx, 300*time.Millisecond)
+	c := unavailableLeaseClient{NewClient(app.Listener.Addr().String())}
+	g, err := NewLeasedGenerator(ctx, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.NextID(); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(400 * time.Millisecond)
+	if _, err := g.NextID(); !errors.Is(err, ErrWorkerLeaseExpired) {
+		t.Errorf("generator must stop when the lease is lapsed: %v", err)
+	}
+}
+
+func TestWorkerLeaseDisabled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	app := newTestAppAndListenTCP(ctx, t, nil)
+	c := NewClient(app.Listener.Addr().String())
+	if _, err := c.LeaseWorker(ctx); err == nil {
+		t.Error("lease must fail when worker lease is disabled")
+	}
+}